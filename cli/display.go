@@ -30,6 +30,10 @@ var (
 	queriesList []string
 	listMode    bool
 	dnsServer   string
+	upstreams   = make(map[string]*dns.UpstreamInfo)
+	upstreamL   []string
+	blacklist   *dns.BlacklistInfo
+	limited     int
 )
 
 // Init initializes the display in raw mode.
@@ -108,6 +112,9 @@ func EventHandler(ch chan dns.Event) {
 
 	for event := range ch {
 		label := event.Labels.String()
+		if event.CNAME != nil {
+			label += " \u2192 " + event.CNAME.String()
+		}
 
 		switch event.Type {
 		case dns.EventQuery:
@@ -133,7 +140,21 @@ func EventHandler(ch chan dns.Event) {
 			}
 
 		case dns.EventConfig:
-			dnsServer = label
+			if event.Blacklist != nil {
+				blacklist = event.Blacklist
+			} else {
+				dnsServer = label
+			}
+
+		case dns.EventUpstream:
+			_, ok := upstreams[event.Upstream.Name]
+			if !ok {
+				upstreamL = append(upstreamL, event.Upstream.Name)
+			}
+			upstreams[event.Upstream.Name] = event.Upstream
+
+		case dns.EventRateLimit:
+			limited++
 		}
 		printStats(os.Stdout, width, bHeight, qHeight, blocked, queries,
 			blockedList, queriesList, countBlocks, countQueries)
@@ -164,7 +185,17 @@ func printStats(out io.Writer, w, bHeight, qHeight int, b, q map[string]int,
 		printMap(out, w, bHeight+3, qHeight, q)
 	}
 
-	statusLine(out, bHeight+2+qHeight+1, w, fmt.Sprintf("DNS: %s", dnsServer))
+	status := fmt.Sprintf("DNS: %s", dnsServer)
+	if blacklist != nil {
+		status += fmt.Sprintf(", blacklist %s", blacklist)
+	}
+	if limited > 0 {
+		status += fmt.Sprintf(", rate limited %d", limited)
+	}
+	for _, name := range upstreamL {
+		status += fmt.Sprintf(", %s", upstreams[name])
+	}
+	statusLine(out, bHeight+2+qHeight+1, w, status)
 }
 
 func printMap(out io.Writer, w, row, height int, stats map[string]int) {
@@ -0,0 +1,86 @@
+//
+// main.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Compiles blacklists into a binary blacklist index.
+//
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/markkurossi/vpn/dns"
+)
+
+func main() {
+	output := flag.String("o", "", "Output index file")
+	format := flag.String("format", "auto",
+		"Blacklist format: auto, glob, hosts, adblock, dnsmasq, or rpz")
+	allowlist := flag.String("allowlist", "", "Allowlist file")
+	flag.Parse()
+
+	if len(*output) == 0 || len(flag.Args()) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: blcompile -o INDEX [options] LIST...\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	listFormat, err := dns.ParseListFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var rules []dns.Rule
+	for _, arg := range flag.Args() {
+		r, err := dns.ReadBlacklistFormat(arg, listFormat)
+		if err != nil {
+			var listErr *dns.ListError
+			if !errors.As(err, &listErr) {
+				log.Fatal(err)
+			}
+			log.Printf("%s", err)
+		}
+		fmt.Printf("%s: %d rules\n", arg, len(r))
+		rules = append(rules, r...)
+	}
+	if len(*allowlist) > 0 {
+		r, err := dns.ReadAllowlist(*allowlist)
+		if err != nil {
+			var listErr *dns.ListError
+			if !errors.As(err, &listErr) {
+				log.Fatal(err)
+			}
+			log.Printf("%s", err)
+		}
+		fmt.Printf("%s: %d rules\n", *allowlist, len(r))
+		rules = append(rules, r...)
+	}
+
+	// Write the index into a temporary file and rename it so that
+	// the running proxies never see partially written indices.
+	tmp := *output + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = dns.WriteIndex(f, rules)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		log.Fatal(err)
+	}
+	err = os.Rename(tmp, *output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %d rules\n", *output, len(rules))
+}
@@ -0,0 +1,214 @@
+//
+// main.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Searches and aggregates the proxy query logs.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/markkurossi/vpn/dns"
+)
+
+var keys = map[string]func(rec *dns.LogRecord) string{
+	"name": func(rec *dns.LogRecord) string {
+		return rec.Name
+	},
+	"domain": func(rec *dns.LogRecord) string {
+		return dns.NewLabels(rec.Name).Domain()
+	},
+	"client": func(rec *dns.LogRecord) string {
+		return rec.Client
+	},
+	"type": func(rec *dns.LogRecord) string {
+		return rec.Type
+	},
+	"decision": func(rec *dns.LogRecord) string {
+		return rec.Decision.String()
+	},
+	"rule": func(rec *dns.LogRecord) string {
+		return rec.Rule
+	},
+	"upstream": func(rec *dns.LogRecord) string {
+		return rec.Upstream
+	},
+	"rcode": func(rec *dns.LogRecord) string {
+		return rec.RCode
+	},
+}
+
+func keyNames() string {
+	var names []string
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+type filter struct {
+	name     dns.Labels
+	client   string
+	qtype    string
+	decision *dns.Decision
+	rcode    string
+}
+
+func (f *filter) match(rec *dns.LogRecord) bool {
+	if f.name != nil && !dns.NewLabels(rec.Name).Match(f.name) {
+		return false
+	}
+	if len(f.client) > 0 && rec.Client != f.client {
+		return false
+	}
+	if len(f.qtype) > 0 && !strings.EqualFold(rec.Type, f.qtype) {
+		return false
+	}
+	if f.decision != nil && rec.Decision != *f.decision {
+		return false
+	}
+	if len(f.rcode) > 0 && !strings.EqualFold(rec.RCode, f.rcode) {
+		return false
+	}
+	return true
+}
+
+type group struct {
+	key     string
+	count   int
+	latency time.Duration
+	timed   int
+}
+
+func main() {
+	since := flag.Duration("since", 0,
+		"Include records from the duration, e.g. 24h (default all)")
+	name := flag.String("name", "", "Name pattern, e.g. '**.example.com'")
+	client := flag.String("client", "", "Client address")
+	qtype := flag.String("type", "", "Query type")
+	decision := flag.String("decision", "",
+		"Decision: forwarded, blocked, cached, passthrough, local, "+
+			"limited, dropped, stale")
+	rcode := flag.String("rcode", "", "Response code")
+	top := flag.Int("top", 0, "Aggregate the top N values of the -by key")
+	by := flag.String("by", "name", "Aggregation key: "+keyNames())
+	flag.Parse()
+
+	if len(flag.Args()) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: querylog [options] LOG\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var f filter
+	if len(*name) > 0 {
+		f.name = dns.NewLabels(strings.ToLower(*name))
+	}
+	f.client = *client
+	f.qtype = *qtype
+	f.rcode = *rcode
+	if len(*decision) > 0 {
+		d, err := dns.ParseDecision(*decision)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f.decision = &d
+	}
+	key, ok := keys[*by]
+	if !ok {
+		log.Fatalf("unknown aggregation key '%s', expected %s", *by,
+			keyNames())
+	}
+
+	var start time.Time
+	if *since > 0 {
+		start = time.Now().Add(-*since)
+	}
+
+	groups := make(map[string]*group)
+	var total int
+
+	err := dns.ReadQueryLog(flag.Args()[0], start,
+		func(rec *dns.LogRecord) error {
+			if !f.match(rec) {
+				return nil
+			}
+			total++
+			if *top <= 0 {
+				printRecord(rec)
+				return nil
+			}
+			k := key(rec)
+			g, ok := groups[k]
+			if !ok {
+				g = &group{
+					key: k,
+				}
+				groups[k] = g
+			}
+			g.count++
+			if rec.Latency > 0 {
+				g.latency += rec.Latency
+				g.timed++
+			}
+			return nil
+		})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *top <= 0 {
+		return
+	}
+
+	var result []*group
+	for _, g := range groups {
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].count == result[j].count {
+			return result[i].key < result[j].key
+		}
+		return result[i].count > result[j].count
+	})
+	if len(result) > *top {
+		result = result[:*top]
+	}
+	for _, g := range result {
+		key := g.key
+		if len(key) == 0 {
+			key = "-"
+		}
+		fmt.Printf("%8d %5.1f%%  %s", g.count,
+			float64(g.count)/float64(total)*100, key)
+		if g.timed > 0 {
+			fmt.Printf(" (avg %s)",
+				(g.latency / time.Duration(g.timed)).Round(time.Microsecond))
+		}
+		fmt.Println()
+	}
+}
+
+func printRecord(rec *dns.LogRecord) {
+	fmt.Printf("%s %s %s %s %s %s",
+		rec.Time.Local().Format(time.DateTime), rec.Client, rec.Decision,
+		rec.Name, rec.Type, rec.RCode)
+	if len(rec.Upstream) > 0 {
+		fmt.Printf(" %s %s", rec.Upstream, rec.Latency.Round(time.Microsecond))
+	}
+	if len(rec.Rule) > 0 {
+		fmt.Printf(" (%s)", rec.Rule)
+	}
+	fmt.Println()
+}
@@ -10,31 +10,164 @@ package dns
 
 import (
 	"bufio"
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
 )
 
-// ReadBlacklist reads the blacklist from the file.
-func ReadBlacklist(name string) ([]Labels, error) {
+// AllowPrefix defines the blacklist exception rule prefix.
+const AllowPrefix = "@@"
+
+// Rule defines a blacklist rule.
+type Rule struct {
+	Pattern Labels
+	// Allow specifies if the rule is an exception rule that allows
+	// the matching names.
+	Allow bool
+	// Response defines the response for the blocked names. If nil,
+	// the proxy's default block response is used.
+	Response *BlockResponse
+}
+
+func (r Rule) String() string {
+	if r.Allow {
+		return AllowPrefix + r.Pattern.String()
+	}
+	if r.Response != nil {
+		return fmt.Sprintf("%s %s", r.Pattern, r.Response)
+	}
+	return r.Pattern.String()
+}
+
+// MatchRule finds the rule for the labels. If multiple rules match
+// the labels, the most specific rule wins, and allow rules win block
+// rules with equal specificity. The function returns nil if no rules
+// match the labels.
+func MatchRule(rules []Rule, labels Labels) *Rule {
+	var best *Rule
+	var bestSpecificity int
+
+	for i := range rules {
+		rule := &rules[i]
+		if !labels.Match(rule.Pattern) {
+			continue
+		}
+		specificity := rule.Pattern.Specificity()
+		if best == nil || specificity > bestSpecificity ||
+			(specificity == bestSpecificity && rule.Allow && !best.Allow) {
+			best = rule
+			bestSpecificity = specificity
+		}
+	}
+	return best
+}
+
+// ListError reports the unparseable entries of a blacklist file.
+type ListError struct {
+	Name  string
+	Lines []int
+	// Err is the error of the first unparseable entry.
+	Err error
+}
+
+// MaxListErrorLines defines how many line numbers ListError reports
+// in its error message.
+const MaxListErrorLines = 10
+
+func (e *ListError) Error() string {
+	msg := fmt.Sprintf("%s:%d: %s", e.Name, e.Lines[0], e.Err)
+	if len(e.Lines) == 1 {
+		return msg
+	}
+	var lines []string
+	for idx, line := range e.Lines {
+		if idx >= MaxListErrorLines {
+			lines = append(lines, "...")
+			break
+		}
+		lines = append(lines, strconv.Itoa(line))
+	}
+	return fmt.Sprintf("%s (%d unparseable entries at lines %s)",
+		msg, len(e.Lines), strings.Join(lines, ","))
+}
+
+// ReadBlacklist reads the blacklist from the file. The blacklist
+// format is detected from the file content, see ReadBlacklistFormat.
+func ReadBlacklist(name string) ([]Rule, error) {
+	return ReadBlacklistFormat(name, FormatAuto)
+}
+
+// ReadBlacklistFormat reads the blacklist in the format from the
+// file. If the format is FormatAuto, the format is detected from the
+// file content. In the glob format, each line of the file contains a
+// name pattern and an optional block response, see
+// ParseBlockResponse, and the lines starting with the AllowPrefix
+// define exception rules.
+//
+// The function skips the unparseable entries and returns the parsed
+// rules with a *ListError reporting the unparseable lines.
+func ReadBlacklistFormat(name string, format ListFormat) ([]Rule, error) {
+	return readRules(name, format, false)
+}
+
+// ReadAllowlist reads the allowlist from the file. Each line of the
+// file contains a name pattern that is allowed even if it matches
+// blacklist rules.
+func ReadAllowlist(name string) ([]Rule, error) {
+	return readRules(name, FormatGlob, true)
+}
+
+func readRules(name string, format ListFormat, allow bool) ([]Rule, error) {
+	if format == FormatIndex {
+		return nil, fmt.Errorf("%s: index files must be opened with OpenIndex",
+			name)
+	}
 	file, err := os.Open(name)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	var result []Labels
-
+	var lines []string
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if len(line) == 0 {
-			continue
-		}
-		if line[0] == '#' {
+		lines = append(lines, scanner.Text())
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return parseRules(name, lines, format, allow)
+}
+
+func parseRules(name string, lines []string, format ListFormat,
+	allow bool) ([]Rule, error) {
+
+	if format == FormatAuto {
+		format = DetectListFormat(lines)
+	}
+	parser := newLineParser(format, allow)
+
+	var result []Rule
+	var listErr *ListError
+
+	for idx, line := range lines {
+		rules, err := parser(line)
+		if err != nil {
+			if listErr == nil {
+				listErr = &ListError{
+					Name: name,
+					Err:  err,
+				}
+			}
+			listErr.Lines = append(listErr.Lines, idx+1)
 			continue
 		}
-
-		result = append(result, strings.Split(line, "."))
+		result = append(result, rules...)
+	}
+	if listErr != nil {
+		return result, listErr
 	}
-	return result, scanner.Err()
+	return result, nil
 }
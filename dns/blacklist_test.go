@@ -0,0 +1,41 @@
+//
+// blacklist_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"testing"
+)
+
+func TestMatchRule(t *testing.T) {
+	rules := []Rule{
+		{Pattern: NewLabels("*.doubleclick.net")},
+		{Pattern: NewLabels("pubads.g.doubleclick.net"), Allow: true},
+		{Pattern: NewLabels("**.example.com"), Allow: true},
+		{Pattern: NewLabels("**.example.com")},
+		{Pattern: NewLabels("ads.example.com")},
+	}
+	tests := []struct {
+		name    string
+		blocked bool
+	}{
+		{"ad.doubleclick.net", true},
+		{"pubads.g.doubleclick.net", false},
+		{"www.example.com", false},
+		{"ads.example.com", true},
+		{"www.example.org", false},
+	}
+	for _, test := range tests {
+		rule := MatchRule(rules, NewLabels(test.name))
+		blocked := rule != nil && !rule.Allow
+		if blocked != test.blocked {
+			t.Errorf("%s: blocked=%v, expected %v (%v)", test.name, blocked,
+				test.blocked, rule)
+		}
+	}
+}
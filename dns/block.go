@@ -0,0 +1,185 @@
+//
+// block.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// DefaultBlockTTL defines the default TTL of the block answers.
+const DefaultBlockTTL = 60
+
+// BlockMode defines how blocked names are answered.
+type BlockMode int
+
+// Block modes.
+const (
+	BlockNXDomain BlockMode = iota
+	BlockNoData
+	BlockRefused
+	BlockNullIP
+	BlockSinkhole
+	BlockDrop
+)
+
+var blockModes = map[BlockMode]string{
+	BlockNXDomain: "nxdomain",
+	BlockNoData:   "nodata",
+	BlockRefused:  "refused",
+	BlockNullIP:   "null",
+	BlockSinkhole: "sinkhole",
+	BlockDrop:     "drop",
+}
+
+func (m BlockMode) String() string {
+	name, ok := blockModes[m]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{BlockMode %d}", m)
+}
+
+// BlockResponse defines the response for blocked names.
+type BlockResponse struct {
+	Mode BlockMode
+	// IPv4 and IPv6 define the sinkhole addresses.
+	IPv4 net.IP
+	IPv6 net.IP
+	// TTL defines the TTL of the null IP and sinkhole answers. If
+	// zero, the TTL of the proxy's default block response is used.
+	TTL uint32
+}
+
+func (b *BlockResponse) String() string {
+	if b.Mode != BlockSinkhole {
+		return b.Mode.String()
+	}
+	var addrs []string
+	if b.IPv4 != nil {
+		addrs = append(addrs, b.IPv4.String())
+	}
+	if b.IPv6 != nil {
+		addrs = append(addrs, b.IPv6.String())
+	}
+	return strings.Join(addrs, ",")
+}
+
+// ParseBlockResponse parses the block response specification. The
+// specification is one of the block mode names nxdomain, nodata,
+// refused, null, and drop, or a comma separated list of IPv4 and IPv6
+// sinkhole addresses.
+func ParseBlockResponse(spec string) (*BlockResponse, error) {
+	result := new(BlockResponse)
+	for mode, name := range blockModes {
+		if mode != BlockSinkhole && name == strings.ToLower(spec) {
+			result.Mode = mode
+			return result, nil
+		}
+	}
+	result.Mode = BlockSinkhole
+	for _, addr := range strings.Split(spec, ",") {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid block response: %s", spec)
+		}
+		if ip.To4() != nil {
+			result.IPv4 = ip.To4()
+		} else {
+			result.IPv6 = ip
+		}
+	}
+	return result, nil
+}
+
+// block writes the block response for the query. The rule response
+// overrides the proxy's default block response.
+func (p *Proxy) block(packet gopacket.Packet, q *layers.DNS,
+	rule *Rule) error {
+
+	resp := rule.Response
+	if resp == nil {
+		resp = p.Block
+	}
+	if resp != nil && resp.Mode == BlockDrop {
+		return p.drop(packet, q, rule)
+	}
+	rcode, answers := p.blockResponse(q, rule)
+	p.logQuery(packet, q, DecisionBlocked, rule, rcode, nil)
+	return p.synthesize(packet, q, rcode, answers)
+}
+
+// drop drops the query without a response.
+func (p *Proxy) drop(packet gopacket.Packet, q *layers.DNS,
+	rule *Rule) error {
+
+	p.logQuery(packet, q, DecisionDropped, rule, 0, nil)
+	return nil
+}
+
+// blockResponse returns the response code and answers for the
+// blocked query.
+func (p *Proxy) blockResponse(q *layers.DNS, rule *Rule) (
+	layers.DNSResponseCode, []layers.DNSResourceRecord) {
+
+	resp := rule.Response
+	if resp == nil {
+		resp = p.Block
+	}
+	if resp == nil {
+		return layers.DNSResponseCodeNXDomain, nil
+	}
+
+	switch resp.Mode {
+	case BlockNXDomain:
+		return layers.DNSResponseCodeNXDomain, nil
+
+	case BlockRefused:
+		return layers.DNSResponseCodeRefused, nil
+
+	case BlockNoData:
+		return layers.DNSResponseCodeNoErr, nil
+	}
+
+	ipv4, ipv6 := resp.IPv4, resp.IPv6
+	if resp.Mode == BlockNullIP {
+		ipv4, ipv6 = net.IPv4zero.To4(), net.IPv6zero
+	}
+	ttl := resp.TTL
+	if ttl == 0 && p.Block != nil {
+		ttl = p.Block.TTL
+	}
+	if ttl == 0 {
+		ttl = DefaultBlockTTL
+	}
+
+	var answers []layers.DNSResourceRecord
+	for _, question := range q.Questions {
+		rr := layers.DNSResourceRecord{
+			Name:  question.Name,
+			Type:  question.Type,
+			Class: question.Class,
+			TTL:   ttl,
+		}
+		switch question.Type {
+		case layers.DNSTypeA:
+			rr.IP = ipv4
+		case layers.DNSTypeAAAA:
+			rr.IP = ipv6
+		}
+		if rr.IP != nil {
+			answers = append(answers, rr)
+		}
+	}
+	return layers.DNSResponseCodeNoErr, answers
+}
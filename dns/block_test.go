@@ -0,0 +1,107 @@
+//
+// block_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func TestParseBlockResponse(t *testing.T) {
+	for mode, name := range blockModes {
+		if mode == BlockSinkhole {
+			continue
+		}
+		resp, err := ParseBlockResponse(name)
+		if err != nil {
+			t.Fatalf("ParseBlockResponse(%s): %s", name, err)
+		}
+		if resp.Mode != mode {
+			t.Errorf("ParseBlockResponse(%s): mode %s", name, resp.Mode)
+		}
+	}
+	resp, err := ParseBlockResponse("192.0.2.1,2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Mode != BlockSinkhole ||
+		!resp.IPv4.Equal(net.IPv4(192, 0, 2, 1)) ||
+		!resp.IPv6.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("invalid sinkhole response: %v", resp)
+	}
+	_, err = ParseBlockResponse("servfail")
+	if err == nil {
+		t.Errorf("invalid block response accepted")
+	}
+}
+
+func TestProxyBlock(t *testing.T) {
+	proxy, out := newTestProxy(t)
+	sinkhole, _ := ParseBlockResponse("192.0.2.53")
+	proxy.Block = &BlockResponse{
+		Mode: BlockNoData,
+		TTL:  300,
+	}
+	proxy.SetBlacklist([]Rule{
+		{
+			Pattern: NewLabels("*.ads.example.com"),
+		},
+		{
+			Pattern:  NewLabels("tracker.example.com"),
+			Response: sinkhole,
+		},
+		{
+			Pattern: NewLabels("drop.example.com"),
+			Response: &BlockResponse{
+				Mode: BlockDrop,
+			},
+		},
+	})
+
+	packet, query := testQuery(t, 1, "x.ads.example.com", layers.DNSTypeA)
+	if err := proxy.Query(packet, query); err != nil {
+		t.Fatal(err)
+	}
+	resp := out.response(t)
+	if resp.ResponseCode != layers.DNSResponseCodeNoErr ||
+		len(resp.Answers) != 0 {
+		t.Errorf("unexpected NODATA response: %v %v", resp.ResponseCode,
+			resp.Answers)
+	}
+
+	packet, query = testQuery(t, 2, "tracker.example.com", layers.DNSTypeA)
+	if err := proxy.Query(packet, query); err != nil {
+		t.Fatal(err)
+	}
+	resp = out.response(t)
+	if len(resp.Answers) != 1 || resp.Answers[0].TTL != 300 ||
+		!resp.Answers[0].IP.Equal(net.IPv4(192, 0, 2, 53)) {
+		t.Errorf("unexpected sinkhole response: %v", resp.Answers)
+	}
+
+	packet, query = testQuery(t, 3, "tracker.example.com",
+		layers.DNSTypeAAAA)
+	if err := proxy.Query(packet, query); err != nil {
+		t.Fatal(err)
+	}
+	resp = out.response(t)
+	if len(resp.Answers) != 0 {
+		t.Errorf("unexpected sinkhole AAAA response: %v", resp.Answers)
+	}
+
+	packet, query = testQuery(t, 4, "drop.example.com", layers.DNSTypeA)
+	if err := proxy.Query(packet, query); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 0 {
+		t.Errorf("dropped query answered")
+	}
+}
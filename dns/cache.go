@@ -0,0 +1,313 @@
+//
+// cache.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// Cache limits.
+const (
+	CacheMaxTTL = 24 * 60 * 60
+	// StaleTTL defines the TTL of the stale answers (RFC 8767 section
+	// 4).
+	StaleTTL = 30
+	// DefaultPrefetchThreshold defines the default fraction of the
+	// TTL below which the popular entries are prefetched.
+	DefaultPrefetchThreshold = 0.1
+)
+
+// Cache implements a bounded DNS response cache. The cached
+// responses honor the TTLs of their resource records and negative
+// responses are cached as specified in RFC 2308. The expired
+// responses are kept for the Stale duration so that they can be
+// served when the upstreams are unreachable (RFC 8767). The entries
+// that have at least PrefetchHits hits are prefetched when their
+// remaining TTL drops below the PrefetchThreshold fraction of their
+// TTL.
+type Cache struct {
+	Stale             time.Duration
+	PrefetchHits      int
+	PrefetchThreshold float64
+	m                 sync.Mutex
+	size              int
+	lru               *list.List
+	entries           map[cacheKey]*list.Element
+}
+
+type cacheKey struct {
+	name  string
+	qtype layers.DNSType
+	class layers.DNSClass
+}
+
+func newCacheKey(q layers.DNSQuestion) cacheKey {
+	return cacheKey{
+		name:  strings.ToLower(string(q.Name)),
+		qtype: q.Type,
+		class: q.Class,
+	}
+}
+
+type cacheEntry struct {
+	key        cacheKey
+	dns        *layers.DNS
+	created    time.Time
+	expires    time.Time
+	hits       int
+	prefetched bool
+}
+
+// NewCache creates a new cache holding at most size responses.
+func NewCache(size int) *Cache {
+	return &Cache{
+		PrefetchThreshold: DefaultPrefetchThreshold,
+		size:              size,
+		lru:               list.New(),
+		entries:           make(map[cacheKey]*list.Element),
+	}
+}
+
+// Len returns the number of cached responses.
+func (c *Cache) Len() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.lru.Len()
+}
+
+// Flush removes all responses from the cache.
+func (c *Cache) Flush() {
+	c.m.Lock()
+	c.lru.Init()
+	c.entries = make(map[cacheKey]*list.Element)
+	c.m.Unlock()
+}
+
+// Get returns the cached response for the question. The TTLs of the
+// returned response are decremented by the time the response has
+// been in the cache. The function returns nil if the question does
+// not have a valid cached response.
+func (c *Cache) Get(q layers.DNSQuestion) *layers.DNS {
+	return c.get(q, time.Now())
+}
+
+func (c *Cache) get(q layers.DNSQuestion, now time.Time) *layers.DNS {
+	dns, _ := c.lookup(q, now)
+	return dns
+}
+
+// Lookup returns the cached response for the question like Get. The
+// function also returns true if the entry should be prefetched. The
+// prefetch is signaled only once for each entry.
+func (c *Cache) Lookup(q layers.DNSQuestion) (*layers.DNS, bool) {
+	return c.lookup(q, time.Now())
+}
+
+func (c *Cache) lookup(q layers.DNSQuestion, now time.Time) (
+	*layers.DNS, bool) {
+
+	key := newCacheKey(q)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		if !entry.stale(now, c.Stale) {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+		}
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+
+	entry.hits++
+	var prefetch bool
+	if c.PrefetchHits > 0 && entry.hits >= c.PrefetchHits &&
+		!entry.prefetched {
+		ttl := entry.expires.Sub(entry.created)
+		threshold := time.Duration(float64(ttl) * c.PrefetchThreshold)
+		if entry.expires.Sub(now) < threshold {
+			entry.prefetched = true
+			prefetch = true
+		}
+	}
+
+	age := uint32(now.Sub(entry.created) / time.Second)
+
+	result := *entry.dns
+	result.Answers = ageRRs(entry.dns.Answers, age)
+	result.Authorities = ageRRs(entry.dns.Authorities, age)
+	result.Additionals = ageRRs(entry.dns.Additionals, age)
+
+	return &result, prefetch
+}
+
+// GetStale returns the expired response for the question if it is
+// still within the stale window. The TTLs of the returned response
+// are set to StaleTTL. The function returns nil if the question does
+// not have a stale response.
+func (c *Cache) GetStale(q layers.DNSQuestion) *layers.DNS {
+	return c.getStale(q, time.Now())
+}
+
+func (c *Cache) getStale(q layers.DNSQuestion, now time.Time) *layers.DNS {
+	key := newCacheKey(q)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	if now.Before(entry.expires) || !entry.stale(now, c.Stale) {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+
+	result := *entry.dns
+	result.Answers = staleRRs(entry.dns.Answers)
+	result.Authorities = staleRRs(entry.dns.Authorities)
+	result.Additionals = staleRRs(entry.dns.Additionals)
+
+	return &result
+}
+
+// stale tests if the expired entry is within the stale window at
+// now.
+func (entry *cacheEntry) stale(now time.Time, window time.Duration) bool {
+	return now.Before(entry.expires.Add(window))
+}
+
+func staleRRs(rrs []layers.DNSResourceRecord) []layers.DNSResourceRecord {
+	if len(rrs) == 0 {
+		return nil
+	}
+	result := make([]layers.DNSResourceRecord, len(rrs))
+	copy(result, rrs)
+	for i := range result {
+		if result[i].Type != layers.DNSTypeOPT {
+			result[i].TTL = StaleTTL
+		}
+	}
+	return result
+}
+
+func ageRRs(rrs []layers.DNSResourceRecord,
+	age uint32) []layers.DNSResourceRecord {
+
+	if len(rrs) == 0 {
+		return nil
+	}
+	result := make([]layers.DNSResourceRecord, len(rrs))
+	copy(result, rrs)
+	for i := range result {
+		if result[i].Type == layers.DNSTypeOPT {
+			continue
+		}
+		if result[i].TTL > age {
+			result[i].TTL -= age
+		} else {
+			result[i].TTL = 0
+		}
+	}
+	return result
+}
+
+// Put adds the response to the cache. Responses that are not
+// cacheable are ignored.
+func (c *Cache) Put(dns *layers.DNS) {
+	c.put(dns, time.Now())
+}
+
+func (c *Cache) put(dns *layers.DNS, now time.Time) {
+	if c.size <= 0 || len(dns.Questions) != 1 || dns.TC {
+		return
+	}
+	ttl, ok := cacheTTL(dns)
+	if !ok || ttl == 0 {
+		return
+	}
+	key := newCacheKey(dns.Questions[0])
+	entry := &cacheEntry{
+		key:     key,
+		dns:     dns,
+		created: now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.size {
+		elem := c.lru.Back()
+		c.lru.Remove(elem)
+		delete(c.entries, elem.Value.(*cacheEntry).key)
+	}
+}
+
+// cacheTTL computes the cache lifetime of the response in
+// seconds. Positive responses are cached for the minimum TTL of
+// their answers. Negative responses (NXDOMAIN and NODATA) are cached
+// for the minimum of the SOA record's TTL and its MINIMUM field (RFC
+// 2308 section 5).
+func cacheTTL(dns *layers.DNS) (uint32, bool) {
+	var ttl uint32 = CacheMaxTTL
+
+	switch dns.ResponseCode {
+	case layers.DNSResponseCodeNoErr:
+		if len(dns.Answers) > 0 {
+			for _, rr := range dns.Answers {
+				if rr.TTL < ttl {
+					ttl = rr.TTL
+				}
+			}
+			return ttl, true
+		}
+		fallthrough
+
+	case layers.DNSResponseCodeNXDomain:
+		for _, rr := range dns.Authorities {
+			if rr.Type != layers.DNSTypeSOA {
+				continue
+			}
+			if rr.TTL < ttl {
+				ttl = rr.TTL
+			}
+			if rr.SOA.Minimum < ttl {
+				ttl = rr.SOA.Minimum
+			}
+			return ttl, true
+		}
+		// Negative responses without SOA are not cached.
+		return 0, false
+
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,271 @@
+//
+// cache_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func question(name string, t layers.DNSType) layers.DNSQuestion {
+	return layers.DNSQuestion{
+		Name:  []byte(name),
+		Type:  t,
+		Class: layers.DNSClassIN,
+	}
+}
+
+func soa(ttl, minimum uint32) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{
+		Name:  []byte("example.com"),
+		Type:  layers.DNSTypeSOA,
+		Class: layers.DNSClassIN,
+		TTL:   ttl,
+		SOA: layers.DNSSOA{
+			MName:   []byte("ns.example.com"),
+			RName:   []byte("hostmaster.example.com"),
+			Minimum: minimum,
+		},
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	cache := NewCache(10)
+	now := time.Now()
+
+	q := question("www.example.com", layers.DNSTypeA)
+	cache.put(&layers.DNS{
+		QR:        true,
+		Questions: []layers.DNSQuestion{q},
+		Answers: []layers.DNSResourceRecord{
+			{
+				Name:  q.Name,
+				Type:  layers.DNSTypeA,
+				Class: layers.DNSClassIN,
+				TTL:   300,
+				IP:    net.IPv4(192, 0, 2, 1),
+			},
+			{
+				Name:  q.Name,
+				Type:  layers.DNSTypeA,
+				Class: layers.DNSClassIN,
+				TTL:   60,
+				IP:    net.IPv4(192, 0, 2, 2),
+			},
+		},
+	}, now)
+
+	resp := cache.get(question("WWW.Example.COM", layers.DNSTypeA),
+		now.Add(20*time.Second))
+	if resp == nil {
+		t.Fatalf("cache miss")
+	}
+	if resp.Answers[0].TTL != 280 || resp.Answers[1].TTL != 40 {
+		t.Errorf("TTLs not decremented: %d %d",
+			resp.Answers[0].TTL, resp.Answers[1].TTL)
+	}
+	if cache.get(question("www.example.com", layers.DNSTypeAAAA),
+		now) != nil {
+		t.Errorf("cache hit for wrong type")
+	}
+	if cache.get(q, now.Add(60*time.Second)) != nil {
+		t.Errorf("cache hit after minimum TTL")
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	cache := NewCache(10)
+	now := time.Now()
+
+	nx := question("nx.example.com", layers.DNSTypeA)
+	cache.put(&layers.DNS{
+		QR:           true,
+		ResponseCode: layers.DNSResponseCodeNXDomain,
+		Questions:    []layers.DNSQuestion{nx},
+		Authorities:  []layers.DNSResourceRecord{soa(3600, 30)},
+	}, now)
+
+	if cache.get(nx, now.Add(29*time.Second)) == nil {
+		t.Errorf("NXDOMAIN not cached")
+	}
+	if cache.get(nx, now.Add(30*time.Second)) != nil {
+		t.Errorf("NXDOMAIN cached longer than SOA minimum")
+	}
+
+	nodata := question("www.example.com", layers.DNSTypeAAAA)
+	cache.put(&layers.DNS{
+		QR:          true,
+		Questions:   []layers.DNSQuestion{nodata},
+		Authorities: []layers.DNSResourceRecord{soa(10, 300)},
+	}, now)
+	if cache.get(nodata, now.Add(9*time.Second)) == nil {
+		t.Errorf("NODATA not cached")
+	}
+	if cache.get(nodata, now.Add(10*time.Second)) != nil {
+		t.Errorf("NODATA cached longer than SOA TTL")
+	}
+
+	nosoa := question("nosoa.example.com", layers.DNSTypeA)
+	cache.put(&layers.DNS{
+		QR:           true,
+		ResponseCode: layers.DNSResponseCodeNXDomain,
+		Questions:    []layers.DNSQuestion{nosoa},
+	}, now)
+	if cache.get(nosoa, now) != nil {
+		t.Errorf("negative response without SOA cached")
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache := NewCache(2)
+	now := time.Now()
+
+	names := []string{"a.example.com", "b.example.com", "c.example.com"}
+	for i, name := range names {
+		cache.put(&layers.DNS{
+			QR:          true,
+			Questions:   []layers.DNSQuestion{question(name, layers.DNSTypeA)},
+			Authorities: []layers.DNSResourceRecord{soa(60, 60)},
+		}, now)
+		if i == 1 {
+			// Make a.example.com most recently used.
+			cache.get(question(names[0], layers.DNSTypeA), now)
+		}
+	}
+	if cache.Len() != 2 {
+		t.Fatalf("cache size %d, expected 2", cache.Len())
+	}
+	if cache.get(question(names[1], layers.DNSTypeA), now) != nil {
+		t.Errorf("least recently used entry not evicted")
+	}
+	if cache.get(question(names[0], layers.DNSTypeA), now) == nil {
+		t.Errorf("recently used entry evicted")
+	}
+}
+
+func TestCacheStale(t *testing.T) {
+	cache := NewCache(10)
+	cache.Stale = time.Hour
+	now := time.Now()
+
+	q := question("www.example.com", layers.DNSTypeA)
+	cache.put(&layers.DNS{
+		QR:        true,
+		Questions: []layers.DNSQuestion{q},
+		Answers: []layers.DNSResourceRecord{
+			{
+				Name:  q.Name,
+				Type:  layers.DNSTypeA,
+				Class: layers.DNSClassIN,
+				TTL:   60,
+				IP:    net.IPv4(192, 0, 2, 1),
+			},
+		},
+	}, now)
+
+	if cache.getStale(q, now.Add(30*time.Second)) != nil {
+		t.Errorf("stale response for valid entry")
+	}
+	if cache.get(q, now.Add(61*time.Second)) != nil {
+		t.Errorf("cache hit after TTL")
+	}
+	resp := cache.getStale(q, now.Add(61*time.Second))
+	if resp == nil {
+		t.Fatalf("stale entry removed")
+	}
+	if resp.Answers[0].TTL != StaleTTL {
+		t.Errorf("stale TTL %d, expected %d", resp.Answers[0].TTL, StaleTTL)
+	}
+	if cache.getStale(q, now.Add(2*time.Hour)) != nil {
+		t.Errorf("stale response after stale window")
+	}
+	cache.get(q, now.Add(2*time.Hour))
+	if cache.Len() != 0 {
+		t.Errorf("expired entry not removed")
+	}
+}
+
+func TestCachePrefetch(t *testing.T) {
+	cache := NewCache(10)
+	cache.PrefetchHits = 2
+	now := time.Now()
+
+	q := question("www.example.com", layers.DNSTypeA)
+	cache.put(&layers.DNS{
+		QR:        true,
+		Questions: []layers.DNSQuestion{q},
+		Answers: []layers.DNSResourceRecord{
+			{
+				Name:  q.Name,
+				Type:  layers.DNSTypeA,
+				Class: layers.DNSClassIN,
+				TTL:   100,
+				IP:    net.IPv4(192, 0, 2, 1),
+			},
+		},
+	}, now)
+
+	if _, prefetch := cache.lookup(q, now.Add(95*time.Second)); prefetch {
+		t.Errorf("prefetch before hits threshold")
+	}
+	if _, prefetch := cache.lookup(q, now.Add(50*time.Second)); prefetch {
+		t.Errorf("prefetch before TTL threshold")
+	}
+	if _, prefetch := cache.lookup(q, now.Add(95*time.Second)); !prefetch {
+		t.Errorf("popular entry not prefetched")
+	}
+	if _, prefetch := cache.lookup(q, now.Add(96*time.Second)); prefetch {
+		t.Errorf("prefetch signaled twice")
+	}
+}
+
+func TestProxyCacheDNSSEC(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, true))
+	proxy.Cache = NewCache(10)
+
+	q := question("www.example.com", layers.DNSTypeA)
+	proxy.Cache.Put(&layers.DNS{
+		QR:        true,
+		Questions: []layers.DNSQuestion{q},
+		Answers: []layers.DNSResourceRecord{
+			testA("www.example.com", "192.0.2.1"),
+			testRR("www.example.com", dnsTypeRRSIG, []byte{0, 1}),
+		},
+	})
+
+	// The DNSSEC records are not returned to the queries without the
+	// DO bit.
+	packet, query := testQuery(t, 1, "www.example.com", layers.DNSTypeA)
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := out.response(t)
+	if len(resp.Answers) != 1 || resp.Answers[0].Type != layers.DNSTypeA {
+		t.Errorf("unexpected answers: %v", resp.Answers)
+	}
+
+	response := &layers.DNS{
+		Answers: []layers.DNSResourceRecord{
+			testRR("www.example.com", dnsTypeRRSIG, []byte{0, 1}),
+		},
+	}
+	query.Additionals = append(query.Additionals, layers.DNSResourceRecord{
+		Type:  layers.DNSTypeOPT,
+		Class: 4096,
+		TTL:   ednsDO,
+	})
+	stripUnsolicitedDNSSEC(response, query)
+	if len(response.Answers) != 1 {
+		t.Errorf("DNSSEC records stripped from DO query")
+	}
+}
@@ -48,7 +48,7 @@ func NewUDPClient(server string, c chan []byte) (*UDPClient, error) {
 }
 
 func (dns *UDPClient) reader() error {
-	var buf [1500]byte
+	var buf [65535]byte
 	for {
 		n, err := dns.Conn.Read(buf[:])
 		if err != nil {
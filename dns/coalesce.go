@@ -0,0 +1,118 @@
+//
+// coalesce.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// In-flight query coalescing.
+//
+
+package dns
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// queryKey identifies the identical upstream queries. The queries
+// are identical if they have the same question and the same DNSSEC
+// and client subnet options, and they are sent to the same pool.
+type queryKey struct {
+	name  string
+	qtype layers.DNSType
+	class layers.DNSClass
+	do    bool
+	cd    bool
+	ecs   string
+	pool  *Pool
+}
+
+func (key *queryKey) String() string {
+	return fmt.Sprintf("%s %s %s", key.name, key.qtype, key.class)
+}
+
+// newQueryKey creates the query key for the upstream query. The
+// function returns nil if the query can't be coalesced.
+func newQueryKey(dns *layers.DNS, pool *Pool) *queryKey {
+	if len(dns.Questions) != 1 {
+		return nil
+	}
+	q := dns.Questions[0]
+	key := &queryKey{
+		name:  strings.ToLower(string(q.Name)),
+		qtype: q.Type,
+		class: q.Class,
+		cd:    dns.Z&dnsFlagCD != 0,
+		pool:  pool,
+	}
+	for _, rr := range dns.Additionals {
+		if rr.Type != layers.DNSTypeOPT {
+			continue
+		}
+		key.do = rr.TTL&ednsDO != 0
+		for _, o := range rr.OPT {
+			if o.Code == layers.DNSOptionCodeEDNSClientSubnet {
+				key.ecs = string(o.Data)
+			}
+		}
+	}
+	return key
+}
+
+// coalesce adds the pending query to the identical in-flight query
+// if there is one. The function returns true if the query was added,
+// or if the prefetch query is not needed. Otherwise the pending query
+// becomes the in-flight query that the identical queries are added
+// to.
+func (p *Proxy) coalesce(pending *Pending, key *queryKey) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	leader, ok := p.queries[*key]
+	if ok {
+		if pending.prefetch {
+			// The in-flight query refreshes the cache.
+			return true
+		}
+		leader.followers = append(leader.followers, pending)
+		pending.stale = leader.stale
+		return true
+	}
+	pending.key = key
+	p.queries[*key] = pending
+	return false
+}
+
+// uncoalesce removes the pending query from the in-flight queries so
+// that no more queries are added to it. The proxy mutex must be
+// held.
+func (p *Proxy) uncoalesce(pending *Pending) {
+	if pending.key != nil && p.queries[*pending.key] == pending {
+		delete(p.queries, *pending.key)
+	}
+}
+
+// fanout writes the upstream response to the followers of the
+// pending query. Each follower gets its own copy of the response
+// since the response is rewritten for each client.
+func (p *Proxy) fanout(pending *Pending, dns *layers.DNS) {
+	for _, follower := range pending.followers {
+		packet := gopacket.NewPacket(dns.Contents, layers.LayerTypeDNS,
+			decodeOptions)
+		layer := packet.Layer(layers.LayerTypeDNS)
+		if layer == nil {
+			log.Printf("Proxy: can't copy response\n")
+			return
+		}
+		response := layer.(*layers.DNS)
+		// The validator sets the AD bit.
+		response.Z = dns.Z
+		follower.upstream = pending.upstream
+		p.respond(follower, response)
+	}
+}
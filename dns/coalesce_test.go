@@ -0,0 +1,150 @@
+//
+// coalesce_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+func TestQueryKey(t *testing.T) {
+	pool := NewPool(StrategyOrder)
+	_, a := testQuery(t, 1, "www.example.com", layers.DNSTypeA)
+	_, b := testQuery(t, 2, "WWW.Example.com", layers.DNSTypeA)
+	_, c := testQuery(t, 3, "www.example.com", layers.DNSTypeAAAA)
+
+	if *newQueryKey(a, pool) != *newQueryKey(b, pool) {
+		t.Errorf("keys differ by name case")
+	}
+	if *newQueryKey(a, pool) == *newQueryKey(c, pool) {
+		t.Errorf("keys equal for different types")
+	}
+	if *newQueryKey(a, pool) == *newQueryKey(a, NewPool(StrategyOrder)) {
+		t.Errorf("keys equal for different pools")
+	}
+	setDO(b)
+	if *newQueryKey(a, pool) == *newQueryKey(b, pool) {
+		t.Errorf("keys equal for different DO bits")
+	}
+	c.Questions = append(c.Questions, c.Questions[0])
+	if newQueryKey(c, pool) != nil {
+		t.Errorf("key for multiple questions")
+	}
+}
+
+func TestProxyCoalesce(t *testing.T) {
+	release := make(chan struct{})
+	server := &testServer{}
+	server.handler = func(q *layers.DNS) []byte {
+		<-release
+		resp := &layers.DNS{
+			ID:        q.ID,
+			QR:        true,
+			OpCode:    q.OpCode,
+			RD:        q.RD,
+			RA:        true,
+			Questions: q.Questions,
+			Answers: []layers.DNSResourceRecord{
+				{
+					Name:  q.Questions[0].Name,
+					Type:  layers.DNSTypeA,
+					Class: layers.DNSClassIN,
+					TTL:   60,
+					IP:    net.IPv4(192, 0, 2, 1),
+				},
+			},
+		}
+		buffer := gopacket.NewSerializeBuffer()
+		err := gopacket.SerializeLayers(buffer, serializeOptions, resp)
+		if err != nil {
+			return nil
+		}
+		return buffer.Bytes()
+	}
+	startTestServer(t, server)
+	proxy, out := newTestProxy(t, server)
+
+	const count = 5
+	for i := 0; i < count; i++ {
+		packet, query := testClientQuery(t, layers.UDPPort(40000+i),
+			uint16(100+i), "www.example.com", layers.DNSTypeA)
+		err := proxy.Query(packet, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := proxy.inFlight(); n != 1 {
+		t.Errorf("%d upstream queries in flight, expected 1", n)
+	}
+	close(release)
+
+	seen := make(map[layers.UDPPort]bool)
+	for i := 0; i < count; i++ {
+		var data []byte
+		select {
+		case data = <-out:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d responses, expected %d", i, count)
+		}
+		packet := gopacket.NewPacket(data, layers.LayerTypeIPv4,
+			gopacket.Default)
+		udp := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		dns := packet.Layer(layers.LayerTypeDNS).(*layers.DNS)
+		port := udp.DstPort
+		if seen[port] {
+			t.Errorf("duplicate response to port %d", port)
+		}
+		seen[port] = true
+		if dns.ID != uint16(100+int(port)-40000) {
+			t.Errorf("port %d: got ID %d", port, dns.ID)
+		}
+		if len(dns.Answers) != 1 || !dns.Answers[0].IP.Equal(
+			net.IPv4(192, 0, 2, 1)) {
+			t.Errorf("port %d: unexpected answers %v", port, dns.Answers)
+		}
+	}
+	if len(server.queries) != 1 {
+		t.Errorf("upstream got %d queries, expected 1", len(server.queries))
+	}
+
+	// The completed query is not coalesced with new queries.
+	packet, query := testQuery(t, 200, "www.example.com", layers.DNSTypeA)
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := out.response(t); resp.ID != 200 {
+		t.Errorf("got ID %d, expected 200", resp.ID)
+	}
+}
+
+func TestProxyCoalesceServFail(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, true))
+	proxy.Timeout = 50 * time.Millisecond
+	proxy.Retries = 0
+
+	for i := 0; i < 3; i++ {
+		packet, query := testQuery(t, uint16(i), "www.example.com",
+			layers.DNSTypeA)
+		err := proxy.Query(packet, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		resp := out.response(t)
+		if resp.ResponseCode != layers.DNSResponseCodeServFail {
+			t.Errorf("got response code %v", resp.ResponseCode)
+		}
+	}
+}
@@ -0,0 +1,791 @@
+//
+// dnssec.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// DNSSEC records and signatures (RFC 4034, RFC 5155).
+//
+
+package dns
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// DNSSEC record types. The gopacket layers package does not define
+// them.
+const (
+	dnsTypeDS     layers.DNSType = 43
+	dnsTypeRRSIG  layers.DNSType = 46
+	dnsTypeNSEC   layers.DNSType = 47
+	dnsTypeDNSKEY layers.DNSType = 48
+	dnsTypeNSEC3  layers.DNSType = 50
+)
+
+var dnssecTypes = map[layers.DNSType]string{
+	dnsTypeDS:     "DS",
+	dnsTypeRRSIG:  "RRSIG",
+	dnsTypeNSEC:   "NSEC",
+	dnsTypeDNSKEY: "DNSKEY",
+	dnsTypeNSEC3:  "NSEC3",
+}
+
+// typeString returns the name of the record type including the
+// DNSSEC types.
+func typeString(t layers.DNSType) string {
+	name, ok := dnssecTypes[t]
+	if ok {
+		return name
+	}
+	return t.String()
+}
+
+// DNS header flags in the Z field of layers.DNS.
+const (
+	dnsFlagAD uint8 = 0x2
+	dnsFlagCD uint8 = 0x1
+)
+
+// ednsDO defines the DNSSEC OK bit in the TTL field of the OPT
+// record (RFC 3225).
+const ednsDO = 0x8000
+
+// DNSKEY flags.
+const (
+	DNSKEYZone   = 0x0100
+	DNSKEYRevoke = 0x0080
+	DNSKEYSEP    = 0x0001
+)
+
+// Algorithm defines the DNSSEC signature algorithms.
+type Algorithm uint8
+
+// Supported DNSSEC algorithms.
+const (
+	AlgorithmRSASHA256       Algorithm = 8
+	AlgorithmECDSAP256SHA256 Algorithm = 13
+	AlgorithmECDSAP384SHA384 Algorithm = 14
+	AlgorithmEd25519         Algorithm = 15
+)
+
+var algorithms = map[Algorithm]string{
+	AlgorithmRSASHA256:       "RSASHA256",
+	AlgorithmECDSAP256SHA256: "ECDSAP256SHA256",
+	AlgorithmECDSAP384SHA384: "ECDSAP384SHA384",
+	AlgorithmEd25519:         "ED25519",
+}
+
+func (alg Algorithm) String() string {
+	name, ok := algorithms[alg]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{Algorithm %d}", alg)
+}
+
+// Supported tests if the algorithm is supported.
+func (alg Algorithm) Supported() bool {
+	_, ok := algorithms[alg]
+	return ok
+}
+
+// DigestType defines the DS digest algorithms.
+type DigestType uint8
+
+// Supported DS digest algorithms.
+const (
+	DigestSHA256 DigestType = 2
+	DigestSHA384 DigestType = 4
+)
+
+var digestTypes = map[DigestType]string{
+	DigestSHA256: "SHA-256",
+	DigestSHA384: "SHA-384",
+}
+
+func (t DigestType) String() string {
+	name, ok := digestTypes[t]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{DigestType %d}", t)
+}
+
+// Supported tests if the digest type is supported.
+func (t DigestType) Supported() bool {
+	_, ok := digestTypes[t]
+	return ok
+}
+
+func (t DigestType) digest(data []byte) ([]byte, error) {
+	switch t {
+	case DigestSHA256:
+		sum := sha256.Sum256(data)
+		return sum[:], nil
+	case DigestSHA384:
+		sum := sha512.Sum384(data)
+		return sum[:], nil
+	default:
+		return nil, fmt.Errorf("unsupported digest type %s", t)
+	}
+}
+
+// DNSKEY defines a DNSSEC public key record.
+type DNSKEY struct {
+	Name      string
+	TTL       uint32
+	Flags     uint16
+	Protocol  uint8
+	Algorithm Algorithm
+	PublicKey []byte
+}
+
+// ParseDNSKEY parses the DNSKEY resource record.
+func ParseDNSKEY(rr *layers.DNSResourceRecord) (*DNSKEY, error) {
+	if rr.Type != dnsTypeDNSKEY || len(rr.Data) < 4 {
+		return nil, fmt.Errorf("invalid DNSKEY record")
+	}
+	return &DNSKEY{
+		Name:      normalizeName(string(rr.Name)),
+		TTL:       rr.TTL,
+		Flags:     bo.Uint16(rr.Data),
+		Protocol:  rr.Data[2],
+		Algorithm: Algorithm(rr.Data[3]),
+		PublicKey: rr.Data[4:],
+	}, nil
+}
+
+func (key *DNSKEY) String() string {
+	return fmt.Sprintf("%s DNSKEY %d %s %d", key.Name, key.Flags, key.Algorithm,
+		key.KeyTag())
+}
+
+// RData returns the DNSKEY RDATA.
+func (key *DNSKEY) RData() []byte {
+	data := bo.AppendUint16(nil, key.Flags)
+	data = append(data, key.Protocol, byte(key.Algorithm))
+	return append(data, key.PublicKey...)
+}
+
+// KeyTag computes the key tag of the key (RFC 4034 appendix B).
+func (key *DNSKEY) KeyTag() uint16 {
+	var ac uint32
+	for i, b := range key.RData() {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xffff
+	return uint16(ac)
+}
+
+// DS creates the DS record for the key.
+func (key *DNSKEY) DS(digestType DigestType) (*DS, error) {
+	digest, err := digestType.digest(append(appendName(nil, key.Name),
+		key.RData()...))
+	if err != nil {
+		return nil, err
+	}
+	return &DS{
+		Name:       key.Name,
+		TTL:        key.TTL,
+		KeyTag:     key.KeyTag(),
+		Algorithm:  key.Algorithm,
+		DigestType: digestType,
+		Digest:     digest,
+	}, nil
+}
+
+// Usable tests if the key can be used for validating the zone's
+// signatures.
+func (key *DNSKEY) Usable() bool {
+	return key.Protocol == 3 && key.Flags&DNSKEYZone != 0 &&
+		key.Flags&DNSKEYRevoke == 0 && key.Algorithm.Supported()
+}
+
+// verify verifies the signature over the data.
+func (key *DNSKEY) verify(data, sig []byte) error {
+	switch key.Algorithm {
+	case AlgorithmRSASHA256:
+		pub, err := rsaPublicKey(key.PublicKey)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+
+	case AlgorithmECDSAP256SHA256:
+		digest := sha256.Sum256(data)
+		return verifyECDSA(elliptic.P256(), key.PublicKey, digest[:], sig)
+
+	case AlgorithmECDSAP384SHA384:
+		digest := sha512.Sum384(data)
+		return verifyECDSA(elliptic.P384(), key.PublicKey, digest[:], sig)
+
+	case AlgorithmEd25519:
+		if len(key.PublicKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid %s public key", key.Algorithm)
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key.PublicKey), data, sig) {
+			return errors.New("verification error")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported algorithm %s", key.Algorithm)
+	}
+}
+
+// rsaPublicKey decodes the RSA public key (RFC 3110 section 2).
+func rsaPublicKey(data []byte) (*rsa.PublicKey, error) {
+	if len(data) < 3 {
+		return nil, errors.New("invalid RSA public key")
+	}
+	expLen := int(data[0])
+	data = data[1:]
+	if expLen == 0 {
+		expLen = int(bo.Uint16(data))
+		data = data[2:]
+	}
+	if expLen == 0 || expLen > 4 || len(data) <= expLen {
+		return nil, errors.New("invalid RSA public key")
+	}
+	var exp int
+	for _, b := range data[:expLen] {
+		exp = exp<<8 | int(b)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(data[expLen:]),
+		E: exp,
+	}, nil
+}
+
+// verifyECDSA verifies the ECDSA signature (RFC 6605 section 4).
+func verifyECDSA(curve elliptic.Curve, pub, digest, sig []byte) error {
+	size := (curve.Params().BitSize + 7) / 8
+	if len(pub) != 2*size {
+		return errors.New("invalid ECDSA public key")
+	}
+	if len(sig) != 2*size {
+		return errors.New("invalid ECDSA signature")
+	}
+	key := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(pub[:size]),
+		Y:     new(big.Int).SetBytes(pub[size:]),
+	}
+	r := new(big.Int).SetBytes(sig[:size])
+	s := new(big.Int).SetBytes(sig[size:])
+	if !ecdsa.Verify(key, digest, r, s) {
+		return errors.New("verification error")
+	}
+	return nil
+}
+
+// DS defines a delegation signer record.
+type DS struct {
+	Name       string
+	TTL        uint32
+	KeyTag     uint16
+	Algorithm  Algorithm
+	DigestType DigestType
+	Digest     []byte
+}
+
+// ParseDS parses the DS resource record.
+func ParseDS(rr *layers.DNSResourceRecord) (*DS, error) {
+	if rr.Type != dnsTypeDS || len(rr.Data) < 5 {
+		return nil, fmt.Errorf("invalid DS record")
+	}
+	return &DS{
+		Name:       normalizeName(string(rr.Name)),
+		TTL:        rr.TTL,
+		KeyTag:     bo.Uint16(rr.Data),
+		Algorithm:  Algorithm(rr.Data[2]),
+		DigestType: DigestType(rr.Data[3]),
+		Digest:     rr.Data[4:],
+	}, nil
+}
+
+func (ds *DS) String() string {
+	return fmt.Sprintf("%s DS %d %s %s", ds.Name, ds.KeyTag, ds.Algorithm,
+		ds.DigestType)
+}
+
+// RData returns the DS RDATA.
+func (ds *DS) RData() []byte {
+	data := bo.AppendUint16(nil, ds.KeyTag)
+	data = append(data, byte(ds.Algorithm), byte(ds.DigestType))
+	return append(data, ds.Digest...)
+}
+
+// Supported tests if the algorithm and digest type of the DS record
+// are supported.
+func (ds *DS) Supported() bool {
+	return ds.Algorithm.Supported() && ds.DigestType.Supported()
+}
+
+// Match tests if the DS record identifies the key.
+func (ds *DS) Match(key *DNSKEY) bool {
+	if key.Name != ds.Name || key.Algorithm != ds.Algorithm ||
+		key.KeyTag() != ds.KeyTag {
+		return false
+	}
+	keyDS, err := key.DS(ds.DigestType)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(keyDS.Digest, ds.Digest)
+}
+
+// RRSIG defines a resource record signature.
+type RRSIG struct {
+	Name        string
+	TypeCovered layers.DNSType
+	Algorithm   Algorithm
+	Labels      uint8
+	OrigTTL     uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+	Signature   []byte
+}
+
+// ParseRRSIG parses the RRSIG resource record.
+func ParseRRSIG(rr *layers.DNSResourceRecord) (*RRSIG, error) {
+	data := rr.Data
+	if rr.Type != dnsTypeRRSIG || len(data) < 18 {
+		return nil, fmt.Errorf("invalid RRSIG record")
+	}
+	signer, ofs, err := readName(data, 18)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RRSIG record: %s", err)
+	}
+	return &RRSIG{
+		Name:        normalizeName(string(rr.Name)),
+		TypeCovered: layers.DNSType(bo.Uint16(data)),
+		Algorithm:   Algorithm(data[2]),
+		Labels:      data[3],
+		OrigTTL:     bo.Uint32(data[4:]),
+		Expiration:  bo.Uint32(data[8:]),
+		Inception:   bo.Uint32(data[12:]),
+		KeyTag:      bo.Uint16(data[16:]),
+		SignerName:  signer,
+		Signature:   data[ofs:],
+	}, nil
+}
+
+func (sig *RRSIG) String() string {
+	return fmt.Sprintf("%s RRSIG %s %s %d %s", sig.Name,
+		typeString(sig.TypeCovered), sig.Algorithm, sig.KeyTag, sig.SignerName)
+}
+
+// RData returns the RRSIG RDATA.
+func (sig *RRSIG) RData() []byte {
+	return append(sig.appendFields(nil), sig.Signature...)
+}
+
+// appendFields appends the RRSIG RDATA fields without the signature.
+func (sig *RRSIG) appendFields(data []byte) []byte {
+	data = bo.AppendUint16(data, uint16(sig.TypeCovered))
+	data = append(data, byte(sig.Algorithm), sig.Labels)
+	data = bo.AppendUint32(data, sig.OrigTTL)
+	data = bo.AppendUint32(data, sig.Expiration)
+	data = bo.AppendUint32(data, sig.Inception)
+	data = bo.AppendUint16(data, sig.KeyTag)
+	return appendName(data, sig.SignerName)
+}
+
+// Valid tests if the time is in the signature validity period. The
+// times are compared with serial number arithmetic (RFC 4034 section
+// 3.1.5).
+func (sig *RRSIG) Valid(now time.Time) bool {
+	t := uint32(now.Unix())
+	return int32(t-sig.Inception) >= 0 && int32(sig.Expiration-t) >= 0
+}
+
+// Wildcard tests if the signature covers an RRset that was
+// synthesized from a wildcard (RFC 4035 section 5.3.4).
+func (sig *RRSIG) Wildcard() bool {
+	labels := nameLabels(sig.Name)
+	if len(labels) > 0 && labels[0] == "*" {
+		return false
+	}
+	return int(sig.Labels) < len(labels)
+}
+
+// Verify verifies the signature over the RRset with the key. The
+// RRset must have the owner name, type, and class of the signature.
+func (sig *RRSIG) Verify(key *DNSKEY, rrset []layers.DNSResourceRecord) error {
+	if key.Name != sig.SignerName || key.Algorithm != sig.Algorithm ||
+		key.KeyTag() != sig.KeyTag {
+		return fmt.Errorf("key %s does not match signature", key)
+	}
+	if !key.Usable() {
+		return fmt.Errorf("key %s is not usable", key)
+	}
+	data, err := sig.signedData(rrset)
+	if err != nil {
+		return err
+	}
+	return key.verify(data, sig.Signature)
+}
+
+// signedData creates the data that the signature signs (RFC 4034
+// section 3.1.8.1).
+func (sig *RRSIG) signedData(rrset []layers.DNSResourceRecord) ([]byte, error) {
+	if len(rrset) == 0 {
+		return nil, errors.New("empty RRset")
+	}
+	owner := sig.Name
+	labels := nameLabels(owner)
+	if len(labels) > 0 && labels[0] == "*" {
+		labels = labels[1:]
+	}
+	if int(sig.Labels) > len(labels) {
+		return nil, fmt.Errorf("invalid RRSIG labels %d for %s", sig.Labels,
+			owner)
+	}
+	if int(sig.Labels) < len(labels) {
+		owner = strings.Join(append([]string{"*"},
+			labels[len(labels)-int(sig.Labels):]...), ".")
+	}
+	prefix := appendName(nil, owner)
+	prefix = bo.AppendUint16(prefix, uint16(sig.TypeCovered))
+	prefix = bo.AppendUint16(prefix, uint16(rrset[0].Class))
+	prefix = bo.AppendUint32(prefix, sig.OrigTTL)
+
+	var rdatas [][]byte
+	for i := range rrset {
+		rdatas = append(rdatas, canonicalRData(&rrset[i]))
+	}
+	sort.Slice(rdatas, func(i, j int) bool {
+		return bytes.Compare(rdatas[i], rdatas[j]) < 0
+	})
+
+	data := sig.appendFields(nil)
+	for i, rdata := range rdatas {
+		if i > 0 && bytes.Equal(rdata, rdatas[i-1]) {
+			continue
+		}
+		data = append(data, prefix...)
+		data = bo.AppendUint16(data, uint16(len(rdata)))
+		data = append(data, rdata...)
+	}
+	return data, nil
+}
+
+// canonicalRData returns the RDATA of the resource record in the
+// canonical format (RFC 4034 section 6.2). The domain names of the
+// well-known types are uncompressed and converted to lowercase.
+func canonicalRData(rr *layers.DNSResourceRecord) []byte {
+	var data []byte
+
+	switch rr.Type {
+	case layers.DNSTypeA:
+		ip := rr.IP.To4()
+		if ip == nil {
+			return rr.Data
+		}
+		return ip
+
+	case layers.DNSTypeAAAA:
+		ip := rr.IP.To16()
+		if ip == nil {
+			return rr.Data
+		}
+		return ip
+
+	case layers.DNSTypeNS:
+		return appendName(data, string(rr.NS))
+
+	case layers.DNSTypeCNAME:
+		return appendName(data, string(rr.CNAME))
+
+	case layers.DNSTypePTR:
+		return appendName(data, string(rr.PTR))
+
+	case layers.DNSTypeMX:
+		data = bo.AppendUint16(data, rr.MX.Preference)
+		return appendName(data, string(rr.MX.Name))
+
+	case layers.DNSTypeSRV:
+		data = bo.AppendUint16(data, rr.SRV.Priority)
+		data = bo.AppendUint16(data, rr.SRV.Weight)
+		data = bo.AppendUint16(data, rr.SRV.Port)
+		return appendName(data, string(rr.SRV.Name))
+
+	case layers.DNSTypeSOA:
+		data = appendName(data, string(rr.SOA.MName))
+		data = appendName(data, string(rr.SOA.RName))
+		data = bo.AppendUint32(data, rr.SOA.Serial)
+		data = bo.AppendUint32(data, rr.SOA.Refresh)
+		data = bo.AppendUint32(data, rr.SOA.Retry)
+		data = bo.AppendUint32(data, rr.SOA.Expire)
+		return bo.AppendUint32(data, rr.SOA.Minimum)
+
+	default:
+		return rr.Data
+	}
+}
+
+// nsec defines an NSEC record (RFC 4034 section 4).
+type nsec struct {
+	name  string
+	zone  string
+	next  string
+	types []layers.DNSType
+}
+
+func parseNSEC(rr *layers.DNSResourceRecord, zone string) (*nsec, error) {
+	next, ofs, err := readName(rr.Data, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NSEC record: %s", err)
+	}
+	types, err := parseTypeBitmap(rr.Data[ofs:])
+	if err != nil {
+		return nil, err
+	}
+	return &nsec{
+		name:  normalizeName(string(rr.Name)),
+		zone:  zone,
+		next:  next,
+		types: types,
+	}, nil
+}
+
+// covers tests if the NSEC record proves that the name does not
+// exist.
+func (n *nsec) covers(name string) bool {
+	if !inZone(name, n.zone) || name == n.name {
+		return false
+	}
+	if inZone(name, n.name) && hasType(n.types, layers.DNSTypeNS) &&
+		!hasType(n.types, layers.DNSTypeSOA) {
+		// The name is below a delegation point.
+		return false
+	}
+	after := compareNames(n.name, name) < 0
+	if compareNames(n.name, n.next) < 0 {
+		return after && compareNames(name, n.next) < 0
+	}
+	// The last NSEC record of the zone.
+	return after
+}
+
+// nsec3 defines an NSEC3 record (RFC 5155 section 3).
+type nsec3 struct {
+	name       string
+	zone       string
+	hash       []byte
+	flags      uint8
+	iterations uint16
+	salt       []byte
+	next       []byte
+	types      []layers.DNSType
+}
+
+// nsec3OptOut defines the NSEC3 Opt-Out flag.
+const nsec3OptOut = 0x01
+
+var nsec3Encoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+func parseNSEC3(rr *layers.DNSResourceRecord, zone string) (*nsec3, error) {
+	data := rr.Data
+	if len(data) < 5 || data[0] != 1 {
+		return nil, errors.New("invalid NSEC3 record")
+	}
+	ofs := 5 + int(data[4])
+	if len(data) < ofs+1 || len(data) < ofs+1+int(data[ofs]) {
+		return nil, errors.New("invalid NSEC3 record")
+	}
+	salt := data[5:ofs]
+	next := data[ofs+1 : ofs+1+int(data[ofs])]
+	types, err := parseTypeBitmap(data[ofs+1+len(next):])
+	if err != nil {
+		return nil, err
+	}
+
+	name := normalizeName(string(rr.Name))
+	labels := strings.SplitN(name, ".", 2)
+	if len(labels) != 2 || labels[1] != zone {
+		return nil, fmt.Errorf("NSEC3 %s not in zone %s", name, zone)
+	}
+	hash, err := nsec3Encoding.DecodeString(strings.ToUpper(labels[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid NSEC3 owner %s", name)
+	}
+	return &nsec3{
+		name:       name,
+		zone:       zone,
+		hash:       hash,
+		flags:      data[1],
+		iterations: bo.Uint16(data[2:]),
+		salt:       salt,
+		next:       next,
+		types:      types,
+	}, nil
+}
+
+// hashName computes the NSEC3 hash of the name (RFC 5155 section 5).
+func (n *nsec3) hashName(name string) []byte {
+	return nsec3Hash(name, n.salt, n.iterations)
+}
+
+func nsec3Hash(name string, salt []byte, iterations uint16) []byte {
+	h := sha1.Sum(append(appendName(nil, name), salt...))
+	for i := 0; i < int(iterations); i++ {
+		h = sha1.Sum(append(h[:], salt...))
+	}
+	return h[:]
+}
+
+// matches tests if the NSEC3 record is the record of the name.
+func (n *nsec3) matches(name string) bool {
+	return inZone(name, n.zone) && bytes.Equal(n.hashName(name), n.hash)
+}
+
+// covers tests if the NSEC3 record proves that the name does not
+// exist.
+func (n *nsec3) covers(name string) bool {
+	if !inZone(name, n.zone) {
+		return false
+	}
+	h := n.hashName(name)
+	after := bytes.Compare(n.hash, h) < 0
+	before := bytes.Compare(h, n.next) < 0
+	if bytes.Compare(n.hash, n.next) < 0 {
+		return after && before
+	}
+	// The last NSEC3 record of the zone.
+	return after || before
+}
+
+func (n *nsec3) optOut() bool {
+	return n.flags&nsec3OptOut != 0
+}
+
+// parseTypeBitmap parses the NSEC and NSEC3 type bitmaps (RFC 4034
+// section 4.1.2).
+func parseTypeBitmap(data []byte) ([]layers.DNSType, error) {
+	var types []layers.DNSType
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, errors.New("truncated type bitmap")
+		}
+		window := int(data[0])
+		length := int(data[1])
+		if length == 0 || length > 32 || len(data) < 2+length {
+			return nil, errors.New("invalid type bitmap")
+		}
+		for i, b := range data[2 : 2+length] {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					types = append(types,
+						layers.DNSType(window<<8|i*8+bit))
+				}
+			}
+		}
+		data = data[2+length:]
+	}
+	return types, nil
+}
+
+func hasType(types []layers.DNSType, t layers.DNSType) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+// appendName appends the name in the canonical wire format.
+func appendName(data []byte, name string) []byte {
+	for _, label := range nameLabels(normalizeName(name)) {
+		data = append(data, byte(len(label)))
+		data = append(data, label...)
+	}
+	return append(data, 0)
+}
+
+// readName reads an uncompressed name from the RDATA. The name is
+// returned in lowercase.
+func readName(data []byte, ofs int) (string, int, error) {
+	var labels []string
+	for {
+		if ofs >= len(data) {
+			return "", 0, errors.New("truncated name")
+		}
+		length := int(data[ofs])
+		ofs++
+		if length == 0 {
+			break
+		}
+		if length > 63 || ofs+length > len(data) {
+			return "", 0, errors.New("invalid name")
+		}
+		labels = append(labels, strings.ToLower(string(data[ofs:ofs+length])))
+		ofs += length
+	}
+	return strings.Join(labels, "."), ofs, nil
+}
+
+// nameLabels returns the labels of the normalized name. The root
+// name has no labels.
+func nameLabels(name string) []string {
+	if len(name) == 0 {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+// parentName returns the parent of the normalized name.
+func parentName(name string) string {
+	idx := strings.IndexByte(name, '.')
+	if idx < 0 {
+		return ""
+	}
+	return name[idx+1:]
+}
+
+// inZone tests if the normalized name is the zone or its subdomain.
+func inZone(name, zone string) bool {
+	return len(zone) == 0 || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// compareNames compares the normalized names in the canonical DNS
+// name order (RFC 4034 section 6.1).
+func compareNames(a, b string) int {
+	la := nameLabels(a)
+	lb := nameLabels(b)
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		c := strings.Compare(la[i], lb[j])
+		if c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
@@ -0,0 +1,488 @@
+//
+// dnssec_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// testKey is a signing key of a fixture zone.
+type testKey struct {
+	dnskey *DNSKEY
+	priv   crypto.Signer
+}
+
+func newTestKey(t *testing.T, zone string, alg Algorithm,
+	flags uint16) *testKey {
+
+	t.Helper()
+
+	var pub []byte
+	var priv crypto.Signer
+
+	switch alg {
+	case AlgorithmRSASHA256:
+		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := big.NewInt(int64(key.E)).Bytes()
+		pub = append([]byte{byte(len(exp))}, exp...)
+		pub = append(pub, key.N.Bytes()...)
+		priv = key
+
+	case AlgorithmECDSAP256SHA256, AlgorithmECDSAP384SHA384:
+		curve := elliptic.P256()
+		if alg == AlgorithmECDSAP384SHA384 {
+			curve = elliptic.P384()
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		pub = append(key.X.FillBytes(make([]byte, size)),
+			key.Y.FillBytes(make([]byte, size))...)
+		priv = key
+
+	case AlgorithmEd25519:
+		pk, sk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub = pk
+		priv = sk
+
+	default:
+		t.Fatalf("unsupported algorithm %s", alg)
+	}
+
+	return &testKey{
+		dnskey: &DNSKEY{
+			Name:      zone,
+			TTL:       3600,
+			Flags:     flags,
+			Protocol:  3,
+			Algorithm: alg,
+			PublicKey: pub,
+		},
+		priv: priv,
+	}
+}
+
+func (key *testKey) sign(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	switch priv := key.priv.(type) {
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256(data)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256,
+			digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+
+	case *ecdsa.PrivateKey:
+		var digest []byte
+		if key.dnskey.Algorithm == AlgorithmECDSAP384SHA384 {
+			sum := sha512.Sum384(data)
+			digest = sum[:]
+		} else {
+			sum := sha256.Sum256(data)
+			digest = sum[:]
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		return append(r.FillBytes(make([]byte, size)),
+			s.FillBytes(make([]byte, size))...)
+
+	case ed25519.PrivateKey:
+		return ed25519.Sign(priv, data)
+
+	default:
+		t.Fatalf("unsupported key %T", priv)
+		return nil
+	}
+}
+
+// testZone is a signed fixture zone. The zone's records are signed
+// with its ZSK and the DNSKEY RRset with its KSK.
+type testZone struct {
+	name string
+	ksk  *testKey
+	zsk  *testKey
+}
+
+func newTestZone(t *testing.T, name string, alg Algorithm,
+	split bool) *testZone {
+
+	z := &testZone{
+		name: name,
+		ksk:  newTestKey(t, name, alg, DNSKEYZone|DNSKEYSEP),
+	}
+	z.zsk = z.ksk
+	if split {
+		z.zsk = newTestKey(t, name, alg, DNSKEYZone)
+	}
+	return z
+}
+
+// sign returns the RRset with its signature.
+func (z *testZone) sign(t *testing.T,
+	rrs ...layers.DNSResourceRecord) []layers.DNSResourceRecord {
+
+	return z.signWith(t, z.zsk, rrs...)
+}
+
+func (z *testZone) signWith(t *testing.T, key *testKey,
+	rrs ...layers.DNSResourceRecord) []layers.DNSResourceRecord {
+
+	t.Helper()
+
+	owner := normalizeName(string(rrs[0].Name))
+	labels := nameLabels(owner)
+	if len(labels) > 0 && labels[0] == "*" {
+		labels = labels[1:]
+	}
+	now := time.Now()
+	sig := &RRSIG{
+		Name:        owner,
+		TypeCovered: rrs[0].Type,
+		Algorithm:   key.dnskey.Algorithm,
+		Labels:      uint8(len(labels)),
+		OrigTTL:     rrs[0].TTL,
+		Expiration:  uint32(now.Add(time.Hour).Unix()),
+		Inception:   uint32(now.Add(-time.Hour).Unix()),
+		KeyTag:      key.dnskey.KeyTag(),
+		SignerName:  z.name,
+	}
+	data, err := sig.signedData(rrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig.Signature = key.sign(t, data)
+
+	result := append([]layers.DNSResourceRecord(nil), rrs...)
+	return append(result, testRR(owner, dnsTypeRRSIG, sig.RData()))
+}
+
+// keys returns the signed DNSKEY response of the zone.
+func (z *testZone) keys(t *testing.T) *layers.DNS {
+	rrs := []layers.DNSResourceRecord{
+		testRR(z.name, dnsTypeDNSKEY, z.ksk.dnskey.RData()),
+	}
+	if z.zsk != z.ksk {
+		rrs = append(rrs, testRR(z.name, dnsTypeDNSKEY, z.zsk.dnskey.RData()))
+	}
+	return testResponse(z.name, dnsTypeDNSKEY, layers.DNSResponseCodeNoErr,
+		z.signWith(t, z.ksk, rrs...), nil)
+}
+
+// ds returns the DS record of the zone's KSK.
+func (z *testZone) ds(t *testing.T) layers.DNSResourceRecord {
+	ds, err := z.ksk.dnskey.DS(DigestSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testRR(z.name, dnsTypeDS, ds.RData())
+}
+
+func (z *testZone) soa(t *testing.T) []layers.DNSResourceRecord {
+	return z.sign(t, layers.DNSResourceRecord{
+		Name:  []byte(z.name),
+		Type:  layers.DNSTypeSOA,
+		Class: layers.DNSClassIN,
+		TTL:   300,
+		SOA: layers.DNSSOA{
+			MName:   []byte("ns." + z.name),
+			RName:   []byte("hostmaster." + z.name),
+			Serial:  1,
+			Refresh: 3600,
+			Retry:   600,
+			Expire:  86400,
+			Minimum: 300,
+		},
+	})
+}
+
+func (z *testZone) nsec(t *testing.T, owner, next string,
+	types ...layers.DNSType) []layers.DNSResourceRecord {
+
+	data := appendTypeBitmap(appendName(nil, next), types...)
+	return z.sign(t, testRR(owner, dnsTypeNSEC, data))
+}
+
+// NSEC3 parameters of the fixture zones.
+var (
+	testSalt       = []byte{0xaa, 0xbb, 0xcc, 0xdd}
+	testIterations = uint16(2)
+)
+
+func (z *testZone) nsec3(t *testing.T, hash, next []byte, flags uint8,
+	types ...layers.DNSType) []layers.DNSResourceRecord {
+
+	owner := strings.ToLower(nsec3Encoding.EncodeToString(hash)) + "." + z.name
+
+	data := []byte{1, flags}
+	data = bo.AppendUint16(data, testIterations)
+	data = append(data, byte(len(testSalt)))
+	data = append(data, testSalt...)
+	data = append(data, byte(len(next)))
+	data = append(data, next...)
+	data = appendTypeBitmap(data, types...)
+
+	return z.sign(t, testRR(owner, dnsTypeNSEC3, data))
+}
+
+// testHash computes the NSEC3 hash of the name with the fixture
+// parameters and returns the hash and its predecessor and
+// successor.
+func testHash(name string) (hash, prev, next []byte) {
+	hash = nsec3Hash(name, testSalt, testIterations)
+	h := new(big.Int).SetBytes(hash)
+	prev = new(big.Int).Sub(h, big.NewInt(1)).FillBytes(make([]byte, len(hash)))
+	next = new(big.Int).Add(h, big.NewInt(1)).FillBytes(make([]byte, len(hash)))
+	return
+}
+
+func testRR(name string, rtype layers.DNSType,
+	data []byte) layers.DNSResourceRecord {
+
+	return layers.DNSResourceRecord{
+		Name:  []byte(name),
+		Type:  rtype,
+		Class: layers.DNSClassIN,
+		TTL:   3600,
+		Data:  data,
+	}
+}
+
+func testA(name, ip string) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{
+		Name:  []byte(name),
+		Type:  layers.DNSTypeA,
+		Class: layers.DNSClassIN,
+		TTL:   3600,
+		IP:    net.ParseIP(ip).To4(),
+	}
+}
+
+func testResponse(name string, qtype layers.DNSType,
+	rcode layers.DNSResponseCode,
+	answers, authorities []layers.DNSResourceRecord) *layers.DNS {
+
+	return &layers.DNS{
+		QR:           true,
+		OpCode:       layers.DNSOpCodeQuery,
+		RD:           true,
+		RA:           true,
+		ResponseCode: rcode,
+		Questions: []layers.DNSQuestion{
+			{
+				Name:  []byte(name),
+				Type:  qtype,
+				Class: layers.DNSClassIN,
+			},
+		},
+		Answers:     answers,
+		Authorities: authorities,
+	}
+}
+
+func appendTypeBitmap(data []byte, types ...layers.DNSType) []byte {
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+	var windows [256][32]byte
+	var lengths [256]int
+	for _, t := range types {
+		window := int(t) >> 8
+		octet := int(t) & 0xff / 8
+		windows[window][octet] |= 0x80 >> (int(t) % 8)
+		if octet+1 > lengths[window] {
+			lengths[window] = octet + 1
+		}
+	}
+	for window, length := range lengths {
+		if length > 0 {
+			data = append(data, byte(window), byte(length))
+			data = append(data, windows[window][:length]...)
+		}
+	}
+	return data
+}
+
+// packTestMessage encodes the DNS message without name compression.
+// The gopacket layers package can't serialize the DNSSEC records.
+func packTestMessage(dns *layers.DNS) []byte {
+	data := bo.AppendUint16(nil, dns.ID)
+
+	var flags uint16
+	if dns.QR {
+		flags |= 0x8000
+	}
+	flags |= uint16(dns.OpCode) << 11
+	if dns.AA {
+		flags |= 0x0400
+	}
+	if dns.TC {
+		flags |= 0x0200
+	}
+	if dns.RD {
+		flags |= 0x0100
+	}
+	if dns.RA {
+		flags |= 0x0080
+	}
+	flags |= uint16(dns.Z)<<4 | uint16(dns.ResponseCode)
+	data = bo.AppendUint16(data, flags)
+
+	data = bo.AppendUint16(data, uint16(len(dns.Questions)))
+	data = bo.AppendUint16(data, uint16(len(dns.Answers)))
+	data = bo.AppendUint16(data, uint16(len(dns.Authorities)))
+	data = bo.AppendUint16(data, uint16(len(dns.Additionals)))
+
+	for _, q := range dns.Questions {
+		data = appendName(data, string(q.Name))
+		data = bo.AppendUint16(data, uint16(q.Type))
+		data = bo.AppendUint16(data, uint16(q.Class))
+	}
+	for _, rrs := range [][]layers.DNSResourceRecord{
+		dns.Answers, dns.Authorities, dns.Additionals,
+	} {
+		for _, rr := range rrs {
+			rdata := canonicalRData(&rr)
+			data = appendName(data, string(rr.Name))
+			data = bo.AppendUint16(data, uint16(rr.Type))
+			data = bo.AppendUint16(data, uint16(rr.Class))
+			data = bo.AppendUint32(data, rr.TTL)
+			data = bo.AppendUint16(data, uint16(len(rdata)))
+			data = append(data, rdata...)
+		}
+	}
+	return data
+}
+
+func decodeTestMessage(t *testing.T, data []byte) *layers.DNS {
+	t.Helper()
+	packet := gopacket.NewPacket(data, layers.LayerTypeDNS, gopacket.Default)
+	layer := packet.Layer(layers.LayerTypeDNS)
+	if layer == nil {
+		t.Fatalf("non-DNS message: %s", packet)
+	}
+	return layer.(*layers.DNS)
+}
+
+func TestNSEC3Hash(t *testing.T) {
+	// RFC 5155 appendix A.
+	salt, _ := hex.DecodeString("aabbccdd")
+	for name, expected := range map[string]string{
+		"example":   "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom",
+		"a.example": "35mthgpgcu1qg68fab165klnsnk3dpvl",
+	} {
+		hash := nsec3Hash(name, salt, 12)
+		got := strings.ToLower(nsec3Encoding.EncodeToString(hash))
+		if got != expected {
+			t.Errorf("NSEC3 hash of %s: got %s, expected %s", name, got,
+				expected)
+		}
+	}
+}
+
+func TestCompareNames(t *testing.T) {
+	// RFC 4034 section 6.1.
+	names := []string{
+		"example",
+		"a.example",
+		"yljkjljk.a.example",
+		"z.a.example",
+		"zabc.a.example",
+		"z.example",
+		"\x01.z.example",
+		"*.z.example",
+		"\x80.z.example",
+	}
+	for i := 1; i < len(names); i++ {
+		if compareNames(names[i-1], names[i]) >= 0 {
+			t.Errorf("%q >= %q", names[i-1], names[i])
+		}
+		if compareNames(names[i], names[i-1]) <= 0 {
+			t.Errorf("%q <= %q", names[i], names[i-1])
+		}
+	}
+}
+
+func TestDNSSECSignatures(t *testing.T) {
+	for _, alg := range []Algorithm{
+		AlgorithmRSASHA256,
+		AlgorithmECDSAP256SHA256,
+		AlgorithmECDSAP384SHA384,
+		AlgorithmEd25519,
+	} {
+		z := newTestZone(t, "example.test", alg, false)
+		signed := z.sign(t,
+			testA("www.example.test", "192.0.2.1"),
+			testA("www.example.test", "192.0.2.2"))
+
+		// Decode the records from the wire format.
+		resp := decodeTestMessage(t, packTestMessage(testResponse(
+			"www.example.test", layers.DNSTypeA, layers.DNSResponseCodeNoErr,
+			signed, nil)))
+
+		sig, err := ParseRRSIG(&resp.Answers[2])
+		if err != nil {
+			t.Fatalf("%s: %s", alg, err)
+		}
+		// The RRset order does not matter.
+		rrset := []layers.DNSResourceRecord{resp.Answers[1], resp.Answers[0]}
+		err = sig.Verify(z.ksk.dnskey, rrset)
+		if err != nil {
+			t.Errorf("%s: %s", alg, err)
+		}
+
+		rrset[0].IP = net.IPv4(192, 0, 2, 3)
+		err = sig.Verify(z.ksk.dnskey, rrset)
+		if err == nil {
+			t.Errorf("%s: modified RRset verified", alg)
+		}
+
+		ds, err := z.ksk.dnskey.DS(DigestSHA384)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ds.Match(z.ksk.dnskey) {
+			t.Errorf("%s: DS does not match key", alg)
+		}
+		ds.Digest[0] ^= 0xff
+		if ds.Match(z.ksk.dnskey) {
+			t.Errorf("%s: modified DS matches key", alg)
+		}
+	}
+}
@@ -0,0 +1,801 @@
+//
+// dnstap.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// dnstap output with Frame Streams framing.
+//
+
+package dns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+var (
+	le = binary.LittleEndian
+)
+
+// Dnstap constants.
+const (
+	// DnstapQueueSize defines how many messages are queued for the
+	// writer. The messages are dropped when the queue is full.
+	DnstapQueueSize = 1024
+	// DnstapReconnect defines how often a lost socket connection is
+	// reconnected.
+	DnstapReconnect = 5 * time.Second
+	// DnstapContentType defines the Frame Streams content type of
+	// dnstap.
+	DnstapContentType = "protobuf:dnstap.Dnstap"
+	// MaxFrameSize defines the maximum Frame Streams frame size.
+	MaxFrameSize = 1024 * 1024
+)
+
+// Frame Streams control frame types and fields.
+const (
+	fstrmControlAccept = 1
+	fstrmControlStart  = 2
+	fstrmControlStop   = 3
+	fstrmControlReady  = 4
+	fstrmControlFinish = 5
+
+	fstrmFieldContentType = 1
+)
+
+// DnstapType defines the dnstap message types.
+type DnstapType int
+
+// Dnstap message types.
+const (
+	DnstapClientQuery       DnstapType = 5
+	DnstapClientResponse    DnstapType = 6
+	DnstapForwarderQuery    DnstapType = 7
+	DnstapForwarderResponse DnstapType = 8
+)
+
+var dnstapTypes = map[DnstapType]string{
+	DnstapClientQuery:       "CLIENT_QUERY",
+	DnstapClientResponse:    "CLIENT_RESPONSE",
+	DnstapForwarderQuery:    "FORWARDER_QUERY",
+	DnstapForwarderResponse: "FORWARDER_RESPONSE",
+}
+
+func (t DnstapType) String() string {
+	name, ok := dnstapTypes[t]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{DnstapType %d}", t)
+}
+
+// DnstapProtocol defines the dnstap socket protocols.
+type DnstapProtocol int
+
+// Dnstap socket protocols.
+const (
+	DnstapUDP DnstapProtocol = 1
+	DnstapTCP DnstapProtocol = 2
+	DnstapDoT DnstapProtocol = 3
+	DnstapDoH DnstapProtocol = 4
+)
+
+var dnstapProtocols = map[DnstapProtocol]string{
+	DnstapUDP: "UDP",
+	DnstapTCP: "TCP",
+	DnstapDoT: "DOT",
+	DnstapDoH: "DOH",
+}
+
+func (p DnstapProtocol) String() string {
+	name, ok := dnstapProtocols[p]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{DnstapProtocol %d}", p)
+}
+
+// DnstapMessage defines a dnstap message. The socket family is
+// derived from the addresses.
+type DnstapMessage struct {
+	Identity        []byte
+	Version         []byte
+	Type            DnstapType
+	Protocol        DnstapProtocol
+	QueryAddress    net.IP
+	QueryPort       uint16
+	ResponseAddress net.IP
+	ResponsePort    uint16
+	QueryTime       time.Time
+	ResponseTime    time.Time
+	QueryMessage    []byte
+	ResponseMessage []byte
+}
+
+// Protocol buffers wire types.
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+func pbAppendVarint(data []byte, v uint64) []byte {
+	for v >= 0x80 {
+		data = append(data, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(data, byte(v))
+}
+
+func pbAppendTag(data []byte, field, wireType int) []byte {
+	return pbAppendVarint(data, uint64(field<<3|wireType))
+}
+
+func pbAppendUint(data []byte, field int, v uint64) []byte {
+	data = pbAppendTag(data, field, pbVarint)
+	return pbAppendVarint(data, v)
+}
+
+func pbAppendBytes(data []byte, field int, v []byte) []byte {
+	data = pbAppendTag(data, field, pbBytes)
+	data = pbAppendVarint(data, uint64(len(v)))
+	return append(data, v...)
+}
+
+func pbAppendFixed32(data []byte, field int, v uint32) []byte {
+	data = pbAppendTag(data, field, pbFixed32)
+	return le.AppendUint32(data, v)
+}
+
+// Marshal encodes the message as a dnstap.Dnstap protocol buffer.
+func (m *DnstapMessage) Marshal() []byte {
+	var msg []byte
+
+	msg = pbAppendUint(msg, 1, uint64(m.Type))
+	addr := m.QueryAddress
+	if addr == nil {
+		addr = m.ResponseAddress
+	}
+	if addr != nil {
+		family := uint64(1)
+		if addr.To4() == nil {
+			family = 2
+		}
+		msg = pbAppendUint(msg, 2, family)
+	}
+	if m.Protocol != 0 {
+		msg = pbAppendUint(msg, 3, uint64(m.Protocol))
+	}
+	if m.QueryAddress != nil {
+		msg = pbAppendBytes(msg, 4, ipBytes(m.QueryAddress))
+		msg = pbAppendUint(msg, 6, uint64(m.QueryPort))
+	}
+	if m.ResponseAddress != nil {
+		msg = pbAppendBytes(msg, 5, ipBytes(m.ResponseAddress))
+		msg = pbAppendUint(msg, 7, uint64(m.ResponsePort))
+	}
+	if !m.QueryTime.IsZero() {
+		msg = pbAppendUint(msg, 8, uint64(m.QueryTime.Unix()))
+		msg = pbAppendFixed32(msg, 9, uint32(m.QueryTime.Nanosecond()))
+	}
+	if m.QueryMessage != nil {
+		msg = pbAppendBytes(msg, 10, m.QueryMessage)
+	}
+	if !m.ResponseTime.IsZero() {
+		msg = pbAppendUint(msg, 12, uint64(m.ResponseTime.Unix()))
+		msg = pbAppendFixed32(msg, 13, uint32(m.ResponseTime.Nanosecond()))
+	}
+	if m.ResponseMessage != nil {
+		msg = pbAppendBytes(msg, 14, m.ResponseMessage)
+	}
+
+	var data []byte
+	if m.Identity != nil {
+		data = pbAppendBytes(data, 1, m.Identity)
+	}
+	if m.Version != nil {
+		data = pbAppendBytes(data, 2, m.Version)
+	}
+	data = pbAppendBytes(data, 14, msg)
+	// Type MESSAGE.
+	return pbAppendUint(data, 15, 1)
+}
+
+func ipBytes(ip net.IP) []byte {
+	ip4 := ip.To4()
+	if ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// pbField defines a decoded protocol buffer field.
+type pbField struct {
+	number   int
+	wireType int
+	v        uint64
+	data     []byte
+}
+
+func pbReadVarint(data []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		v |= uint64(data[i]&0x7f) << (7 * i)
+		if data[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("truncated varint")
+}
+
+func pbFields(data []byte) ([]pbField, error) {
+	var result []pbField
+	for len(data) > 0 {
+		tag, n, err := pbReadVarint(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		f := pbField{
+			number:   int(tag >> 3),
+			wireType: int(tag & 0x7),
+		}
+		switch f.wireType {
+		case pbVarint:
+			f.v, n, err = pbReadVarint(data)
+			if err != nil {
+				return nil, err
+			}
+			data = data[n:]
+
+		case pbBytes:
+			l, n, err := pbReadVarint(data)
+			if err != nil {
+				return nil, err
+			}
+			data = data[n:]
+			if l > uint64(len(data)) {
+				return nil, errors.New("truncated field")
+			}
+			f.data = data[:l]
+			data = data[l:]
+
+		case pbFixed64:
+			if len(data) < 8 {
+				return nil, errors.New("truncated field")
+			}
+			f.v = le.Uint64(data)
+			data = data[8:]
+
+		case pbFixed32:
+			if len(data) < 4 {
+				return nil, errors.New("truncated field")
+			}
+			f.v = uint64(le.Uint32(data))
+			data = data[4:]
+
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", f.wireType)
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// UnmarshalDnstap decodes the dnstap.Dnstap protocol buffer.
+func UnmarshalDnstap(data []byte) (*DnstapMessage, error) {
+	fields, err := pbFields(data)
+	if err != nil {
+		return nil, err
+	}
+	m := new(DnstapMessage)
+	var msg []byte
+	for _, f := range fields {
+		switch f.number {
+		case 1:
+			m.Identity = f.data
+		case 2:
+			m.Version = f.data
+		case 14:
+			msg = f.data
+		case 15:
+			if f.v != 1 {
+				return nil, fmt.Errorf("unsupported dnstap type %d", f.v)
+			}
+		}
+	}
+	if msg == nil {
+		return nil, errors.New("dnstap message missing")
+	}
+	fields, err = pbFields(msg)
+	if err != nil {
+		return nil, err
+	}
+	var qsec, rsec uint64
+	var qnsec, rnsec uint64
+	for _, f := range fields {
+		switch f.number {
+		case 1:
+			m.Type = DnstapType(f.v)
+		case 3:
+			m.Protocol = DnstapProtocol(f.v)
+		case 4:
+			m.QueryAddress = net.IP(f.data)
+		case 5:
+			m.ResponseAddress = net.IP(f.data)
+		case 6:
+			m.QueryPort = uint16(f.v)
+		case 7:
+			m.ResponsePort = uint16(f.v)
+		case 8:
+			qsec = f.v
+		case 9:
+			qnsec = f.v
+		case 10:
+			m.QueryMessage = f.data
+		case 12:
+			rsec = f.v
+		case 13:
+			rnsec = f.v
+		case 14:
+			m.ResponseMessage = f.data
+		}
+	}
+	if qsec != 0 || qnsec != 0 {
+		m.QueryTime = time.Unix(int64(qsec), int64(qnsec))
+	}
+	if rsec != 0 || rnsec != 0 {
+		m.ResponseTime = time.Unix(int64(rsec), int64(rnsec))
+	}
+	return m, nil
+}
+
+// appendControl appends the Frame Streams control frame.
+func appendControl(data []byte, control uint32, contentType string) []byte {
+	var payload []byte
+	payload = bo.AppendUint32(payload, control)
+	if len(contentType) > 0 {
+		payload = bo.AppendUint32(payload, fstrmFieldContentType)
+		payload = bo.AppendUint32(payload, uint32(len(contentType)))
+		payload = append(payload, contentType...)
+	}
+	// Escape sequence.
+	data = bo.AppendUint32(data, 0)
+	data = bo.AppendUint32(data, uint32(len(payload)))
+	return append(data, payload...)
+}
+
+// readFrame reads the next Frame Streams frame. For control frames,
+// the function returns true and the control frame payload.
+func readFrame(r io.Reader) ([]byte, bool, error) {
+	var hdr [4]byte
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return nil, false, err
+	}
+	var control bool
+	l := bo.Uint32(hdr[:])
+	if l == 0 {
+		control = true
+		_, err = io.ReadFull(r, hdr[:])
+		if err != nil {
+			return nil, false, err
+		}
+		l = bo.Uint32(hdr[:])
+	}
+	if l > MaxFrameSize {
+		return nil, false, fmt.Errorf("frame too large: %d", l)
+	}
+	data := make([]byte, l)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, control, nil
+}
+
+// readControl reads the control frame and verifies its type and
+// content type.
+func readControl(r io.Reader, expected uint32) error {
+	data, control, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	if !control || len(data) < 4 {
+		return errors.New("control frame expected")
+	}
+	if bo.Uint32(data) != expected {
+		return fmt.Errorf("unexpected control frame %d, expected %d",
+			bo.Uint32(data), expected)
+	}
+	return checkContentType(data[4:])
+}
+
+// checkContentType checks that the content type fields contain the
+// dnstap content type. Control frames without content types match
+// all types.
+func checkContentType(data []byte) error {
+	var found, match bool
+	for len(data) >= 8 {
+		field := bo.Uint32(data)
+		l := bo.Uint32(data[4:])
+		data = data[8:]
+		if uint32(len(data)) < l {
+			return errors.New("truncated control frame")
+		}
+		if field == fstrmFieldContentType {
+			found = true
+			if string(data[:l]) == DnstapContentType {
+				match = true
+			}
+		}
+		data = data[l:]
+	}
+	if found && !match {
+		return errors.New("dnstap content type not accepted")
+	}
+	return nil
+}
+
+// Dnstap writes dnstap messages to a file or to a unix socket. The
+// messages are queued and written by a background goroutine so that
+// slow consumers never block the proxy. The messages are dropped if
+// the queue is full or the output fails.
+type Dnstap struct {
+	// Identity and Version are set to the messages. They must not be
+	// modified after the first message.
+	Identity []byte
+	Version  []byte
+	m        sync.Mutex
+	closed   bool
+	ch       chan []byte
+	done     chan struct{}
+	dropped  atomic.Uint64
+	socket   string
+	conn     io.ReadWriteCloser
+	w        *bufio.Writer
+	retry    time.Time
+}
+
+// NewDnstapFile creates a dnstap output to the file. The file is
+// written with the unidirectional Frame Streams protocol.
+func NewDnstapFile(path string) (*Dnstap, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	d := newDnstap()
+	d.conn = f
+	d.w = bufio.NewWriter(f)
+	_, err = d.w.Write(appendControl(nil, fstrmControlStart,
+		DnstapContentType))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	go d.writer()
+	return d, nil
+}
+
+// NewDnstapSocket creates a dnstap output to the unix socket. The
+// socket is written with the bidirectional Frame Streams protocol
+// and it is reconnected if the connection is lost.
+func NewDnstapSocket(path string) (*Dnstap, error) {
+	d := newDnstap()
+	d.socket = path
+	err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	go d.writer()
+	return d, nil
+}
+
+func newDnstap() *Dnstap {
+	return &Dnstap{
+		ch:   make(chan []byte, DnstapQueueSize),
+		done: make(chan struct{}),
+	}
+}
+
+// connect connects to the unix socket and performs the Frame Streams
+// handshake.
+func (d *Dnstap) connect() error {
+	conn, err := net.DialTimeout("unix", d.socket, DnstapReconnect)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(DnstapReconnect))
+	_, err = conn.Write(appendControl(nil, fstrmControlReady,
+		DnstapContentType))
+	if err == nil {
+		err = readControl(conn, fstrmControlAccept)
+	}
+	if err == nil {
+		_, err = conn.Write(appendControl(nil, fstrmControlStart,
+			DnstapContentType))
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("dnstap %s: %s", d.socket, err)
+	}
+	conn.SetDeadline(time.Time{})
+	d.conn = conn
+	d.w = bufio.NewWriter(conn)
+	return nil
+}
+
+// Dropped returns the number of dropped messages.
+func (d *Dnstap) Dropped() uint64 {
+	return d.dropped.Load()
+}
+
+// Log queues the message for writing.
+func (d *Dnstap) Log(m *DnstapMessage) {
+	m.Identity = d.Identity
+	m.Version = d.Version
+	frame := m.Marshal()
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.closed {
+		d.dropped.Add(1)
+		return
+	}
+	select {
+	case d.ch <- frame:
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+func (d *Dnstap) writer() {
+	defer close(d.done)
+
+	for frame := range d.ch {
+		if d.w == nil {
+			if len(d.socket) == 0 || time.Now().Before(d.retry) {
+				d.dropped.Add(1)
+				continue
+			}
+			err := d.connect()
+			if err != nil {
+				d.retry = time.Now().Add(DnstapReconnect)
+				d.dropped.Add(1)
+				continue
+			}
+		}
+		if c, ok := d.conn.(net.Conn); ok {
+			// Stalled consumers are disconnected.
+			c.SetWriteDeadline(time.Now().Add(DnstapReconnect))
+		}
+		var hdr [4]byte
+		bo.PutUint32(hdr[:], uint32(len(frame)))
+		_, err := d.w.Write(hdr[:])
+		if err == nil {
+			_, err = d.w.Write(frame)
+		}
+		if err == nil && len(d.ch) == 0 {
+			err = d.w.Flush()
+		}
+		if err != nil {
+			log.Printf("dnstap: %s\n", err)
+			d.conn.Close()
+			d.conn = nil
+			d.w = nil
+			d.retry = time.Now().Add(DnstapReconnect)
+			d.dropped.Add(1)
+		}
+	}
+	if d.w == nil {
+		return
+	}
+	_, err := d.w.Write(appendControl(nil, fstrmControlStop, ""))
+	if err == nil {
+		err = d.w.Flush()
+	}
+	if err == nil && len(d.socket) > 0 {
+		if c, ok := d.conn.(net.Conn); ok {
+			c.SetReadDeadline(time.Now().Add(DnstapReconnect))
+		}
+		err = readControl(d.conn, fstrmControlFinish)
+	}
+	if err != nil {
+		log.Printf("dnstap: %s\n", err)
+	}
+	d.conn.Close()
+}
+
+// Close flushes the queued messages and closes the output.
+func (d *Dnstap) Close() error {
+	d.m.Lock()
+	if d.closed {
+		d.m.Unlock()
+		return nil
+	}
+	d.closed = true
+	close(d.ch)
+	d.m.Unlock()
+
+	<-d.done
+	return nil
+}
+
+// DnstapReader reads dnstap messages from Frame Streams. It
+// implements both the unidirectional protocol of the files and the
+// receiver side of the bidirectional protocol.
+type DnstapReader struct {
+	r    *bufio.Reader
+	w    io.Writer
+	done bool
+}
+
+// NewDnstapReader creates a new reader and reads the Frame Streams
+// handshake. The READY frames are answered with ACCEPT if the
+// reader is also an io.Writer.
+func NewDnstapReader(r io.Reader) (*DnstapReader, error) {
+	reader := &DnstapReader{
+		r: bufio.NewReader(r),
+	}
+	data, control, err := readFrame(reader.r)
+	if err != nil {
+		return nil, err
+	}
+	if !control || len(data) < 4 {
+		return nil, errors.New("control frame expected")
+	}
+	err = checkContentType(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	switch bo.Uint32(data) {
+	case fstrmControlStart:
+		return reader, nil
+
+	case fstrmControlReady:
+		w, ok := r.(io.Writer)
+		if !ok {
+			return nil, errors.New("bidirectional stream without writer")
+		}
+		reader.w = w
+		_, err = w.Write(appendControl(nil, fstrmControlAccept,
+			DnstapContentType))
+		if err != nil {
+			return nil, err
+		}
+		err = readControl(reader.r, fstrmControlStart)
+		if err != nil {
+			return nil, err
+		}
+		return reader, nil
+
+	default:
+		return nil, fmt.Errorf("unexpected control frame %d", bo.Uint32(data))
+	}
+}
+
+// Read reads the next dnstap message. The function returns io.EOF
+// when the stream is stopped.
+func (r *DnstapReader) Read() (*DnstapMessage, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	data, control, err := readFrame(r.r)
+	if err != nil {
+		return nil, err
+	}
+	if !control {
+		return UnmarshalDnstap(data)
+	}
+	if len(data) < 4 || bo.Uint32(data) != fstrmControlStop {
+		return nil, errors.New("unexpected control frame")
+	}
+	r.done = true
+	if r.w != nil {
+		_, err = r.w.Write(appendControl(nil, fstrmControlFinish, ""))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, io.EOF
+}
+
+// packetAddrs returns the source and destination addresses and
+// ports of the packet.
+func packetAddrs(packet gopacket.Packet) (src net.IP, sport uint16,
+	dst net.IP, dport uint16) {
+
+	switch network := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		src, dst = network.SrcIP, network.DstIP
+	case *layers.IPv6:
+		src, dst = network.SrcIP, network.DstIP
+	}
+	if udp, ok := packet.TransportLayer().(*layers.UDP); ok {
+		sport, dport = uint16(udp.SrcPort), uint16(udp.DstPort)
+	}
+	return
+}
+
+// upstreamAddr returns the address and port of the upstream if the
+// upstream server is specified by its IP address.
+func upstreamAddr(u *Upstream) (net.IP, uint16, DnstapProtocol) {
+	var protocol DnstapProtocol
+	switch u.Type {
+	case UpstreamDoH:
+		return nil, 0, DnstapDoH
+	case UpstreamDoT:
+		protocol = DnstapDoT
+	default:
+		protocol = DnstapUDP
+	}
+	host, port, err := net.SplitHostPort(u.Server)
+	if err != nil {
+		return nil, 0, protocol
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, 0, protocol
+	}
+	return net.ParseIP(host), uint16(p), protocol
+}
+
+// tapClient logs the client query or response. The packet is the
+// client's query packet.
+func (p *Proxy) tapClient(t DnstapType, packet gopacket.Packet, msg []byte) {
+	if p.Dnstap == nil {
+		return
+	}
+	m := &DnstapMessage{
+		Type:     t,
+		Protocol: DnstapUDP,
+	}
+	m.QueryAddress, m.QueryPort, m.ResponseAddress, m.ResponsePort =
+		packetAddrs(packet)
+	if t == DnstapClientQuery {
+		m.QueryTime = time.Now()
+		m.QueryMessage = msg
+	} else {
+		m.ResponseTime = time.Now()
+		m.ResponseMessage = msg
+	}
+	p.Dnstap.Log(m)
+}
+
+// tapForwarder logs the upstream query or response.
+func (p *Proxy) tapForwarder(t DnstapType, u *Upstream, sent time.Time,
+	msg []byte) {
+
+	if p.Dnstap == nil {
+		return
+	}
+	m := &DnstapMessage{
+		Type:      t,
+		QueryTime: sent,
+	}
+	m.ResponseAddress, m.ResponsePort, m.Protocol = upstreamAddr(u)
+	if t == DnstapForwarderQuery {
+		m.QueryMessage = msg
+	} else {
+		m.ResponseTime = time.Now()
+		m.ResponseMessage = msg
+	}
+	p.Dnstap.Log(m)
+}
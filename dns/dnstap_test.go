@@ -0,0 +1,306 @@
+//
+// dnstap_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func testDnstapMessages() []*DnstapMessage {
+	now := time.Unix(1792238400, 123456789)
+	return []*DnstapMessage{
+		{
+			Type:            DnstapClientQuery,
+			Protocol:        DnstapUDP,
+			QueryAddress:    net.IPv4(192, 168, 192, 1).To4(),
+			QueryPort:       40000,
+			ResponseAddress: net.IPv4(192, 168, 192, 254).To4(),
+			ResponsePort:    53,
+			QueryTime:       now,
+			QueryMessage:    []byte{0x12, 0x34, 1, 0},
+		},
+		{
+			Type:            DnstapForwarderResponse,
+			Protocol:        DnstapDoT,
+			ResponseAddress: net.ParseIP("2001:db8::53"),
+			ResponsePort:    853,
+			QueryTime:       now,
+			ResponseTime:    now.Add(15 * time.Millisecond),
+			ResponseMessage: bytes.Repeat([]byte{0xab}, 300),
+		},
+	}
+}
+
+func checkDnstapMessage(t *testing.T, got, expected *DnstapMessage) {
+	t.Helper()
+	if got.Type != expected.Type || got.Protocol != expected.Protocol ||
+		!got.QueryAddress.Equal(expected.QueryAddress) ||
+		got.QueryPort != expected.QueryPort ||
+		!got.ResponseAddress.Equal(expected.ResponseAddress) ||
+		got.ResponsePort != expected.ResponsePort ||
+		!got.QueryTime.Equal(expected.QueryTime) ||
+		!got.ResponseTime.Equal(expected.ResponseTime) ||
+		!bytes.Equal(got.QueryMessage, expected.QueryMessage) ||
+		!bytes.Equal(got.ResponseMessage, expected.ResponseMessage) ||
+		!bytes.Equal(got.Identity, expected.Identity) ||
+		!bytes.Equal(got.Version, expected.Version) {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+}
+
+func readDnstap(t *testing.T, r io.Reader) []*DnstapMessage {
+	t.Helper()
+	reader, err := NewDnstapReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []*DnstapMessage
+	for {
+		m, err := reader.Read()
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, m)
+	}
+}
+
+func TestDnstapMarshal(t *testing.T) {
+	for _, m := range testDnstapMessages() {
+		m.Identity = []byte("vpn")
+		got, err := UnmarshalDnstap(m.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkDnstapMessage(t, got, m)
+	}
+
+	// Message with the wire types that are not used by the proxy.
+	var msg []byte
+	msg = pbAppendUint(msg, 1, uint64(DnstapClientQuery))
+	msg = pbAppendTag(msg, 16, pbFixed64)
+	msg = le.AppendUint64(msg, 42)
+	var data []byte
+	data = pbAppendBytes(data, 14, msg)
+	data = pbAppendUint(data, 15, 1)
+	m, err := UnmarshalDnstap(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != DnstapClientQuery {
+		t.Errorf("got type %s", m.Type)
+	}
+
+	_, err = UnmarshalDnstap(data[:len(data)-4])
+	if err == nil {
+		t.Errorf("truncated message accepted")
+	}
+}
+
+func TestDnstapFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.fstrm")
+	d, err := NewDnstapFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Identity = []byte("vpn")
+	d.Version = []byte("test")
+
+	messages := testDnstapMessages()
+	for _, m := range messages {
+		d.Log(m)
+	}
+	err = d.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := readDnstap(t, f)
+	if len(got) != len(messages) {
+		t.Fatalf("got %d messages, expected %d", len(got), len(messages))
+	}
+	for i := range got {
+		checkDnstapMessage(t, got[i], messages[i])
+	}
+}
+
+func TestDnstapSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	result := make(chan []*DnstapMessage)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			close(result)
+			return
+		}
+		defer conn.Close()
+		result <- readDnstap(t, conn)
+	}()
+
+	d, err := NewDnstapSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := testDnstapMessages()
+	for _, m := range messages {
+		d.Log(m)
+	}
+	err = d.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := <-result
+	if len(got) != len(messages) {
+		t.Fatalf("got %d messages, expected %d", len(got), len(messages))
+	}
+	for i := range got {
+		checkDnstapMessage(t, got[i], messages[i])
+	}
+	if d.Dropped() != 0 {
+		t.Errorf("dropped %d messages", d.Dropped())
+	}
+}
+
+func TestDnstapSlowConsumer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		// Complete the handshake and stop reading.
+		_, _, err = readFrame(conn)
+		if err == nil {
+			conn.Write(appendControl(nil, fstrmControlAccept,
+				DnstapContentType))
+		}
+		accepted <- conn
+	}()
+
+	d, err := NewDnstapSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("accept failed")
+	}
+
+	m := testDnstapMessages()[1]
+	m.ResponseMessage = make([]byte, 4096)
+	start := time.Now()
+	for i := 0; i < 10*DnstapQueueSize; i++ {
+		d.Log(m)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Log blocked by slow consumer")
+	}
+	if d.Dropped() == 0 {
+		t.Errorf("no messages dropped")
+	}
+	conn.Close()
+	d.Close()
+}
+
+func TestProxyDnstap(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, false))
+	path := filepath.Join(t.TempDir(), "dnstap.fstrm")
+	d, err := NewDnstapFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.Dnstap = d
+
+	packet, query := testQuery(t, 0x1234, "www.example.com", layers.DNSTypeA)
+	err = proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.response(t)
+	err = d.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := readDnstap(t, f)
+
+	expected := []DnstapType{
+		DnstapClientQuery,
+		DnstapForwarderQuery,
+		DnstapForwarderResponse,
+		DnstapClientResponse,
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("got %d messages, expected %d", len(got), len(expected))
+	}
+	for i, m := range got {
+		if m.Type != expected[i] {
+			t.Errorf("message %d: got %s, expected %s", i, m.Type,
+				expected[i])
+		}
+		var msg []byte
+		switch m.Type {
+		case DnstapClientQuery, DnstapForwarderQuery:
+			msg = m.QueryMessage
+		default:
+			msg = m.ResponseMessage
+		}
+		dns := decodeTestMessage(t, msg)
+		if len(dns.Questions) != 1 ||
+			string(dns.Questions[0].Name) != "www.example.com" {
+			t.Errorf("message %d: unexpected DNS message: %v", i, dns)
+		}
+	}
+	client := got[0]
+	if !client.QueryAddress.Equal(net.IPv4(192, 168, 192, 1)) ||
+		client.QueryPort != 40000 || client.ResponsePort != 53 {
+		t.Errorf("unexpected client query: %+v", client)
+	}
+	forwarder := got[2]
+	if !forwarder.ResponseAddress.Equal(net.IPv4(127, 0, 0, 1)) ||
+		forwarder.Protocol != DnstapUDP ||
+		forwarder.ResponseTime.Before(forwarder.QueryTime) {
+		t.Errorf("unexpected forwarder response: %+v", forwarder)
+	}
+}
@@ -188,7 +188,7 @@ func (doh *DoHClient) doDoH(data []byte) ([]byte, error) {
 	}
 	req.Header.Set("Content-Type", "application/dns-message")
 
-	resp, err := doh.http.Do(req)
+	resp, err := doh.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -215,7 +215,7 @@ func (doh *DoHClient) doDoHProxy(data []byte) ([]byte, error) {
 		req.Header.Set("Content-Type", "application/json;charset=UTF-8")
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
-		resp, err := doh.http.Do(req)
+		resp, err := doh.do(req)
 		if err != nil {
 			return nil, err
 		}
@@ -268,7 +268,7 @@ func (doh *DoHClient) doDoHEncryptedProxy(data []byte) ([]byte, error) {
 		req.Header.Set("Content-Type", "application/octet-stream")
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
-		resp, err := doh.http.Do(req)
+		resp, err := doh.do(req)
 		if err != nil {
 			return nil, err
 		}
@@ -387,7 +387,7 @@ func (doh *DoHClient) CreateSA(sa *SA) error {
 		req.Header.Set("Content-Type", "application/octet-stream")
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
-		resp, err := doh.http.Do(req)
+		resp, err := doh.do(req)
 		if err != nil {
 			return err
 		}
@@ -400,6 +400,7 @@ func (doh *DoHClient) CreateSA(sa *SA) error {
 
 		switch resp.StatusCode {
 		case http.StatusCreated:
+			metricDoHSAs.Inc()
 			_, err = doh.AddCertificate(result)
 			return err
 
@@ -453,7 +454,8 @@ func (doh *DoHClient) Certificate() ([]*Certificate, error) {
 		return nil, err
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	resp, err := doh.http.Do(req)
+	metricDoHCertificates.Inc()
+	resp, err := doh.do(req)
 	if err != nil {
 		return nil, err
 	}
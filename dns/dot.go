@@ -0,0 +1,258 @@
+//
+// dot.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// DNS-over-TLS, RFC 7858.
+//
+
+package dns
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DoT client constants.
+const (
+	DoTPort        = "853"
+	DoTTimeout     = 5 * time.Second
+	DoTIdleTimeout = 30 * time.Second
+)
+
+// DoTClient implements a DNS-over-TLS client. The client pipelines
+// queries over a persistent TLS connection. The client assigns its
+// own IDs to the queries since the queries of the different callers
+// may have the same IDs.
+type DoTClient struct {
+	// Server is the address of the DoT server. The address is
+	// resolved when the client is created so that the client does
+	// not depend on the proxy it serves.
+	Server string
+	// ServerName is the authentication domain name of the server. It
+	// is used for SNI and for validating the server certificate.
+	ServerName string
+	// Pins contain the SHA-256 hashes of the accepted server
+	// SubjectPublicKeyInfos, see RFC 7858 section 4.2.
+	Pins    [][]byte
+	m       sync.Mutex
+	conn    *tls.Conn
+	nextID  uint16
+	pending map[uint16]chan []byte
+}
+
+// NewDoTClient creates a new DoT client for the server. The server
+// is specified as host[:port] and the port defaults to 853. If the
+// serverName is empty, the server certificate is validated against
+// the server host.
+func NewDoTClient(server, serverName string) (*DoTClient, error) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host = server
+		port = DoTPort
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("could not resolve DoT server %s", host)
+	}
+	if len(serverName) == 0 {
+		serverName = host
+	}
+
+	return &DoTClient{
+		Server:     net.JoinHostPort(addrs[0], port),
+		ServerName: serverName,
+		pending:    make(map[uint16]chan []byte),
+	}, nil
+}
+
+// AddPin adds a base64 encoded SHA-256 SPKI pin for the client.
+func (dot *DoTClient) AddPin(pin string) error {
+	data, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil {
+		return err
+	}
+	if len(data) != sha256.Size {
+		return fmt.Errorf("invalid SPKI pin length %d", len(data))
+	}
+	dot.Pins = append(dot.Pins, data)
+	return nil
+}
+
+func (dot *DoTClient) String() string {
+	return fmt.Sprintf("tls://%s#%s", dot.Server, dot.ServerName)
+}
+
+func (dot *DoTClient) verifyPins(cs tls.ConnectionState) error {
+	if len(dot.Pins) == 0 {
+		return nil
+	}
+	for _, cert := range cs.PeerCertificates {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range dot.Pins {
+			if bytes.Equal(sum[:], pin) {
+				return nil
+			}
+		}
+	}
+	return errors.New("DoT server SPKI pin mismatch")
+}
+
+func (dot *DoTClient) connect() (*tls.Conn, error) {
+	dot.m.Lock()
+	defer dot.m.Unlock()
+
+	if dot.conn != nil {
+		return dot.conn, nil
+	}
+
+	config := &tls.Config{
+		ServerName:       dot.ServerName,
+		VerifyConnection: dot.verifyPins,
+	}
+	if len(dot.Pins) > 0 && net.ParseIP(dot.ServerName) != nil {
+		// Pinned server without authentication name. The pins
+		// authenticate the server in VerifyConnection.
+		config.InsecureSkipVerify = true
+	}
+
+	dialer := &net.Dialer{
+		Timeout: DoTTimeout,
+	}
+	conn, err := tls.DialWithDialer(dialer, "tcp", dot.Server, config)
+	if err != nil {
+		return nil, err
+	}
+	dot.conn = conn
+	go dot.reader(conn)
+
+	return conn, nil
+}
+
+func (dot *DoTClient) reader(conn *tls.Conn) {
+	for {
+		conn.SetReadDeadline(time.Now().Add(DoTIdleTimeout))
+		msg, err := readTCPMessage(conn)
+		if err != nil {
+			break
+		}
+		if len(msg) < 2 {
+			continue
+		}
+		id := bo.Uint16(msg)
+
+		dot.m.Lock()
+		ch, ok := dot.pending[id]
+		delete(dot.pending, id)
+		dot.m.Unlock()
+
+		if ok {
+			ch <- msg
+		}
+	}
+	dot.closeConn(conn)
+}
+
+// closeConn closes the connection and fails all pending queries
+// that were sent over the connection.
+func (dot *DoTClient) closeConn(conn *tls.Conn) {
+	dot.m.Lock()
+	if dot.conn == conn {
+		dot.conn = nil
+		for id, ch := range dot.pending {
+			close(ch)
+			delete(dot.pending, id)
+		}
+	}
+	dot.m.Unlock()
+	conn.Close()
+}
+
+// Close closes the DoT client.
+func (dot *DoTClient) Close() error {
+	dot.m.Lock()
+	conn := dot.conn
+	dot.m.Unlock()
+
+	if conn != nil {
+		dot.closeConn(conn)
+	}
+	return nil
+}
+
+// allocate allocates a client ID for the query and registers the
+// query's response channel. The client mutex must be held.
+func (dot *DoTClient) allocate(ch chan []byte) (uint16, error) {
+	if len(dot.pending) > 0xffff {
+		return 0, fmt.Errorf("too many pending DoT queries")
+	}
+	for {
+		dot.nextID++
+		_, ok := dot.pending[dot.nextID]
+		if !ok {
+			dot.pending[dot.nextID] = ch
+			return dot.nextID, nil
+		}
+	}
+}
+
+// Do sends the DNS query to the server and returns the server's
+// response.
+func (dot *DoTClient) Do(data []byte) ([]byte, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("truncated DNS query")
+	}
+	qid := bo.Uint16(data)
+
+	conn, err := dot.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []byte, 1)
+	dot.m.Lock()
+	id, err := dot.allocate(ch)
+	dot.m.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the query with the client ID and restore the caller's ID
+	// to the response.
+	query := make([]byte, len(data))
+	copy(query, data)
+	bo.PutUint16(query, id)
+
+	err = writeTCPMessage(conn, query)
+	if err != nil {
+		dot.closeConn(conn)
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, fmt.Errorf("DoT connection closed")
+		}
+		bo.PutUint16(resp, qid)
+		return resp, nil
+
+	case <-time.After(DoTTimeout):
+		dot.m.Lock()
+		delete(dot.pending, id)
+		dot.m.Unlock()
+		return nil, ErrorTimeout
+	}
+}
@@ -0,0 +1,145 @@
+//
+// dot_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestDoTServer(t *testing.T) (net.Listener, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{
+			{
+				Certificate: [][]byte{der},
+				PrivateKey:  key,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				for {
+					msg, err := readTCPMessage(conn)
+					if err != nil {
+						return
+					}
+					if writeTCPMessage(conn, msg) != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return l, base64.StdEncoding.EncodeToString(pin[:])
+}
+
+func TestDoTPin(t *testing.T) {
+	l, pin := newTestDoTServer(t)
+	defer l.Close()
+
+	client, err := NewDoTClient(l.Addr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.AddPin(pin); err != nil {
+		t.Fatal(err)
+	}
+	query := []byte{0x12, 0x34, 0x01, 0x00}
+	resp, err := client.Do(query)
+	if err != nil {
+		t.Fatalf("pinned query failed: %s", err)
+	}
+	if !bytes.Equal(resp, query) {
+		t.Errorf("unexpected response %x", resp)
+	}
+
+	other, err := NewDoTClient(l.Addr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	other.AddPin(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	_, err = other.Do(query)
+	if err == nil {
+		t.Errorf("query succeeded with wrong pin")
+	}
+}
+
+func TestDoTQueryID(t *testing.T) {
+	l, pin := newTestDoTServer(t)
+	defer l.Close()
+
+	client, err := NewDoTClient(l.Addr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.AddPin(pin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another caller's query with the same ID is pending.
+	query := []byte{0x12, 0x34, 0x01, 0x00}
+	client.m.Lock()
+	client.pending[0x1234] = make(chan []byte, 1)
+	client.nextID = 0x1233
+	client.m.Unlock()
+
+	resp, err := client.Do(query)
+	if err != nil {
+		t.Fatalf("query with pending ID failed: %s", err)
+	}
+	if !bytes.Equal(resp, query) {
+		t.Errorf("unexpected response %x", resp)
+	}
+}
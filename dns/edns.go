@@ -0,0 +1,397 @@
+//
+// edns.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// EDNS(0) option policies.
+//
+
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// Client subnet constants.
+const (
+	// MaxECSPrefix4 defines the longest IPv4 client subnet prefix
+	// that is sent to upstreams (RFC 7871 section 11.1).
+	MaxECSPrefix4 = 24
+	// MaxECSPrefix6 defines the longest IPv6 client subnet prefix
+	// that is sent to upstreams.
+	MaxECSPrefix6 = 56
+)
+
+// EDNSPolicy defines how the EDNS(0) options of the client queries
+// are rewritten before they are sent to upstreams. The padding
+// option is not controlled by the policy since the proxy pads the
+// queries to its encrypted upstreams after the options are
+// rewritten.
+type EDNSPolicy struct {
+	Name string
+	// Keep lists the options that are forwarded to upstreams. If
+	// Keep is nil, all options are forwarded except the ones listed
+	// in Strip.
+	Keep []layers.DNSOptionCode
+	// Strip lists the options that are removed from the queries.
+	Strip []layers.DNSOptionCode
+	// ClientSubnet specifies the client subnet (RFC 7871) that is
+	// set to the queries. The clients' own subnet options are always
+	// removed if the ClientSubnet is set.
+	ClientSubnet *net.IPNet
+}
+
+// EDNS policy profiles.
+var (
+	// EDNSNone forwards the options as-is.
+	EDNSNone = &EDNSPolicy{
+		Name: "none",
+	}
+	// EDNSNoECS removes the client subnet options.
+	EDNSNoECS = &EDNSPolicy{
+		Name: "noecs",
+		Strip: []layers.DNSOptionCode{
+			layers.DNSOptionCodeEDNSClientSubnet,
+		},
+	}
+	// EDNSPrivacy removes all identifying options: client subnets,
+	// cookies, client and device tags, and all unknown options. It
+	// is the default policy for the encrypted upstreams.
+	EDNSPrivacy = &EDNSPolicy{
+		Name: "privacy",
+		Keep: []layers.DNSOptionCode{
+			layers.DNSOptionCodeDAU,
+			layers.DNSOptionCodeDHU,
+			layers.DNSOptionCodeN3U,
+			layers.DNSOptionCodeEDNSKeepAlive,
+		},
+	}
+)
+
+// EDNSProfiles lists the EDNS policy profiles by their names.
+var EDNSProfiles = map[string]*EDNSPolicy{
+	EDNSNone.Name:    EDNSNone,
+	EDNSNoECS.Name:   EDNSNoECS,
+	EDNSPrivacy.Name: EDNSPrivacy,
+}
+
+// EDNSProfileNames returns the sorted names of the EDNS policy
+// profiles.
+func EDNSProfileNames() []string {
+	var names []string
+	for name := range EDNSProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEDNSPolicy creates a new EDNS policy from the named profile. If
+// the subnet is not empty, it specifies the client subnet that is
+// set to the queries.
+func NewEDNSPolicy(profile, subnet string) (*EDNSPolicy, error) {
+	p, ok := EDNSProfiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown EDNS profile '%s', expected %s",
+			profile, strings.Join(EDNSProfileNames(), ", "))
+	}
+	policy := *p
+	if len(subnet) > 0 {
+		ipnet, err := ParseClientSubnet(subnet)
+		if err != nil {
+			return nil, err
+		}
+		policy.ClientSubnet = ipnet
+	}
+	return &policy, nil
+}
+
+// ParseClientSubnet parses the client subnet in the CIDR notation.
+// The subnet prefix must not be longer than MaxECSPrefix4 for IPv4
+// and MaxECSPrefix6 for IPv6 subnets.
+func ParseClientSubnet(cidr string) (*net.IPNet, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client subnet: %s", cidr)
+	}
+	ones, bits := ipnet.Mask.Size()
+	max := MaxECSPrefix6
+	if bits == 8*net.IPv4len {
+		max = MaxECSPrefix4
+	}
+	if ones > max {
+		return nil, fmt.Errorf("client subnet %s too specific: max /%d",
+			cidr, max)
+	}
+	return ipnet, nil
+}
+
+func (policy *EDNSPolicy) String() string {
+	if policy.ClientSubnet != nil {
+		return fmt.Sprintf("%s,ecs=%s", policy.Name, policy.ClientSubnet)
+	}
+	return policy.Name
+}
+
+// forward tests if the option is forwarded to upstreams.
+func (policy *EDNSPolicy) forward(code layers.DNSOptionCode) bool {
+	if code == layers.DNSOptionCodePadding {
+		return true
+	}
+	if code == layers.DNSOptionCodeEDNSClientSubnet &&
+		policy.ClientSubnet != nil {
+		return false
+	}
+	for _, c := range policy.Strip {
+		if c == code {
+			return false
+		}
+	}
+	if policy.Keep == nil {
+		return true
+	}
+	for _, c := range policy.Keep {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply rewrites the EDNS(0) options of the query. The function
+// returns true if the query was modified.
+func (policy *EDNSPolicy) Apply(dns *layers.DNS) bool {
+	var modified bool
+
+	for i := range dns.Additionals {
+		opt := &dns.Additionals[i]
+		if opt.Type != layers.DNSTypeOPT {
+			continue
+		}
+		var opts []layers.DNSOPT
+		for _, o := range opt.OPT {
+			if policy.forward(o.Code) {
+				opts = append(opts, o)
+			} else {
+				modified = true
+			}
+		}
+		opt.OPT = opts
+	}
+	if policy.ClientSubnet == nil {
+		return modified
+	}
+
+	// Set the configured client subnet.
+	var opt *layers.DNSResourceRecord
+	for i := range dns.Additionals {
+		if dns.Additionals[i].Type == layers.DNSTypeOPT {
+			opt = &dns.Additionals[i]
+			break
+		}
+	}
+	if opt == nil {
+		dns.Additionals = append(dns.Additionals, layers.DNSResourceRecord{
+			Type:  layers.DNSTypeOPT,
+			Class: 4096,
+		})
+		opt = &dns.Additionals[len(dns.Additionals)-1]
+	}
+	opt.OPT = append(opt.OPT, layers.DNSOPT{
+		Code: layers.DNSOptionCodeEDNSClientSubnet,
+		Data: ecsData(policy.ClientSubnet),
+	})
+	return true
+}
+
+// ecsData encodes the client subnet option data (RFC 7871 section
+// 6). The scope prefix length is 0 in queries.
+func ecsData(ipnet *net.IPNet) []byte {
+	ones, bits := ipnet.Mask.Size()
+	family := uint16(1)
+	ip := ipnet.IP.To4()
+	if bits != 8*net.IPv4len || ip == nil {
+		family = 2
+		ip = ipnet.IP.To16()
+	}
+	data := bo.AppendUint16(nil, family)
+	data = append(data, byte(ones), 0)
+	return append(data, ip.Mask(ipnet.Mask)[:(ones+7)/8]...)
+}
+
+// stripOption removes the EDNS(0) option from the OPT records.
+func stripOption(rrs []layers.DNSResourceRecord, code layers.DNSOptionCode) {
+	for i := range rrs {
+		if rrs[i].Type != layers.DNSTypeOPT {
+			continue
+		}
+		var opts []layers.DNSOPT
+		for _, o := range rrs[i].OPT {
+			if o.Code != code {
+				opts = append(opts, o)
+			}
+		}
+		rrs[i].OPT = opts
+	}
+}
+
+// padQuery pads the query to the closest multiple of 128 octets (RFC
+// 8467 section 4.1). The dataLen specifies the query's encoded
+// length. The client's padding is replaced since the proxy rewrites
+// the query. The function returns the padded query and the padding
+// length.
+func padQuery(dns *layers.DNS, dataLen int) ([]byte, int, error) {
+	var opt *layers.DNSResourceRecord
+	for i := range dns.Additionals {
+		if dns.Additionals[i].Type == layers.DNSTypeOPT {
+			opt = &dns.Additionals[i]
+			break
+		}
+	}
+	if opt == nil {
+		// Add OPT record.
+		dns.Additionals = append(dns.Additionals, layers.DNSResourceRecord{
+			Type:  layers.DNSTypeOPT,
+			Class: 4096,
+			TTL:   0,
+		})
+		opt = &dns.Additionals[len(dns.Additionals)-1]
+		dataLen += 11
+	}
+	var opts []layers.DNSOPT
+	for _, o := range opt.OPT {
+		if o.Code == layers.DNSOptionCodePadding {
+			dataLen -= 4 + len(o.Data)
+		} else {
+			opts = append(opts, o)
+		}
+	}
+	dataLen += 4
+	padLen := (128 - dataLen%128) % 128
+	opt.OPT = append(opts, layers.DNSOPT{
+		Code: layers.DNSOptionCodePadding,
+		Data: make([]byte, padLen),
+	})
+
+	buffer := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buffer, serializeOptions, dns)
+	if err != nil {
+		return nil, 0, err
+	}
+	return buffer.Bytes(), padLen, nil
+}
+
+// ExtendedError defines the Extended DNS Error codes (RFC 8914).
+type ExtendedError uint16
+
+// Extended DNS Error codes.
+const (
+	EDEStaleAnswer          ExtendedError = 3
+	EDEDNSSECBogus          ExtendedError = 6
+	EDENoReachableAuthority ExtendedError = 22
+	EDENetworkError         ExtendedError = 23
+)
+
+// dnsOptionCodeEDE defines the Extended DNS Error option code.
+const dnsOptionCodeEDE layers.DNSOptionCode = 15
+
+var extendedErrors = map[ExtendedError]string{
+	EDEStaleAnswer:          "Stale Answer",
+	EDEDNSSECBogus:          "DNSSEC Bogus",
+	EDENoReachableAuthority: "No Reachable Authority",
+	EDENetworkError:         "Network Error",
+}
+
+func (e ExtendedError) String() string {
+	name, ok := extendedErrors[e]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{ExtendedError %d}", e)
+}
+
+// hasOPT tests if the message has an OPT record.
+func hasOPT(dns *layers.DNS) bool {
+	for _, rr := range dns.Additionals {
+		if rr.Type == layers.DNSTypeOPT {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDO tests if the message has the DNSSEC OK bit set.
+func hasDO(dns *layers.DNS) bool {
+	for _, rr := range dns.Additionals {
+		if rr.Type == layers.DNSTypeOPT && rr.TTL&ednsDO != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasOption tests if the message has the EDNS(0) option.
+func hasOption(dns *layers.DNS, code layers.DNSOptionCode) bool {
+	for _, rr := range dns.Additionals {
+		if rr.Type != layers.DNSTypeOPT {
+			continue
+		}
+		for _, o := range rr.OPT {
+			if o.Code == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setExtendedError sets the Extended DNS Error option with the extra
+// text to the response. The OPT record is added if the response
+// does not have it.
+func setExtendedError(dns *layers.DNS, e ExtendedError, text string) {
+	data := bo.AppendUint16(nil, uint16(e))
+	data = append(data, text...)
+	opt := layers.DNSOPT{
+		Code: dnsOptionCodeEDE,
+		Data: data,
+	}
+	for i := range dns.Additionals {
+		if dns.Additionals[i].Type == layers.DNSTypeOPT {
+			// The options can be shared with the cached responses.
+			opts := make([]layers.DNSOPT, 0, len(dns.Additionals[i].OPT)+1)
+			opts = append(opts, dns.Additionals[i].OPT...)
+			dns.Additionals[i].OPT = append(opts, opt)
+			return
+		}
+	}
+	dns.Additionals = append(dns.Additionals, layers.DNSResourceRecord{
+		Type:  layers.DNSTypeOPT,
+		Class: 4096,
+		OPT:   []layers.DNSOPT{opt},
+	})
+}
+
+// extendedError returns the Extended DNS Error of the response, or
+// false if the response has no Extended DNS Error option.
+func extendedError(dns *layers.DNS) (ExtendedError, string, bool) {
+	for _, rr := range dns.Additionals {
+		if rr.Type != layers.DNSTypeOPT {
+			continue
+		}
+		for _, o := range rr.OPT {
+			if o.Code == dnsOptionCodeEDE && len(o.Data) >= 2 {
+				return ExtendedError(bo.Uint16(o.Data)), string(o.Data[2:]),
+					true
+			}
+		}
+	}
+	return 0, "", false
+}
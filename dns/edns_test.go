@@ -0,0 +1,328 @@
+//
+// edns_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+func testOPT(codes ...layers.DNSOptionCode) layers.DNSResourceRecord {
+	rr := layers.DNSResourceRecord{
+		Type:  layers.DNSTypeOPT,
+		Class: 4096,
+	}
+	for _, code := range codes {
+		rr.OPT = append(rr.OPT, layers.DNSOPT{
+			Code: code,
+			Data: []byte{1, 2, 3, 4},
+		})
+	}
+	return rr
+}
+
+func optCodes(dns *layers.DNS) []layers.DNSOptionCode {
+	var result []layers.DNSOptionCode
+	for _, rr := range dns.Additionals {
+		if rr.Type == layers.DNSTypeOPT {
+			for _, o := range rr.OPT {
+				result = append(result, o.Code)
+			}
+		}
+	}
+	return result
+}
+
+func optData(dns *layers.DNS, code layers.DNSOptionCode) []byte {
+	for _, rr := range dns.Additionals {
+		if rr.Type == layers.DNSTypeOPT {
+			for _, o := range rr.OPT {
+				if o.Code == code {
+					return o.Data
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func TestEDNSPolicy(t *testing.T) {
+	codes := []layers.DNSOptionCode{
+		layers.DNSOptionCodeEDNSClientSubnet,
+		layers.DNSOptionCodeCookie,
+		layers.DNSOptionCodeDAU,
+		layers.DNSOptionCodeDeviceID,
+		layers.DNSOptionCodePadding,
+	}
+	tests := []struct {
+		profile  string
+		subnet   string
+		modified bool
+		codes    []layers.DNSOptionCode
+	}{
+		{
+			profile: "none",
+			codes:   codes,
+		},
+		{
+			profile:  "noecs",
+			modified: true,
+			codes: []layers.DNSOptionCode{
+				layers.DNSOptionCodeCookie,
+				layers.DNSOptionCodeDAU,
+				layers.DNSOptionCodeDeviceID,
+				layers.DNSOptionCodePadding,
+			},
+		},
+		{
+			profile:  "privacy",
+			modified: true,
+			codes: []layers.DNSOptionCode{
+				layers.DNSOptionCodeDAU,
+				layers.DNSOptionCodePadding,
+			},
+		},
+		{
+			profile:  "none",
+			subnet:   "198.51.100.0/24",
+			modified: true,
+			codes: []layers.DNSOptionCode{
+				layers.DNSOptionCodeCookie,
+				layers.DNSOptionCodeDAU,
+				layers.DNSOptionCodeDeviceID,
+				layers.DNSOptionCodePadding,
+				layers.DNSOptionCodeEDNSClientSubnet,
+			},
+		},
+	}
+	for _, test := range tests {
+		policy, err := NewEDNSPolicy(test.profile, test.subnet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dns := &layers.DNS{
+			Additionals: []layers.DNSResourceRecord{testOPT(codes...)},
+		}
+		modified := policy.Apply(dns)
+		if modified != test.modified {
+			t.Errorf("%s: modified=%v, expected %v", policy, modified,
+				test.modified)
+		}
+		result := optCodes(dns)
+		if len(result) != len(test.codes) {
+			t.Errorf("%s: got options %v, expected %v", policy, result,
+				test.codes)
+			continue
+		}
+		for i := range result {
+			if result[i] != test.codes[i] {
+				t.Errorf("%s: got options %v, expected %v", policy, result,
+					test.codes)
+				break
+			}
+		}
+	}
+
+	_, err := NewEDNSPolicy("unknown", "")
+	if err == nil {
+		t.Errorf("unknown profile accepted")
+	}
+}
+
+func TestClientSubnet(t *testing.T) {
+	tests := []struct {
+		subnet string
+		data   []byte
+	}{
+		{
+			subnet: "198.51.100.0/24",
+			data:   []byte{0, 1, 24, 0, 198, 51, 100},
+		},
+		{
+			subnet: "198.51.100.0/20",
+			data:   []byte{0, 1, 20, 0, 198, 51, 96},
+		},
+		{
+			subnet: "10.0.0.0/8",
+			data:   []byte{0, 1, 8, 0, 10},
+		},
+		{
+			subnet: "2001:db8:1234::/48",
+			data:   []byte{0, 2, 48, 0, 0x20, 0x01, 0x0d, 0xb8, 0x12, 0x34},
+		},
+		{
+			subnet: "0.0.0.0/0",
+			data:   []byte{0, 1, 0, 0},
+		},
+	}
+	for _, test := range tests {
+		ipnet, err := ParseClientSubnet(test.subnet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := ecsData(ipnet)
+		if !bytes.Equal(data, test.data) {
+			t.Errorf("%s: got %x, expected %x", test.subnet, data, test.data)
+		}
+	}
+
+	for _, subnet := range []string{
+		"198.51.100.1/32", "198.51.100.0/25", "2001:db8::/64", "198.51.100.0",
+	} {
+		_, err := ParseClientSubnet(subnet)
+		if err == nil {
+			t.Errorf("invalid subnet %s accepted", subnet)
+		}
+	}
+}
+
+func TestPadQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		additionals []layers.DNSResourceRecord
+	}{
+		{
+			name: "www.example.com",
+		},
+		{
+			name: "www.example.com",
+			additionals: []layers.DNSResourceRecord{
+				testOPT(layers.DNSOptionCodeDAU),
+			},
+		},
+		{
+			// The client's padding is replaced.
+			name: "a-longer-name.subdomain.example.com",
+			additionals: []layers.DNSResourceRecord{
+				testOPT(layers.DNSOptionCodePadding, layers.DNSOptionCodeDAU),
+			},
+		},
+		{
+			// The padded query is exactly 128 bytes with an empty
+			// padding option.
+			name: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa." +
+				"bbbbbbbbbbbbbbbbbbbbbbbb.example.com",
+		},
+	}
+	for _, test := range tests {
+		dns := &layers.DNS{
+			ID:          1,
+			OpCode:      layers.DNSOpCodeQuery,
+			RD:          true,
+			Questions:   []layers.DNSQuestion{question(test.name, 1)},
+			Additionals: test.additionals,
+		}
+		buffer := gopacket.NewSerializeBuffer()
+		err := gopacket.SerializeLayers(buffer, serializeOptions, dns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _, err := padQuery(dns, len(buffer.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data)%128 != 0 {
+			t.Errorf("%s: padded query length %d", test.name, len(data))
+		}
+		var count int
+		for _, code := range optCodes(dns) {
+			if code == layers.DNSOptionCodePadding {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Errorf("%s: %d padding options", test.name, count)
+		}
+	}
+}
+
+func TestProxyEDNS(t *testing.T) {
+	server := newTestServer(t, false)
+	proxy, out := newTestProxy(t, server)
+
+	var err error
+	proxy.EDNS, err = NewEDNSPolicy("privacy", "198.51.100.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, query := testQuery(t, 1, "www.example.com", layers.DNSTypeA)
+	query.Additionals = append(query.Additionals,
+		testOPT(layers.DNSOptionCodeEDNSClientSubnet,
+			layers.DNSOptionCodeCookie))
+	err = proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.response(t)
+
+	q := <-server.queries
+	codes := optCodes(q)
+	if len(codes) != 1 || codes[0] != layers.DNSOptionCodeEDNSClientSubnet {
+		t.Errorf("unexpected upstream options: %v", codes)
+	}
+	data := optData(q, layers.DNSOptionCodeEDNSClientSubnet)
+	if !bytes.Equal(data, []byte{0, 1, 24, 0, 198, 51, 100}) {
+		t.Errorf("unexpected client subnet: %x", data)
+	}
+}
+
+func TestProxyCacheClientSubnet(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, false))
+	proxy.Cache = NewCache(10)
+
+	// The client's subnet is forwarded without EDNS policy.
+	packet, query := testQuery(t, 1, "www.example.com", layers.DNSTypeA)
+	query.Additionals = append(query.Additionals,
+		testOPT(layers.DNSOptionCodeEDNSClientSubnet))
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.response(t)
+
+	time.Sleep(50 * time.Millisecond)
+	if proxy.Cache.Len() != 0 {
+		t.Errorf("response to client subnet cached")
+	}
+}
+
+func TestProxyClientQueryUnmodified(t *testing.T) {
+	server := newTestServer(t, false)
+	proxy, out := newTestProxy(t, server)
+
+	var err error
+	proxy.EDNS, err = NewEDNSPolicy("privacy", "198.51.100.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, query := testQuery(t, 1, "www.example.com", layers.DNSTypeA)
+	err = proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.response(t)
+
+	q := <-server.queries
+	if !hasOption(q, layers.DNSOptionCodeEDNSClientSubnet) {
+		t.Errorf("client subnet not sent")
+	}
+	// The client's query remains a non-EDNS query.
+	if hasOPT(query) {
+		t.Errorf("OPT record added to the client's query")
+	}
+	if size := proxy.maxUDPSize(packet); size != MinUDPSize {
+		t.Errorf("client's UDP size %d, expected %d", size, MinUDPSize)
+	}
+}
@@ -0,0 +1,197 @@
+//
+// forward.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Conditional forwarding.
+//
+
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Reserved upstream names.
+const (
+	// UpstreamSystem names the system DNS servers.
+	UpstreamSystem = "system"
+	// UpstreamDefault names the default upstream pool.
+	UpstreamDefault = "default"
+)
+
+// ForwardRule routes the queries matching the pattern to the named
+// upstream.
+type ForwardRule struct {
+	Pattern  Labels
+	Upstream string
+}
+
+func (rule ForwardRule) String() string {
+	return fmt.Sprintf("%s => %s", rule.Pattern, rule.Upstream)
+}
+
+// Forwarding defines conditional forwarding configuration.
+type Forwarding struct {
+	Rules     []ForwardRule
+	Upstreams map[string]*Pool
+}
+
+// MatchForwardRule finds the forwarding rule for the labels. If
+// multiple rules match the labels, the rule with the longest match
+// wins. The function returns nil if no rules match the labels.
+func MatchForwardRule(rules []ForwardRule, labels Labels) *ForwardRule {
+	var best *ForwardRule
+	for i := range rules {
+		rule := &rules[i]
+		if !labels.Match(rule.Pattern) {
+			continue
+		}
+		if best == nil ||
+			rule.Pattern.Specificity() > best.Pattern.Specificity() {
+			best = rule
+		}
+	}
+	return best
+}
+
+// PrivateReverseZones returns the reverse zone patterns for the
+// private (RFC 1918), link-local, and unique local (RFC 4193) address
+// ranges.
+func PrivateReverseZones() []Labels {
+	result := []Labels{
+		NewLabels("**.10.in-addr.arpa"),
+		NewLabels("**.168.192.in-addr.arpa"),
+		NewLabels("**.254.169.in-addr.arpa"),
+		// fc00::/7
+		NewLabels("**.c.f.ip6.arpa"),
+		NewLabels("**.d.f.ip6.arpa"),
+		// fe80::/10
+		NewLabels("**.8.e.f.ip6.arpa"),
+		NewLabels("**.9.e.f.ip6.arpa"),
+		NewLabels("**.a.e.f.ip6.arpa"),
+		NewLabels("**.b.e.f.ip6.arpa"),
+	}
+	// 172.16.0.0/12
+	for i := 16; i < 32; i++ {
+		result = append(result,
+			NewLabels(fmt.Sprintf("**.%d.172.in-addr.arpa", i)))
+	}
+	return result
+}
+
+// ReadForwarding reads the conditional forwarding configuration from
+// the file. The file contains the following directives:
+//
+//	upstream NAME [strategy=STRATEGY] SPEC[,SPEC...]
+//	forward PATTERN NAME
+//	reverse NAME
+//
+// The upstream directive defines a named upstream pool, see
+// ParseUpstream for the upstream specifications. The forward
+// directive routes the names matching the pattern to the named
+// upstream, and the reverse directive routes the private reverse
+// zones to the named upstream. The names "system" and "default"
+// refer to the system DNS servers and to the default upstream pool.
+func ReadForwarding(name string) (*Forwarding, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := &Forwarding{
+		Upstreams: make(map[string]*Pool),
+	}
+
+	var lineNum int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "upstream":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("%s:%d: invalid upstream: %s",
+					name, lineNum, line)
+			}
+			upstream := fields[1]
+			if upstream == UpstreamSystem || upstream == UpstreamDefault {
+				return nil, fmt.Errorf("%s:%d: reserved upstream name: %s",
+					name, lineNum, upstream)
+			}
+			pool := NewPool(StrategyOrder)
+			for _, arg := range fields[2:] {
+				if strings.HasPrefix(arg, "strategy=") {
+					pool.Strategy, err = ParseStrategy(
+						strings.TrimPrefix(arg, "strategy="))
+					if err != nil {
+						return nil, fmt.Errorf("%s:%d: %s", name, lineNum, err)
+					}
+					continue
+				}
+				for _, spec := range strings.Split(arg, ",") {
+					u, err := ParseUpstream(spec)
+					if err != nil {
+						return nil, fmt.Errorf("%s:%d: %s", name, lineNum, err)
+					}
+					pool.Upstreams = append(pool.Upstreams, u)
+				}
+			}
+			result.Upstreams[upstream] = pool
+
+		case "forward":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("%s:%d: invalid forward: %s",
+					name, lineNum, line)
+			}
+			result.Rules = append(result.Rules, ForwardRule{
+				Pattern:  NewLabels(strings.ToLower(fields[1])),
+				Upstream: fields[2],
+			})
+
+		case "reverse":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("%s:%d: invalid reverse: %s",
+					name, lineNum, line)
+			}
+			for _, pattern := range PrivateReverseZones() {
+				result.Rules = append(result.Rules, ForwardRule{
+					Pattern:  pattern,
+					Upstream: fields[1],
+				})
+			}
+
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown directive: %s",
+				name, lineNum, fields[0])
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range result.Rules {
+		switch rule.Upstream {
+		case UpstreamSystem, UpstreamDefault:
+		default:
+			_, ok := result.Upstreams[rule.Upstream]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown upstream '%s' in rule %s",
+					name, rule.Upstream, rule)
+			}
+		}
+	}
+
+	return result, nil
+}
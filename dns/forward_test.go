@@ -0,0 +1,63 @@
+//
+// forward_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"testing"
+)
+
+func TestMatchForwardRule(t *testing.T) {
+	rules := []ForwardRule{
+		{
+			Pattern:  NewLabels("**.example"),
+			Upstream: "a",
+		},
+		{
+			Pattern:  NewLabels("*.corp.example"),
+			Upstream: "b",
+		},
+		{
+			Pattern:  NewLabels("**.internal"),
+			Upstream: "c",
+		},
+	}
+	for _, pattern := range PrivateReverseZones() {
+		rules = append(rules, ForwardRule{
+			Pattern:  pattern,
+			Upstream: UpstreamSystem,
+		})
+	}
+
+	tests := []struct {
+		name     string
+		upstream string
+	}{
+		{"www.example", "a"},
+		{"corp.example", "a"},
+		{"www.corp.example", "b"},
+		{"a.b.corp.example", "b"},
+		{"internal", "c"},
+		{"www.example.com", ""},
+		{"1.0.0.10.in-addr.arpa", UpstreamSystem},
+		{"1.0.20.172.in-addr.arpa", UpstreamSystem},
+		{"1.0.32.172.in-addr.arpa", ""},
+		{"8.8.8.8.in-addr.arpa", ""},
+	}
+	for _, test := range tests {
+		rule := MatchForwardRule(rules, NewLabels(test.name))
+		var upstream string
+		if rule != nil {
+			upstream = rule.Upstream
+		}
+		if upstream != test.upstream {
+			t.Errorf("%s: got upstream '%s', expected '%s'",
+				test.name, upstream, test.upstream)
+		}
+	}
+}
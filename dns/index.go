@@ -0,0 +1,459 @@
+//
+// index.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Precompiled blacklist index.
+//
+
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Index file format constants.
+const (
+	IndexMagic   = "VPNBLIDX"
+	IndexVersion = 1
+
+	indexHeaderSize = 32
+	indexNodeSize   = 24
+	indexEdgeSize   = 12
+	indexRuleSize   = 20
+
+	indexNodeWild  = 0x1
+	indexRuleAllow = 0x1
+)
+
+// The index file has the following layout. All integers are in
+// network byte order.
+//
+//	header:
+//	  magic    [8]byte
+//	  version  uint32
+//	  rules    uint32  number of rules compiled into the index
+//	  nodes    uint32  number of node records
+//	  edges    uint32  number of edge records
+//	  records  uint32  number of rule records
+//	  strings  uint32  length of the string table
+//	nodes:     [nodes]{edgeStart, edgeCount, star, glob, rule, flags uint32}
+//	edges:     [edges]{labelOffset, labelLength, child uint32}
+//	records:   [records]{index uint32, patternOffset uint32,
+//	                     patternLength uint16, flags, specificity uint8,
+//	                     responseOffset, responseLength uint32}
+//	strings:   [strings]byte
+//
+// The first node is the trie root. The edges of a node are sorted by
+// their labels. The node and rule references are 1-based indices and
+// zero means none.
+
+// WriteIndex compiles the rules into an index and writes it to the
+// writer.
+func WriteIndex(w io.Writer, rules []Rule) error {
+	m := NewMatcher(rules)
+
+	var nodes []*trieNode
+	ids := make(map[*trieNode]uint32)
+	queue := []*trieNode{m.root}
+	ids[m.root] = 0
+
+	add := func(n *trieNode) uint32 {
+		if n == nil {
+			return 0
+		}
+		id, ok := ids[n]
+		if !ok {
+			id = uint32(len(ids))
+			ids[n] = id
+			queue = append(queue, n)
+		}
+		return id + 1
+	}
+
+	var strtab bytes.Buffer
+	stringIDs := make(map[string]uint32)
+	str := func(s string) (uint32, uint32) {
+		off, ok := stringIDs[s]
+		if !ok {
+			off = uint32(strtab.Len())
+			strtab.WriteString(s)
+			stringIDs[s] = off
+		}
+		return off, uint32(len(s))
+	}
+
+	var nodeData, edgeData, ruleData []byte
+	var numEdges, numRecords uint32
+
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		nodes = append(nodes, n)
+
+		var labels []string
+		for label := range n.children {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+
+		edgeStart := numEdges
+		for _, label := range labels {
+			off, l := str(label)
+			edgeData = bo.AppendUint32(edgeData, off)
+			edgeData = bo.AppendUint32(edgeData, l)
+			edgeData = bo.AppendUint32(edgeData, add(n.children[label]))
+			numEdges++
+		}
+
+		var rule uint32
+		if n.rule != nil {
+			numRecords++
+			rule = numRecords
+
+			var flags byte
+			if n.rule.Allow {
+				flags |= indexRuleAllow
+			}
+			var resp string
+			if n.rule.Response != nil {
+				resp = n.rule.Response.String()
+			}
+			pOff, pLen := str(n.rule.Pattern.String())
+			rOff, rLen := str(resp)
+
+			ruleData = bo.AppendUint32(ruleData, uint32(n.index))
+			ruleData = bo.AppendUint32(ruleData, pOff)
+			ruleData = bo.AppendUint16(ruleData, uint16(pLen))
+			ruleData = append(ruleData, flags, byte(n.specificity))
+			ruleData = bo.AppendUint32(ruleData, rOff)
+			ruleData = bo.AppendUint32(ruleData, rLen)
+		}
+
+		var flags uint32
+		if n.wild {
+			flags |= indexNodeWild
+		}
+		nodeData = bo.AppendUint32(nodeData, edgeStart)
+		nodeData = bo.AppendUint32(nodeData, uint32(len(labels)))
+		nodeData = bo.AppendUint32(nodeData, add(n.star))
+		nodeData = bo.AppendUint32(nodeData, add(n.glob))
+		nodeData = bo.AppendUint32(nodeData, rule)
+		nodeData = bo.AppendUint32(nodeData, flags)
+	}
+
+	var header []byte
+	header = append(header, IndexMagic...)
+	header = bo.AppendUint32(header, IndexVersion)
+	header = bo.AppendUint32(header, uint32(len(rules)))
+	header = bo.AppendUint32(header, uint32(len(nodes)))
+	header = bo.AppendUint32(header, numEdges)
+	header = bo.AppendUint32(header, numRecords)
+	header = bo.AppendUint32(header, uint32(strtab.Len()))
+
+	bw := bufio.NewWriter(w)
+	for _, data := range [][]byte{
+		header, nodeData, edgeData, ruleData, strtab.Bytes(),
+	} {
+		_, err := bw.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Index implements a precompiled blacklist index. The index file is
+// memory mapped and the lookups use the mapped data directly without
+// loading the rules into memory.
+type Index struct {
+	data    []byte
+	rules   int
+	nodes   []byte
+	edges   []byte
+	records []byte
+	strings []byte
+}
+
+// IsIndex tests if the file is a blacklist index file.
+func IsIndex(name string) (bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var buf [len(IndexMagic)]byte
+	_, err = io.ReadFull(f, buf[:])
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(buf[:]) == IndexMagic, nil
+}
+
+// OpenIndex opens the index file. The index is unmapped when it is
+// closed or when it is no longer referenced. The index file must not
+// be modified in place while it is open; replace it with a new file
+// instead.
+func OpenIndex(name string) (*Index, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < indexHeaderSize {
+		return nil, fmt.Errorf("%s: truncated index", name)
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()),
+		syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("%s: mmap: %s", name, err)
+	}
+	idx, err := newIndex(data)
+	if err != nil {
+		syscall.Munmap(data)
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	runtime.SetFinalizer(idx, (*Index).Close)
+	return idx, nil
+}
+
+func newIndex(data []byte) (*Index, error) {
+	if len(data) < indexHeaderSize || string(data[:8]) != IndexMagic {
+		return nil, fmt.Errorf("not an index file")
+	}
+	version := bo.Uint32(data[8:])
+	if version != IndexVersion {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+	numNodes := uint64(bo.Uint32(data[16:]))
+	numEdges := uint64(bo.Uint32(data[20:]))
+	numRecords := uint64(bo.Uint32(data[24:]))
+	numStrings := uint64(bo.Uint32(data[28:]))
+
+	size := indexHeaderSize + numNodes*indexNodeSize +
+		numEdges*indexEdgeSize + numRecords*indexRuleSize + numStrings
+	if size != uint64(len(data)) || numNodes == 0 {
+		return nil, fmt.Errorf("corrupted index")
+	}
+
+	idx := &Index{
+		data:  data,
+		rules: int(bo.Uint32(data[12:])),
+	}
+	ofs := uint64(indexHeaderSize)
+	idx.nodes = data[ofs : ofs+numNodes*indexNodeSize]
+	ofs += numNodes * indexNodeSize
+	idx.edges = data[ofs : ofs+numEdges*indexEdgeSize]
+	ofs += numEdges * indexEdgeSize
+	idx.records = data[ofs : ofs+numRecords*indexRuleSize]
+	ofs += numRecords * indexRuleSize
+	idx.strings = data[ofs:]
+
+	return idx, nil
+}
+
+// Close unmaps the index. The index must not be used after it is
+// closed.
+func (idx *Index) Close() error {
+	if idx.data == nil {
+		return nil
+	}
+	runtime.SetFinalizer(idx, nil)
+	data := idx.data
+	idx.data = nil
+	idx.nodes = nil
+	idx.edges = nil
+	idx.records = nil
+	idx.strings = nil
+	return syscall.Munmap(data)
+}
+
+// Len returns the number of rules in the index.
+func (idx *Index) Len() int {
+	return idx.rules
+}
+
+// node returns the node record. The node IDs are 1-based and the
+// function returns nil for invalid IDs.
+func (idx *Index) node(id uint32) []byte {
+	if id == 0 || uint64(id)*indexNodeSize > uint64(len(idx.nodes)) {
+		return nil
+	}
+	ofs := (id - 1) * indexNodeSize
+	return idx.nodes[ofs : ofs+indexNodeSize]
+}
+
+// str returns the string from the string table. The returned string
+// refers to the mapped index data, and it must be copied if it is
+// retained after the lookup.
+func (idx *Index) str(ofs, length uint32) (string, bool) {
+	end := uint64(ofs) + uint64(length)
+	if end > uint64(len(idx.strings)) {
+		return "", false
+	}
+	if length == 0 {
+		return "", true
+	}
+	return unsafe.String(&idx.strings[ofs], int(length)), true
+}
+
+// child finds the child node of the label.
+func (idx *Index) child(node []byte, label string) uint32 {
+	start := uint64(bo.Uint32(node[0:]))
+	count := uint64(bo.Uint32(node[4:]))
+	if (start+count)*indexEdgeSize > uint64(len(idx.edges)) {
+		return 0
+	}
+	edges := idx.edges[start*indexEdgeSize : (start+count)*indexEdgeSize]
+
+	// Binary search the edges.
+	lo, hi := 0, int(count)
+	for lo < hi {
+		i := int(uint(lo+hi) >> 1)
+		edge := edges[i*indexEdgeSize:]
+		str, ok := idx.str(bo.Uint32(edge[0:]), bo.Uint32(edge[4:]))
+		if !ok {
+			return 0
+		}
+		switch {
+		case str == label:
+			return bo.Uint32(edge[8:])
+		case str < label:
+			lo = i + 1
+		default:
+			hi = i
+		}
+	}
+	return 0
+}
+
+// Match finds the rule for the labels. The function has the same
+// semantics as Matcher.Match.
+func (idx *Index) Match(labels Labels) *Rule {
+	if idx == nil || idx.data == nil {
+		return nil
+	}
+	var buf [2][16]uint32
+
+	states := idx.closure(append(buf[0][:0], 1))
+	next := buf[1][:0]
+
+	for i := len(labels) - 1; i >= 0 && len(states) > 0; i-- {
+		label := labels[i]
+		next = next[:0]
+		for _, id := range states {
+			node := idx.node(id)
+			if bo.Uint32(node[20:])&indexNodeWild != 0 {
+				next = addIndexState(next, id)
+			}
+			star := bo.Uint32(node[8:])
+			if idx.node(star) != nil {
+				next = addIndexState(next, star)
+			}
+			child := idx.child(node, label)
+			if idx.node(child) != nil {
+				next = addIndexState(next, child)
+			}
+		}
+		states, next = idx.closure(next), states
+	}
+
+	var rule *Rule
+	var best []byte
+	for _, id := range states {
+		rec := idx.record(bo.Uint32(idx.node(id)[16:]))
+		if rec == nil {
+			continue
+		}
+		if best == nil || indexRuleBetter(rec, best) {
+			best = rec
+		}
+	}
+	if best != nil {
+		rule = idx.rule(best)
+	}
+	// Keep the index mapped until the lookup is done.
+	runtime.KeepAlive(idx)
+
+	return rule
+}
+
+func (idx *Index) closure(states []uint32) []uint32 {
+	for i := 0; i < len(states); i++ {
+		glob := bo.Uint32(idx.node(states[i])[12:])
+		if idx.node(glob) != nil {
+			states = addIndexState(states, glob)
+		}
+	}
+	return states
+}
+
+func addIndexState(states []uint32, id uint32) []uint32 {
+	for _, s := range states {
+		if s == id {
+			return states
+		}
+	}
+	return append(states, id)
+}
+
+// record returns the rule record. The record IDs are 1-based and the
+// function returns nil for invalid IDs.
+func (idx *Index) record(id uint32) []byte {
+	if id == 0 || uint64(id)*indexRuleSize > uint64(len(idx.records)) {
+		return nil
+	}
+	ofs := (id - 1) * indexRuleSize
+	return idx.records[ofs : ofs+indexRuleSize]
+}
+
+func indexRuleBetter(rec, best []byte) bool {
+	spec, bestSpec := rec[11], best[11]
+	if spec != bestSpec {
+		return spec > bestSpec
+	}
+	allow := rec[10]&indexRuleAllow != 0
+	bestAllow := best[10]&indexRuleAllow != 0
+	if allow != bestAllow {
+		return allow
+	}
+	return bo.Uint32(rec[0:]) < bo.Uint32(best[0:])
+}
+
+// rule creates the rule from the rule record.
+func (idx *Index) rule(rec []byte) *Rule {
+	pattern, ok := idx.str(bo.Uint32(rec[4:]), uint32(bo.Uint16(rec[8:])))
+	if !ok {
+		return nil
+	}
+	rule := &Rule{
+		Pattern: NewLabels(strings.Clone(pattern)),
+		Allow:   rec[10]&indexRuleAllow != 0,
+	}
+	resp, ok := idx.str(bo.Uint32(rec[12:]), bo.Uint32(rec[16:]))
+	if ok && len(resp) > 0 {
+		rule.Response, _ = ParseBlockResponse(resp)
+	}
+	return rule
+}
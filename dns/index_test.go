@@ -0,0 +1,135 @@
+//
+// index_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestIndex(t testing.TB, rules []Rule) string {
+	name := filepath.Join(t.TempDir(), "test.idx")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := WriteIndex(f, rules); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestIndex(t *testing.T) {
+	sinkhole, _ := ParseBlockResponse("192.0.2.1,2001:db8::1")
+	rules := append([]Rule{
+		{
+			Pattern:  NewLabels("sinkhole.example.net"),
+			Response: sinkhole,
+		},
+	}, matcherRules...)
+
+	name := writeTestIndex(t, rules)
+	ok, err := IsIndex(name)
+	if err != nil || !ok {
+		t.Fatalf("IsIndex: %v %v", ok, err)
+	}
+	idx, err := OpenIndex(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	if idx.Len() != len(rules) {
+		t.Errorf("index has %d rules, expected %d", idx.Len(), len(rules))
+	}
+	for _, name := range append(matcherNames, "sinkhole.example.net") {
+		labels := NewLabels(name)
+		expected := MatchRule(rules, labels)
+		rule := idx.Match(labels)
+		if (rule == nil) != (expected == nil) ||
+			(rule != nil && rule.String() != expected.String()) {
+			t.Errorf("%s: matched %v, expected %v", name, rule, expected)
+		}
+	}
+}
+
+func TestIndexCorrupted(t *testing.T) {
+	name := writeTestIndex(t, matcherRules)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data[:len(data)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenIndex(name)
+	if err == nil {
+		t.Errorf("truncated index opened")
+	}
+	if err := os.WriteFile(name, []byte("*.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := IsIndex(name)
+	if err != nil || ok {
+		t.Errorf("IsIndex(blacklist)=%v,%v", ok, err)
+	}
+}
+
+func TestListWatcherIndex(t *testing.T) {
+	proxy, _ := newTestProxy(t)
+
+	bl := filepath.Join(t.TempDir(), "test.bl")
+	err := os.WriteFile(bl, []byte("@@www.ads.example.com\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher := NewListWatcher(proxy,
+		ListFile{
+			Name: writeTestIndex(t, []Rule{
+				{Pattern: NewLabels("**.ads.example.com")},
+			}),
+		},
+		ListFile{
+			Name: bl,
+		})
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	proxy.m.Lock()
+	blacklist := proxy.blacklist
+	proxy.m.Unlock()
+
+	if blacklist.Len() != 2 {
+		t.Errorf("blacklist has %d rules, expected 2", blacklist.Len())
+	}
+	rule := blacklist.Match(NewLabels("x.ads.example.com"))
+	if rule == nil || rule.Allow {
+		t.Errorf("x.ads.example.com: matched %v", rule)
+	}
+	rule = blacklist.Match(NewLabels("www.ads.example.com"))
+	if rule == nil || !rule.Allow {
+		t.Errorf("www.ads.example.com: matched %v", rule)
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	idx, err := OpenIndex(writeTestIndex(b, benchmarkRules(10000)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer idx.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Match(benchmarkNames[i%len(benchmarkNames)])
+	}
+}
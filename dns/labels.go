@@ -8,6 +8,8 @@ package dns
 
 import (
 	"strings"
+
+	"golang.org/x/net/publicsuffix"
 )
 
 // Labels define DNS labels.
@@ -22,11 +24,36 @@ func (l Labels) String() string {
 	return strings.Join(l, ".")
 }
 
+// Domain returns the registrable domain of the labels in lowercase:
+// the public suffix and the label before it. If the labels are a
+// public suffix, the function returns the labels.
+func (l Labels) Domain() string {
+	name := strings.TrimSuffix(strings.ToLower(l.String()), ".")
+	domain, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return name
+	}
+	return domain
+}
+
 // Match tests if the argument labels match this label instance.
 func (l Labels) Match(o Labels) bool {
 	return glob(l, o)
 }
 
+// Specificity returns the number of non-wildcard labels in the
+// pattern. When multiple patterns match a name, the pattern with the
+// highest specificity is the most specific match.
+func (l Labels) Specificity() int {
+	var result int
+	for _, label := range l {
+		if label != "*" && label != "**" {
+			result++
+		}
+	}
+	return result
+}
+
 func glob(value, pattern []string) bool {
 	for {
 		if len(pattern) == 0 {
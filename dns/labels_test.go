@@ -60,3 +60,21 @@ func TestEmptyGlob(t *testing.T) {
 		t.Errorf("Empty glob match failed")
 	}
 }
+
+func TestDomain(t *testing.T) {
+	tests := map[string]string{
+		"www.example.com":        "example.com",
+		"x1y2z3.WWW.Example.com": "example.com",
+		"www.example.co.uk":      "example.co.uk",
+		"www.other.co.uk":        "other.co.uk",
+		"user.github.io":         "user.github.io",
+		"co.uk":                  "co.uk",
+		"localhost":              "localhost",
+	}
+	for name, expected := range tests {
+		domain := NewLabels(name).Domain()
+		if domain != expected {
+			t.Errorf("%s: got %s, expected %s", name, domain, expected)
+		}
+	}
+}
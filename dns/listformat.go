@@ -0,0 +1,446 @@
+//
+// listformat.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Blacklist file formats.
+//
+
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ListFormat defines the blacklist file format.
+type ListFormat int
+
+// Blacklist file formats.
+const (
+	FormatAuto ListFormat = iota
+	FormatGlob
+	FormatHosts
+	FormatAdBlock
+	FormatDnsmasq
+	FormatRPZ
+	FormatIndex
+)
+
+var listFormats = map[ListFormat]string{
+	FormatAuto:    "auto",
+	FormatGlob:    "glob",
+	FormatHosts:   "hosts",
+	FormatAdBlock: "adblock",
+	FormatDnsmasq: "dnsmasq",
+	FormatRPZ:     "rpz",
+	FormatIndex:   "index",
+}
+
+func (f ListFormat) String() string {
+	name, ok := listFormats[f]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{ListFormat %d}", f)
+}
+
+// ParseListFormat parses the blacklist format name.
+func ParseListFormat(name string) (ListFormat, error) {
+	for format, n := range listFormats {
+		if n == strings.ToLower(name) {
+			return format, nil
+		}
+	}
+	return FormatAuto, fmt.Errorf("unknown blacklist format: %s", name)
+}
+
+// DetectListFormat detects the blacklist format from the file
+// lines. The format is detected from the first non-comment line and
+// it defaults to FormatGlob.
+func DetectListFormat(lines []string) ListFormat {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		switch line[0] {
+		case '#', ';':
+			continue
+		case '!':
+			return FormatAdBlock
+		case '[':
+			if strings.HasPrefix(strings.ToLower(line), "[adblock") {
+				return FormatAdBlock
+			}
+		case '$', '@':
+			if !strings.HasPrefix(line, AllowPrefix) {
+				return FormatRPZ
+			}
+		}
+		if strings.HasPrefix(line, "||") || strings.HasPrefix(line, "@@||") {
+			return FormatAdBlock
+		}
+		if strings.HasPrefix(line, "address=/") ||
+			strings.HasPrefix(line, "local=/") {
+			return FormatDnsmasq
+		}
+		fields := strings.Fields(line)
+		if net.ParseIP(fields[0]) != nil {
+			return FormatHosts
+		}
+		for _, field := range fields[1:] {
+			switch strings.ToUpper(field) {
+			case "IN", "CNAME", "SOA", "NS":
+				return FormatRPZ
+			}
+		}
+		return FormatGlob
+	}
+	return FormatGlob
+}
+
+// lineParser parses one blacklist line. It returns nil rules for
+// empty and comment lines.
+type lineParser func(line string) ([]Rule, error)
+
+func newLineParser(format ListFormat, allow bool) lineParser {
+	switch format {
+	case FormatHosts:
+		return parseHostsLine
+	case FormatAdBlock:
+		return parseAdBlockLine
+	case FormatDnsmasq:
+		return parseDnsmasqLine
+	case FormatRPZ:
+		return new(rpzParser).parse
+	default:
+		return func(line string) ([]Rule, error) {
+			return parseGlobLine(line, allow)
+		}
+	}
+}
+
+func parseGlobLine(line string, allow bool) ([]Rule, error) {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' {
+		return nil, nil
+	}
+	rule := Rule{
+		Allow: allow,
+	}
+	if strings.HasPrefix(line, AllowPrefix) {
+		rule.Allow = true
+		line = line[len(AllowPrefix):]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	if len(fields) > 2 {
+		return nil, fmt.Errorf("invalid rule: %s", line)
+	}
+	rule.Pattern = NewLabels(strings.ToLower(fields[0]))
+	if len(fields) > 1 {
+		if rule.Allow {
+			return nil, fmt.Errorf("block response for allow rule")
+		}
+		var err error
+		rule.Response, err = ParseBlockResponse(fields[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []Rule{rule}, nil
+}
+
+// hostsIgnore lists the names that the hosts blacklists define for
+// the local host. They are not block rules.
+var hostsIgnore = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// parseHostsLine parses the hosts file lines:
+//
+//	0.0.0.0 ads.example.com [tracker.example.com...]
+func parseHostsLine(line string) ([]Rule, error) {
+	idx := strings.IndexByte(line, '#')
+	if idx >= 0 {
+		line = line[:idx]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		return nil, fmt.Errorf("invalid hosts entry: %s", line)
+	}
+	var result []Rule
+	for _, host := range fields[1:] {
+		host = normalizeName(host)
+		if hostsIgnore[host] {
+			continue
+		}
+		if !validName(host) {
+			return nil, fmt.Errorf("invalid name: %s", host)
+		}
+		result = append(result, Rule{
+			Pattern: NewLabels(host),
+		})
+	}
+	return result, nil
+}
+
+// parseAdBlockLine parses the AdBlock Plus domain rules:
+//
+//	||ads.example.com^
+//	@@||www.example.com^
+//
+// The domain rules match the domain and all its subdomains. Rules
+// with options and other than domain rules are not supported.
+func parseAdBlockLine(line string) ([]Rule, error) {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || line[0] == '!' || line[0] == '#' || line[0] == '[' {
+		return nil, nil
+	}
+	var rule Rule
+	if strings.HasPrefix(line, AllowPrefix) {
+		rule.Allow = true
+		line = line[len(AllowPrefix):]
+	}
+	if !strings.HasPrefix(line, "||") {
+		// Plain hostname rules.
+		host := normalizeName(line)
+		if !validName(host) {
+			return nil, fmt.Errorf("unsupported rule: %s", line)
+		}
+		rule.Pattern = NewLabels(host)
+		return []Rule{rule}, nil
+	}
+	idx := strings.IndexByte(line, '^')
+	if idx < 0 || idx+1 != len(line) {
+		return nil, fmt.Errorf("unsupported rule: %s", line)
+	}
+	host := normalizeName(line[2:idx])
+	if !validName(host) {
+		return nil, fmt.Errorf("invalid name: %s", host)
+	}
+	rule.Pattern = NewLabels("**." + host)
+	return []Rule{rule}, nil
+}
+
+// parseDnsmasqLine parses the dnsmasq address and local lines:
+//
+//	address=/ads.example.com/0.0.0.0
+//	local=/tracker.example.com/
+//
+// The rules match the domains and all their subdomains.
+func parseDnsmasqLine(line string) ([]Rule, error) {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' {
+		return nil, nil
+	}
+	var arg string
+	var ok bool
+	for _, prefix := range []string{"address=/", "local=/"} {
+		arg, ok = strings.CutPrefix(line, prefix)
+		if ok {
+			break
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unsupported directive: %s", line)
+	}
+	parts := strings.Split(arg, "/")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid directive: %s", line)
+	}
+	var result []Rule
+	for _, host := range parts[:len(parts)-1] {
+		host = normalizeName(host)
+		if !validName(host) {
+			return nil, fmt.Errorf("invalid name: %s", host)
+		}
+		result = append(result, Rule{
+			Pattern: NewLabels("**." + host),
+		})
+	}
+	return result, nil
+}
+
+// rpzParser parses Response Policy Zone files. It supports the QNAME
+// triggers with the following actions:
+//
+//	CNAME .              NXDOMAIN
+//	CNAME *.             NODATA
+//	CNAME rpz-passthru.  allow
+//	CNAME rpz-drop.      drop
+//	A, AAAA              sinkhole addresses
+type rpzParser struct {
+	origin string
+	owner  string
+	paren  bool
+	rules  map[string]*Rule
+}
+
+func (p *rpzParser) parse(line string) ([]Rule, error) {
+	idx := strings.IndexByte(line, ';')
+	if idx >= 0 {
+		line = line[:idx]
+	}
+	if p.paren {
+		if strings.IndexByte(line, ')') >= 0 {
+			p.paren = false
+		}
+		return nil, nil
+	}
+	if strings.IndexByte(line, '(') >= 0 && strings.IndexByte(line, ')') < 0 {
+		p.paren = true
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "$TTL":
+		return nil, nil
+	case "$ORIGIN":
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid $ORIGIN: %s", line)
+		}
+		p.origin = strings.ToLower(fields[1])
+		return nil, nil
+	}
+	if line[0] != ' ' && line[0] != '\t' {
+		p.owner = strings.ToLower(fields[0])
+		fields = fields[1:]
+	}
+
+	// Skip TTL and class.
+	for len(fields) > 0 {
+		if fields[0][0] >= '0' && fields[0][0] <= '9' {
+			fields = fields[1:]
+		} else if strings.EqualFold(fields[0], "IN") {
+			fields = fields[1:]
+		} else {
+			break
+		}
+	}
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid record: %s", line)
+	}
+	rrType := strings.ToUpper(fields[0])
+	value := fields[1]
+
+	if p.owner == "@" || (len(p.origin) > 0 && p.owner == p.origin) {
+		// Zone apex records.
+		return nil, nil
+	}
+	name := p.owner
+	if strings.HasSuffix(name, ".") {
+		if len(p.origin) == 0 || !strings.HasSuffix(name, "."+p.origin) {
+			return nil, fmt.Errorf("name outside zone: %s", name)
+		}
+		name = strings.TrimSuffix(name, "."+p.origin)
+	}
+	if !validName(strings.TrimPrefix(name, "*.")) ||
+		strings.Contains(name, ".rpz-") {
+		// IP, NSDNAME, and NSIP triggers are not supported.
+		return nil, fmt.Errorf("unsupported trigger: %s", name)
+	}
+
+	rule := &Rule{
+		Pattern: NewLabels(name),
+	}
+	switch rrType {
+	case "CNAME":
+		switch strings.ToLower(value) {
+		case ".":
+			rule.Response = &BlockResponse{
+				Mode: BlockNXDomain,
+			}
+		case "*.":
+			rule.Response = &BlockResponse{
+				Mode: BlockNoData,
+			}
+		case "rpz-passthru.":
+			rule.Allow = true
+		case "rpz-drop.":
+			rule.Response = &BlockResponse{
+				Mode: BlockDrop,
+			}
+		default:
+			return nil, fmt.Errorf("unsupported action: CNAME %s", value)
+		}
+
+	case "A", "AAAA":
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %s", value)
+		}
+		if p.rules == nil {
+			p.rules = make(map[string]*Rule)
+		}
+		prev, ok := p.rules[name]
+		if ok {
+			// Add the address to the previous sinkhole rule.
+			if ip.To4() != nil {
+				prev.Response.IPv4 = ip.To4()
+			} else {
+				prev.Response.IPv6 = ip
+			}
+			return nil, nil
+		}
+		rule.Response = &BlockResponse{
+			Mode: BlockSinkhole,
+		}
+		if ip.To4() != nil {
+			rule.Response.IPv4 = ip.To4()
+		} else {
+			rule.Response.IPv6 = ip
+		}
+		p.rules[name] = rule
+
+	case "SOA", "NS":
+		return nil, fmt.Errorf("%s record outside zone apex", rrType)
+
+	default:
+		return nil, fmt.Errorf("unsupported record type: %s", fields[0])
+	}
+	return []Rule{*rule}, nil
+}
+
+// validName tests if the argument is a valid domain name.
+func validName(name string) bool {
+	if len(name) == 0 || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		for _, ch := range label {
+			switch {
+			case ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9',
+				ch == '-', ch == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
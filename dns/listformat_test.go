@@ -0,0 +1,141 @@
+//
+// listformat_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var listFormatTests = []struct {
+	format ListFormat
+	input  string
+	rules  []string
+	bad    []int
+}{
+	{
+		format: FormatGlob,
+		input: `# Glob rules
+*.doubleclick.net
+Ads.Example.com nodata
+@@pubads.g.doubleclick.net
+ads.example.org invalid-response
+`,
+		rules: []string{
+			"*.doubleclick.net",
+			"ads.example.com nodata",
+			"@@pubads.g.doubleclick.net",
+		},
+		bad: []int{5},
+	},
+	{
+		format: FormatHosts,
+		input: `# Hosts blacklist
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.com # trackers
+0.0.0.0
+0.0.0.0 bad!name.example.com
+`,
+		rules: []string{
+			"ads.example.com",
+			"tracker.example.com",
+		},
+		bad: []int{4, 5},
+	},
+	{
+		format: FormatAdBlock,
+		input: `[Adblock Plus 2.0]
+! Title: test list
+||ads.example.com^
+@@||www.example.com^
+tracker.example.com
+||example.org/ads/*
+example.com##.banner
+`,
+		rules: []string{
+			"**.ads.example.com",
+			"@@**.www.example.com",
+			"tracker.example.com",
+		},
+		bad: []int{6, 7},
+	},
+	{
+		format: FormatDnsmasq,
+		input: `# dnsmasq blacklist
+address=/ads.example.com/0.0.0.0
+address=/a.example.org/b.example.org/
+local=/tracker.example.com/
+server=/example.net/192.0.2.1
+`,
+		rules: []string{
+			"**.ads.example.com",
+			"**.a.example.org",
+			"**.b.example.org",
+			"**.tracker.example.com",
+		},
+		bad: []int{5},
+	},
+	{
+		format: FormatRPZ,
+		input: `$TTL 2h
+$ORIGIN rpz.example.
+@ IN SOA localhost. root.localhost. (
+    1 6h 1h 1w 2h )
+  IN NS localhost.
+ads.example.com        CNAME .
+*.ads.example.com      CNAME .
+nodata.example.com 300 CNAME *.
+www.example.com        CNAME rpz-passthru.
+drop.example.com       CNAME rpz-drop.
+sink.example.com.rpz.example. IN A 192.0.2.1
+                       IN AAAA 2001:db8::1
+other.example.com      CNAME www.example.org.
+32.1.0.0.127.rpz-ip    CNAME .
+`,
+		rules: []string{
+			"ads.example.com nxdomain",
+			"*.ads.example.com nxdomain",
+			"nodata.example.com nodata",
+			"@@www.example.com",
+			"drop.example.com drop",
+			"sink.example.com 192.0.2.1,2001:db8::1",
+		},
+		bad: []int{13, 14},
+	},
+}
+
+func TestListFormats(t *testing.T) {
+	for _, test := range listFormatTests {
+		lines := strings.Split(test.input, "\n")
+		format := DetectListFormat(lines)
+		if format != test.format {
+			t.Errorf("%s: detected format %s", test.format, format)
+		}
+		rules, err := parseRules("test", lines, test.format, false)
+		var rs []string
+		for _, rule := range rules {
+			rs = append(rs, rule.String())
+		}
+		if !reflect.DeepEqual(rs, test.rules) {
+			t.Errorf("%s: got rules %q, expected %q", test.format, rs,
+				test.rules)
+		}
+		var listErr *ListError
+		if !errors.As(err, &listErr) {
+			t.Errorf("%s: expected ListError, got %v", test.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(listErr.Lines, test.bad) {
+			t.Errorf("%s: unparseable lines %v, expected %v", test.format,
+				listErr.Lines, test.bad)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+//
+// local.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Local static records.
+//
+
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// DefaultLocalTTL defines the default TTL of local records.
+const DefaultLocalTTL = 60
+
+// dnsTypeANY defines the QTYPE for all records (RFC 1035 section
+// 3.2.3). The gopacket layers package does not define it.
+const dnsTypeANY layers.DNSType = 255
+
+// MaxCNAMEChain defines the maximum length of CNAME chains the proxy
+// follows.
+const MaxCNAMEChain = 8
+
+// LocalRecords implements local static records that the proxy
+// answers without asking any upstream servers.
+type LocalRecords struct {
+	m       sync.Mutex
+	records map[string][]layers.DNSResourceRecord
+}
+
+// NewLocalRecords creates a new empty local records set.
+func NewLocalRecords() *LocalRecords {
+	return &LocalRecords{
+		records: make(map[string][]layers.DNSResourceRecord),
+	}
+}
+
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// Add adds the resource record.
+func (l *LocalRecords) Add(rr layers.DNSResourceRecord) {
+	name := normalizeName(string(rr.Name))
+	rr.Name = []byte(name)
+
+	l.m.Lock()
+	l.records[name] = append(l.records[name], rr)
+	l.m.Unlock()
+}
+
+// Len returns the number of local records.
+func (l *LocalRecords) Len() int {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	var count int
+	for _, rrs := range l.records {
+		count += len(rrs)
+	}
+	return count
+}
+
+// Lookup finds the local records for the question. The function
+// returns the answer records and a boolean indicating if the name
+// has local records. If the answer ends with a CNAME record whose
+// target is not local, the target is returned in the target
+// argument.
+func (l *LocalRecords) Lookup(q layers.DNSQuestion) (
+	answers []layers.DNSResourceRecord, target string, ok bool) {
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	name := normalizeName(string(q.Name))
+	for i := 0; i < MaxCNAMEChain; i++ {
+		rrs, found := l.records[name]
+		if !found {
+			if i == 0 {
+				return nil, "", false
+			}
+			return answers, name, true
+		}
+		var cname *layers.DNSResourceRecord
+		for idx, rr := range rrs {
+			if rr.Class != q.Class && q.Class != layers.DNSClassAny {
+				continue
+			}
+			if rr.Type == q.Type || q.Type == dnsTypeANY {
+				answers = append(answers, rr)
+			} else if rr.Type == layers.DNSTypeCNAME {
+				cname = &rrs[idx]
+			}
+		}
+		if cname == nil || q.Type == layers.DNSTypeCNAME {
+			return answers, "", true
+		}
+		answers = append(answers, *cname)
+		name = normalizeName(string(cname.CNAME))
+	}
+	return answers, "", true
+}
+
+// ReadHosts reads the local records from the hosts file. The file
+// has the /etc/hosts syntax:
+//
+//	IP-ADDRESS NAME [ALIAS...]
+//
+// The function creates A or AAAA records for the names and PTR
+// records for the addresses. Like in the resolver libraries, the PTR
+// record of an address points to the first name of its first line.
+func (l *LocalRecords) ReadHosts(name string, ttl uint32) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var lineNum int
+	reverse := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		idx := strings.IndexByte(line, '#')
+		if idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("%s:%d: invalid hosts entry: %s",
+				name, lineNum, line)
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			return fmt.Errorf("%s:%d: invalid IP address: %s",
+				name, lineNum, fields[0])
+		}
+		rrType := layers.DNSTypeAAAA
+		if ip.To4() != nil {
+			ip = ip.To4()
+			rrType = layers.DNSTypeA
+		}
+		for _, host := range fields[1:] {
+			l.Add(layers.DNSResourceRecord{
+				Name:  []byte(host),
+				Type:  rrType,
+				Class: layers.DNSClassIN,
+				TTL:   ttl,
+				IP:    ip,
+			})
+		}
+		ptr := ReverseName(ip)
+		if reverse[ptr] {
+			continue
+		}
+		reverse[ptr] = true
+		l.Add(layers.DNSResourceRecord{
+			Name:  []byte(ptr),
+			Type:  layers.DNSTypePTR,
+			Class: layers.DNSClassIN,
+			TTL:   ttl,
+			PTR:   []byte(normalizeName(fields[1])),
+		})
+	}
+	return scanner.Err()
+}
+
+// ReadRecords reads the local records from the records file. Each
+// line of the file defines one record:
+//
+//	NAME [TTL] TYPE VALUE
+//
+// The supported types are A, AAAA, CNAME, TXT, and PTR. The TTL
+// defaults to the ttl argument.
+func (l *LocalRecords) ReadRecords(name string, ttl uint32) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var lineNum int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		rr, err := parseRecord(line, ttl)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", name, lineNum, err)
+		}
+		l.Add(rr)
+	}
+	return scanner.Err()
+}
+
+func parseRecord(line string, ttl uint32) (layers.DNSResourceRecord, error) {
+	rr := layers.DNSResourceRecord{
+		Class: layers.DNSClassIN,
+		TTL:   ttl,
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return rr, fmt.Errorf("invalid record: %s", line)
+	}
+	rr.Name = []byte(fields[0])
+	fields = fields[1:]
+
+	v, err := strconv.ParseUint(fields[0], 10, 32)
+	if err == nil {
+		rr.TTL = uint32(v)
+		fields = fields[1:]
+		if len(fields) < 2 {
+			return rr, fmt.Errorf("invalid record: %s", line)
+		}
+	}
+
+	value := fields[1]
+	switch strings.ToUpper(fields[0]) {
+	case "A":
+		rr.Type = layers.DNSTypeA
+		rr.IP = net.ParseIP(value).To4()
+		if rr.IP == nil {
+			return rr, fmt.Errorf("invalid IPv4 address: %s", value)
+		}
+
+	case "AAAA":
+		rr.Type = layers.DNSTypeAAAA
+		rr.IP = net.ParseIP(value)
+		if rr.IP == nil || rr.IP.To4() != nil {
+			return rr, fmt.Errorf("invalid IPv6 address: %s", value)
+		}
+
+	case "CNAME":
+		rr.Type = layers.DNSTypeCNAME
+		rr.CNAME = []byte(normalizeName(value))
+
+	case "PTR":
+		rr.Type = layers.DNSTypePTR
+		rr.PTR = []byte(normalizeName(value))
+
+	case "TXT":
+		rr.Type = layers.DNSTypeTXT
+		txt := strings.Join(fields[1:], " ")
+		if len(txt) >= 2 && txt[0] == '"' && txt[len(txt)-1] == '"' {
+			txt = txt[1 : len(txt)-1]
+		}
+		for len(txt) > 255 {
+			rr.TXTs = append(rr.TXTs, []byte(txt[:255]))
+			txt = txt[255:]
+		}
+		rr.TXTs = append(rr.TXTs, []byte(txt))
+
+	default:
+		return rr, fmt.Errorf("unsupported record type: %s", fields[0])
+	}
+	return rr, nil
+}
+
+// ReverseName returns the reverse lookup name for the IP address.
+func ReverseName(ip net.IP) string {
+	var labels []string
+
+	ip4 := ip.To4()
+	if ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(ip4[i])))
+		}
+		return strings.Join(labels, ".") + ".in-addr.arpa"
+	}
+	ip = ip.To16()
+	for i := len(ip) - 1; i >= 0; i-- {
+		labels = append(labels, strconv.FormatUint(uint64(ip[i]&0xf), 16))
+		labels = append(labels, strconv.FormatUint(uint64(ip[i]>>4), 16))
+	}
+	return strings.Join(labels, ".") + ".ip6.arpa"
+}
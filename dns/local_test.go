@@ -0,0 +1,127 @@
+//
+// local_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+var localRecords = []string{
+	"dev.example.com A 127.0.0.1",
+	"dev.example.com 30 AAAA ::1",
+	"alias.example.com CNAME dev.example.com",
+	"external.example.com CNAME www.example.org.",
+	`txt.example.com TXT "hello, world"`,
+}
+
+func TestLocalLookup(t *testing.T) {
+	local := NewLocalRecords()
+	for _, line := range localRecords {
+		rr, err := parseRecord(line, DefaultLocalTTL)
+		if err != nil {
+			t.Fatalf("parseRecord(%s): %s", line, err)
+		}
+		local.Add(rr)
+	}
+
+	answers, target, ok := local.Lookup(question("Dev.Example.com",
+		layers.DNSTypeAAAA))
+	if !ok || len(target) != 0 || len(answers) != 1 {
+		t.Fatalf("AAAA lookup failed: %v %v %v", answers, target, ok)
+	}
+	if answers[0].TTL != 30 || !answers[0].IP.Equal(net.IPv6loopback) {
+		t.Errorf("unexpected AAAA answer: %v", answers[0])
+	}
+
+	answers, _, ok = local.Lookup(question("alias.example.com",
+		layers.DNSTypeA))
+	if !ok || len(answers) != 2 ||
+		answers[0].Type != layers.DNSTypeCNAME ||
+		answers[1].Type != layers.DNSTypeA {
+		t.Errorf("CNAME lookup failed: %v", answers)
+	}
+
+	answers, target, ok = local.Lookup(question("external.example.com",
+		layers.DNSTypeA))
+	if !ok || len(answers) != 1 || target != "www.example.org" {
+		t.Errorf("external CNAME lookup failed: %v %v", answers, target)
+	}
+
+	answers, _, ok = local.Lookup(question("txt.example.com",
+		layers.DNSTypeA))
+	if !ok || len(answers) != 0 {
+		t.Errorf("NODATA lookup failed: %v %v", answers, ok)
+	}
+
+	_, _, ok = local.Lookup(question("www.example.com", layers.DNSTypeA))
+	if ok {
+		t.Errorf("lookup of non-local name succeeded")
+	}
+}
+
+func TestReadHosts(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "hosts")
+	err := os.WriteFile(name, []byte(`# Local hosts.
+127.0.0.1	localhost
+192.0.2.1	www.example.com www
+192.0.2.1	mail.example.com
+::1		localhost ip6-localhost
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := NewLocalRecords()
+	err = local.ReadHosts(name, DefaultLocalTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answers, _, ok := local.Lookup(question("mail.example.com",
+		layers.DNSTypeA))
+	if !ok || len(answers) != 1 ||
+		!answers[0].IP.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("A lookup failed: %v", answers)
+	}
+
+	answers, _, ok = local.Lookup(question(
+		ReverseName(net.IPv4(192, 0, 2, 1)), layers.DNSTypePTR))
+	if !ok || len(answers) != 1 ||
+		string(answers[0].PTR) != "www.example.com" {
+		t.Errorf("PTR lookup failed: %v", answers)
+	}
+
+	answers, _, ok = local.Lookup(question(ReverseName(net.IPv6loopback),
+		layers.DNSTypePTR))
+	if !ok || len(answers) != 1 || string(answers[0].PTR) != "localhost" {
+		t.Errorf("IPv6 PTR lookup failed: %v", answers)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		name string
+	}{
+		{"192.0.2.1", "1.2.0.192.in-addr.arpa"},
+		{"2001:db8::567:89ab",
+			"b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+	}
+	for _, test := range tests {
+		name := ReverseName(net.ParseIP(test.ip))
+		if name != test.name {
+			t.Errorf("ReverseName(%s)=%s, expected %s", test.ip, name,
+				test.name)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+//
+// matcher.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Compiled blacklist rule matcher.
+//
+
+package dns
+
+// RuleMatcher matches names against blacklist rules.
+type RuleMatcher interface {
+	// Match finds the rule for the labels. If multiple rules match
+	// the labels, the most specific rule wins, and allow rules win
+	// block rules with equal specificity. The function returns nil
+	// if no rules match the labels.
+	Match(labels Labels) *Rule
+	// Len returns the number of rules.
+	Len() int
+}
+
+// Matchers combines multiple rule matchers. The rules of the matchers
+// are compared with the RuleMatcher precedence, and the earlier
+// matchers win the rules with equal precedence.
+type Matchers []RuleMatcher
+
+// Match implements RuleMatcher.Match.
+func (ms Matchers) Match(labels Labels) *Rule {
+	var best *Rule
+	var bestSpecificity int
+
+	for _, m := range ms {
+		rule := m.Match(labels)
+		if rule == nil {
+			continue
+		}
+		specificity := rule.Pattern.Specificity()
+		if best == nil || specificity > bestSpecificity ||
+			(specificity == bestSpecificity && rule.Allow && !best.Allow) {
+			best = rule
+			bestSpecificity = specificity
+		}
+	}
+	return best
+}
+
+// Len implements RuleMatcher.Len.
+func (ms Matchers) Len() int {
+	var result int
+	for _, m := range ms {
+		result += m.Len()
+	}
+	return result
+}
+
+// Matcher implements a compiled blacklist rule matcher. The rule
+// patterns are stored in a trie of reversed labels so that the
+// lookups walk the name labels from the top-level domain down, and
+// the lookup time depends on the name length instead of the number
+// of rules. The matcher has the same wildcard semantics as
+// Labels.Match and it finds the same rule as MatchRule.
+type Matcher struct {
+	root  *trieNode
+	rules int
+}
+
+// trieNode is a trie node. The star and glob children hold the
+// patterns continuing with the "*" and "**" wildcards.
+type trieNode struct {
+	children map[string]*trieNode
+	star     *trieNode
+	glob     *trieNode
+	// wild specifies if the node is a wildcard node that consumes
+	// any number of additional labels.
+	wild        bool
+	rule        *Rule
+	index       int
+	specificity int
+}
+
+// NewMatcher compiles the rules into a matcher. The rules must not be
+// modified after this call.
+func NewMatcher(rules []Rule) *Matcher {
+	m := &Matcher{
+		root:  new(trieNode),
+		rules: len(rules),
+	}
+	for i := range rules {
+		m.add(&rules[i], i)
+	}
+	return m
+}
+
+// Len returns the number of rules in the matcher.
+func (m *Matcher) Len() int {
+	if m == nil {
+		return 0
+	}
+	return m.rules
+}
+
+func (m *Matcher) add(rule *Rule, index int) {
+	n := m.root
+	for i := len(rule.Pattern) - 1; i >= 0; i-- {
+		label := rule.Pattern[i]
+		// Labels.Match does not match the trailing "**" to an empty
+		// name suffix so it is equal to "*".
+		if label == "**" && i == len(rule.Pattern)-1 {
+			label = "*"
+		}
+		switch label {
+		case "*":
+			if n.star == nil {
+				n.star = &trieNode{
+					wild: true,
+				}
+			}
+			n = n.star
+
+		case "**":
+			if n.glob == nil {
+				n.glob = &trieNode{
+					wild: true,
+				}
+			}
+			n = n.glob
+
+		default:
+			if n.children == nil {
+				n.children = make(map[string]*trieNode)
+			}
+			child, ok := n.children[label]
+			if !ok {
+				child = new(trieNode)
+				n.children[label] = child
+			}
+			n = child
+		}
+	}
+	if n.rule == nil || (rule.Allow && !n.rule.Allow) {
+		n.rule = rule
+		n.index = index
+		n.specificity = rule.Pattern.Specificity()
+	}
+}
+
+// Match finds the rule for the labels. If multiple rules match the
+// labels, the most specific rule wins, and allow rules win block
+// rules with equal specificity. The function returns nil if no rules
+// match the labels.
+func (m *Matcher) Match(labels Labels) *Rule {
+	if m == nil {
+		return nil
+	}
+	var buf [2][16]*trieNode
+
+	states := closure(append(buf[0][:0], m.root))
+	next := buf[1][:0]
+
+	for i := len(labels) - 1; i >= 0 && len(states) > 0; i-- {
+		label := labels[i]
+		next = next[:0]
+		for _, n := range states {
+			if n.wild {
+				next = addState(next, n)
+			}
+			if n.star != nil {
+				next = addState(next, n.star)
+			}
+			child, ok := n.children[label]
+			if ok {
+				next = addState(next, child)
+			}
+		}
+		states, next = closure(next), states
+	}
+
+	var best *trieNode
+	for _, n := range states {
+		if n.rule == nil {
+			continue
+		}
+		if best == nil || n.specificity > best.specificity ||
+			(n.specificity == best.specificity &&
+				((n.rule.Allow && !best.rule.Allow) ||
+					(n.rule.Allow == best.rule.Allow && n.index < best.index))) {
+			best = n
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.rule
+}
+
+// closure adds the "**" wildcard nodes that match zero labels to the
+// states.
+func closure(states []*trieNode) []*trieNode {
+	for i := 0; i < len(states); i++ {
+		if states[i].glob != nil {
+			states = addState(states, states[i].glob)
+		}
+	}
+	return states
+}
+
+func addState(states []*trieNode, n *trieNode) []*trieNode {
+	for _, s := range states {
+		if s == n {
+			return states
+		}
+	}
+	return append(states, n)
+}
@@ -0,0 +1,147 @@
+//
+// matcher_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+var matcherRules = []Rule{
+	{Pattern: NewLabels("*.doubleclick.net")},
+	{Pattern: NewLabels("pubads.g.doubleclick.net"), Allow: true},
+	{Pattern: NewLabels("**.example.com"), Allow: true},
+	{Pattern: NewLabels("**.example.com")},
+	{Pattern: NewLabels("ads.example.com")},
+	{Pattern: NewLabels("ads.*")},
+	{Pattern: NewLabels("*.ad.*")},
+	{Pattern: NewLabels("x.**.y.com")},
+	{Pattern: NewLabels("tracker.**")},
+	{Pattern: NewLabels("**.**.z.org")},
+	{Pattern: NewLabels("*.*.w.org")},
+}
+
+var matcherNames = []string{
+	"doubleclick.net",
+	"ad.doubleclick.net",
+	"a.b.doubleclick.net",
+	"pubads.g.doubleclick.net",
+	"example.com",
+	"www.example.com",
+	"ads.example.com",
+	"ads.example.org",
+	"ads",
+	"web.hb.ad.cpe.dotomi.com",
+	"ad.com",
+	"x.y.com",
+	"x.a.b.y.com",
+	"tracker",
+	"tracker.example.org",
+	"z.org",
+	"a.b.z.org",
+	"a.w.org",
+	"a.b.w.org",
+	"a.b.c.w.org",
+}
+
+func TestMatcher(t *testing.T) {
+	matcher := NewMatcher(matcherRules)
+	if matcher.Len() != len(matcherRules) {
+		t.Errorf("matcher has %d rules, expected %d", matcher.Len(),
+			len(matcherRules))
+	}
+	for _, name := range matcherNames {
+		labels := NewLabels(name)
+		expected := MatchRule(matcherRules, labels)
+		rule := matcher.Match(labels)
+		if rule != expected {
+			t.Errorf("%s: matched %v, expected %v", name, rule, expected)
+		}
+	}
+}
+
+func TestMatcherRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	alphabet := []string{"a", "b", "c", "*", "**"}
+
+	randomLabels := func(wildcards bool) Labels {
+		var result Labels
+		count := 1 + rnd.Intn(4)
+		for i := 0; i < count; i++ {
+			n := 3
+			if wildcards {
+				n = len(alphabet)
+			}
+			result = append(result, alphabet[rnd.Intn(n)])
+		}
+		return result
+	}
+	var rules []Rule
+	for i := 0; i < 50; i++ {
+		rules = append(rules, Rule{
+			Pattern: randomLabels(true),
+			Allow:   rnd.Intn(3) == 0,
+		})
+	}
+	matcher := NewMatcher(rules)
+	for i := 0; i < 10000; i++ {
+		labels := randomLabels(false)
+		expected := MatchRule(rules, labels)
+		rule := matcher.Match(labels)
+		if rule != expected {
+			t.Fatalf("%s: matched %v, expected %v", labels, rule, expected)
+		}
+	}
+}
+
+func benchmarkRules(count int) []Rule {
+	var rules []Rule
+	for i := 0; i < count; i++ {
+		var pattern string
+		switch i % 4 {
+		case 0:
+			pattern = fmt.Sprintf("ads%d.example%d.com", i, i)
+		case 1:
+			pattern = fmt.Sprintf("**.tracker%d.net", i)
+		case 2:
+			pattern = fmt.Sprintf("*.cdn%d.example.org", i)
+		default:
+			pattern = fmt.Sprintf("metrics.site%d.io", i)
+		}
+		rules = append(rules, Rule{
+			Pattern: NewLabels(pattern),
+		})
+	}
+	return rules
+}
+
+var benchmarkNames = []Labels{
+	NewLabels("www.example.com"),
+	NewLabels("a.b.tracker9997.net"),
+	NewLabels("img.cdn9998.example.org"),
+	NewLabels("metrics.site9999.io"),
+}
+
+func BenchmarkMatchRule(b *testing.B) {
+	rules := benchmarkRules(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		MatchRule(rules, benchmarkNames[i%len(benchmarkNames)])
+	}
+}
+
+func BenchmarkMatcher(b *testing.B) {
+	matcher := NewMatcher(benchmarkRules(10000))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matcher.Match(benchmarkNames[i%len(benchmarkNames)])
+	}
+}
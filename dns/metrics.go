@@ -0,0 +1,58 @@
+//
+// metrics.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Proxy and DoH client metrics.
+//
+
+package dns
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/markkurossi/vpn/metrics"
+)
+
+var (
+	metricQueries = metrics.NewCounter("vpn_dns_queries_total",
+		"DNS queries by query type and decision.", "type", "decision")
+	metricCoalesced = metrics.NewCounter("vpn_dns_coalesced_total",
+		"Queries that were coalesced with identical in-flight queries.")
+	metricPrefetch = metrics.NewCounter("vpn_dns_prefetch_total",
+		"Prefetch queries for popular cache entries.")
+	metricUpstreamLatency = metrics.NewHistogram(
+		"vpn_dns_upstream_latency_seconds",
+		"Upstream query latency by transport.", metrics.DefaultBuckets,
+		"transport")
+	metricDoHResponses = metrics.NewCounter("vpn_doh_responses_total",
+		"DoH HTTP responses by status code.", "code")
+	metricDoHSAs = metrics.NewCounter("vpn_doh_sa_created_total",
+		"Security associations created with the DoH proxy.")
+	metricDoHCertificates = metrics.NewCounter(
+		"vpn_doh_certificate_fetches_total",
+		"Certificate fetches from the DoH proxy.")
+)
+
+// RegisterMetrics registers the proxy's gauges to the default
+// metrics registry.
+func (p *Proxy) RegisterMetrics() {
+	metrics.NewGaugeFunc("vpn_dns_pending_queries",
+		"In-flight upstream queries.", func() float64 {
+			return float64(p.inFlight())
+		})
+}
+
+// do sends the HTTP request and counts the response status code.
+func (doh *DoHClient) do(req *http.Request) (*http.Response, error) {
+	resp, err := doh.http.Do(req)
+	if err != nil {
+		metricDoHResponses.Inc("error")
+		return nil, err
+	}
+	metricDoHResponses.Inc(strconv.Itoa(resp.StatusCode))
+	return resp, nil
+}
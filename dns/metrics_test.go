@@ -0,0 +1,51 @@
+//
+// metrics_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func TestProxyMetrics(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, false))
+	proxy.SetBlacklist([]Rule{
+		{
+			Pattern: NewLabels("ads.example.com"),
+		},
+	})
+
+	forwarded := metricQueries.Value("AAAA", "forwarded")
+	blocked := metricQueries.Value("AAAA", "blocked")
+	latency := metricUpstreamLatency.Count("udp")
+
+	for i, name := range []string{"www.example.com", "ads.example.com"} {
+		packet, query := testQuery(t, uint16(i), name, layers.DNSTypeAAAA)
+		err := proxy.Query(packet, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.response(t)
+	}
+
+	if v := metricQueries.Value("AAAA", "forwarded"); v != forwarded+1 {
+		t.Errorf("forwarded queries %v, expected %v", v, forwarded+1)
+	}
+	if v := metricQueries.Value("AAAA", "blocked"); v != blocked+1 {
+		t.Errorf("blocked queries %v, expected %v", v, blocked+1)
+	}
+	if c := metricUpstreamLatency.Count("udp"); c != latency+1 {
+		t.Errorf("upstream latency observations %v, expected %v", c,
+			latency+1)
+	}
+	if n := proxy.inFlight(); n != 0 {
+		t.Errorf("%d pending queries", n)
+	}
+}
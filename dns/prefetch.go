@@ -0,0 +1,57 @@
+//
+// prefetch.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Prefetching popular names.
+//
+
+package dns
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// prefetch refreshes the cache entry of the popular question before
+// it expires. The prefetch query is subject to the domain and
+// in-flight rate limits. It is forwarded like the client queries,
+// but it does not generate events or query log records.
+func (p *Proxy) prefetch(q layers.DNSQuestion) {
+	name := strings.ToLower(string(q.Name))
+	labels := NewLabels(name)
+	if p.RateLimit != nil && !p.RateLimit.AllowDomain(labels) {
+		return
+	}
+	if p.Verbose > 1 {
+		fmt.Printf(" ↻ %s %s %s\n", labels, q.Type, q.Class)
+	}
+	metricPrefetch.Inc()
+
+	now := time.Now()
+	err := p.forwardQuery(&Pending{
+		timestamp:   now,
+		deadline:    now.Add(p.Deadline),
+		passthrough: p.Passthrough(labels.String()),
+		prefetch:    true,
+	}, &layers.DNS{
+		OpCode: layers.DNSOpCodeQuery,
+		RD:     true,
+		Questions: []layers.DNSQuestion{
+			{
+				Name:  []byte(name),
+				Type:  q.Type,
+				Class: q.Class,
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Prefetch failed: %s\n", err)
+	}
+}
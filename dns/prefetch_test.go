@@ -0,0 +1,119 @@
+//
+// prefetch_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func newPrefetchProxy(t *testing.T, server *testServer) (*Proxy, testOutput,
+	layers.DNSQuestion) {
+
+	proxy, out := newTestProxy(t, server)
+	proxy.Cache = NewCache(10)
+	proxy.Cache.PrefetchHits = 2
+
+	// The entry expires in 5 seconds.
+	q := question("www.example.com", layers.DNSTypeA)
+	proxy.Cache.put(&layers.DNS{
+		QR:        true,
+		RA:        true,
+		Questions: []layers.DNSQuestion{q},
+		Answers: []layers.DNSResourceRecord{
+			{
+				Name:  q.Name,
+				Type:  layers.DNSTypeA,
+				Class: layers.DNSClassIN,
+				TTL:   60,
+				IP:    net.IPv4(192, 0, 2, 2),
+			},
+		},
+	}, time.Now().Add(-55*time.Second))
+
+	return proxy, out, q
+}
+
+func TestProxyPrefetch(t *testing.T) {
+	server := newTestServer(t, false)
+	proxy, out, q := newPrefetchProxy(t, server)
+	events := make(chan Event, 10)
+	proxy.Events = events
+
+	for i := 0; i < 2; i++ {
+		packet, query := testQuery(t, uint16(i), "www.example.com",
+			layers.DNSTypeA)
+		err := proxy.Query(packet, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := out.response(t)
+		if !resp.Answers[0].IP.Equal(net.IPv4(192, 0, 2, 2)) {
+			t.Errorf("query %d: not answered from cache", i)
+		}
+	}
+	numEvents := len(events)
+
+	select {
+	case <-server.queries:
+	case <-time.After(time.Second):
+		t.Fatalf("popular entry not prefetched")
+	}
+	for i := 0; ; i++ {
+		if i >= 100 {
+			t.Fatalf("prefetch response not cached")
+		}
+		resp := proxy.Cache.Get(q)
+		if resp != nil && resp.Answers[0].IP.Equal(net.IPv4(192, 0, 2, 1)) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case data := <-out:
+		t.Errorf("prefetch response written to client: %x", data)
+	default:
+	}
+	if len(events) != numEvents {
+		t.Errorf("prefetch generated events")
+	}
+	if n := proxy.inFlight(); n != 0 {
+		t.Errorf("%d pending queries", n)
+	}
+}
+
+func TestProxyPrefetchRateLimit(t *testing.T) {
+	server := newTestServer(t, false)
+	proxy, out, _ := newPrefetchProxy(t, server)
+	// The client queries consume the domain tokens.
+	proxy.RateLimit = NewRateLimiter(Rate{}, Rate{Rate: 0.1, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		packet, query := testQuery(t, uint16(i), "www.example.com",
+			layers.DNSTypeA)
+		err := proxy.Query(packet, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := out.response(t)
+		if resp.ResponseCode != layers.DNSResponseCodeNoErr {
+			t.Fatalf("query %d: got %s", i, resp.ResponseCode)
+		}
+	}
+
+	select {
+	case <-server.queries:
+		t.Errorf("prefetch query exceeded domain rate limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -15,6 +15,8 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -26,25 +28,85 @@ var (
 	bo = binary.BigEndian
 )
 
+// Proxy constants.
+const (
+	DefaultMTU = 1500
+	// MinUDPSize defines the maximum UDP message size for clients
+	// that don't advertise their UDP payload size with EDNS(0).
+	MinUDPSize = 512
+	// DefaultTimeout defines the default upstream query timeout.
+	DefaultTimeout = 3 * time.Second
+	// DefaultRetries defines the default number of upstream query
+	// retries.
+	DefaultRetries = 2
+	// DefaultDeadline defines the default deadline of the queries.
+	DefaultDeadline = 10 * time.Second
+	// ReapInterval defines how often the expired pending queries are
+	// reaped.
+	ReapInterval = time.Second
+)
+
 // Proxy defines a DNS proxy.
 type Proxy struct {
 	Verbose     int
-	Blacklist   []Labels
+	Block       *BlockResponse
 	Events      chan Event
-	DoH         *DoHClient
 	NoPad       bool
+	EDNS        *EDNSPolicy
+	Cache       *Cache
+	Local       *LocalRecords
+	Rebinding   *Rebinding
+	Validator   *Validator
+	RateLimit   *RateLimiter
+	QueryLog    *QueryLog
+	Dnstap      *Dnstap
+	MTU         int
+	Timeout     time.Duration
+	Retries     int
+	Deadline    time.Duration
 	chResponses chan []byte
-	client      *UDPClient
+	system      *Pool
+	pool        *Pool
+	pools       map[string]*Pool
+	forward     []ForwardRule
+	blacklist   RuleMatcher
+	passthrough []string
 	out         io.Writer
 	m           sync.Mutex
 	pending     map[uint16]*Pending
+	queries     map[queryKey]*Pending
 }
 
 // Pending defines a pending DNS query.
 type Pending struct {
-	timestamp time.Time
-	packet    gopacket.Packet
-	id        uint16
+	timestamp   time.Time
+	deadline    time.Time
+	packet      gopacket.Packet
+	id          uint16
+	udpSize     int
+	edns        bool
+	data        []byte
+	tcp         bool
+	questions   []layers.DNSQuestion
+	chain       []layers.DNSResourceRecord
+	question    *layers.DNSQuestion
+	cd          bool
+	ecs         bool
+	clientECS   bool
+	passthrough bool
+	pool        *Pool
+	upstream    *Upstream
+	tried       []*Upstream
+	sent        time.Time
+	timer       *time.Timer
+	err         error
+	key         *queryKey
+	followers   []*Pending
+	stale       bool
+	prefetch    bool
+	// done receives the response of the proxy's own queries. It is
+	// closed if all upstreams fail.
+	done chan *layers.DNS
 }
 
 // EventType defines proxy events.
@@ -55,12 +117,16 @@ const (
 	EventQuery EventType = iota
 	EventBlock
 	EventConfig
+	EventUpstream
+	EventRateLimit
 )
 
 var eventTypes = map[EventType]string{
-	EventQuery:  "?",
-	EventBlock:  "\u00d7",
-	EventConfig: "\u2672",
+	EventQuery:     "?",
+	EventBlock:     "\u00d7",
+	EventConfig:    "\u2672",
+	EventUpstream:  "\u21c5",
+	EventRateLimit: "\u231b",
 }
 
 func (t EventType) String() string {
@@ -85,65 +151,323 @@ var serializeOptions = gopacket.SerializeOptions{
 type Event struct {
 	Type   EventType
 	Labels Labels
+	// CNAME is the blacklisted CNAME target of the EventBlock
+	// events that were blocked by their CNAME chain.
+	CNAME     Labels
+	Upstream  *UpstreamInfo
+	Blacklist *BlacklistInfo
+	RateLimit *RateLimitInfo
+}
+
+// BlacklistInfo describes the proxy's blacklist.
+type BlacklistInfo struct {
+	Rules int
+}
+
+func (info *BlacklistInfo) String() string {
+	return fmt.Sprintf("%d rules", info.Rules)
 }
 
-// NewProxy creates a new DNS proxy.
-func NewProxy(server string, out io.Writer) (*Proxy, error) {
+// NewProxy creates a new DNS proxy. The proxy resolves queries with
+// the servers until the upstream pool is set with SetPool.
+func NewProxy(servers []string, out io.Writer) (*Proxy, error) {
 	proxy := &Proxy{
-		out:     out,
-		pending: make(map[uint16]*Pending),
+		MTU:         DefaultMTU,
+		Timeout:     DefaultTimeout,
+		Retries:     DefaultRetries,
+		Deadline:    DefaultDeadline,
+		chResponses: make(chan []byte),
+		out:         out,
+		pending:     make(map[uint16]*Pending),
+		queries:     make(map[queryKey]*Pending),
 	}
-	err := proxy.SetServer(server)
+	err := proxy.SetServers(servers)
 	if err != nil {
 		return nil, err
 	}
+	go proxy.reader()
+	go proxy.reaper()
+
 	return proxy, nil
 }
 
-// SetServer sets the DNS server to use for the proxy queries.
-func (p *Proxy) SetServer(server string) error {
-	ch := make(chan []byte)
-	client, err := NewUDPClient(server, ch)
+// SetServers sets the system DNS servers. The proxy uses the system
+// servers for passthrough queries and for all queries if the
+// upstream pool is not set.
+func (p *Proxy) SetServers(servers []string) error {
+	var upstreams []*Upstream
+	for _, server := range servers {
+		upstreams = append(upstreams, NewUDPUpstream(server))
+	}
+	system := NewPool(StrategyOrder, upstreams...)
+	err := system.open(p.chResponses, p.upstreamEvent)
 	if err != nil {
-		close(ch)
 		return err
 	}
 
 	p.m.Lock()
-	old := p.client
-	p.client = client
-	p.chResponses = ch
+	old := p.system
+	p.system = system
 	p.m.Unlock()
 
-	go p.reader(client)
+	if old != nil {
+		old.close()
+		if p.Cache != nil {
+			// The new servers may resolve names differently.
+			p.Cache.Flush()
+		}
+	}
+	return nil
+}
+
+// SetPool sets the upstream pool for the proxy queries.
+func (p *Proxy) SetPool(pool *Pool) error {
+	err := pool.open(p.chResponses, p.upstreamEvent)
+	if err != nil {
+		return err
+	}
+
+	p.m.Lock()
+	old := p.pool
+	p.pool = pool
+	p.m.Unlock()
 
 	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+// SetForwarding sets the conditional forwarding rules and their
+// named upstream pools.
+func (p *Proxy) SetForwarding(f *Forwarding) error {
+	for name, pool := range f.Upstreams {
+		err := pool.open(p.chResponses, p.upstreamEvent)
+		if err != nil {
+			return fmt.Errorf("upstream %s: %s", name, err)
+		}
 	}
 
+	p.m.Lock()
+	old := p.pools
+	p.pools = f.Upstreams
+	p.forward = f.Rules
+	p.m.Unlock()
+
+	for _, pool := range old {
+		pool.close()
+	}
 	return nil
 }
 
+// SetBlacklist sets the blacklist rules. The rules are compiled into
+// a Matcher and set with SetMatcher. The rules must not be modified
+// after this call.
+func (p *Proxy) SetBlacklist(rules []Rule) {
+	p.SetMatcher(NewMatcher(rules))
+}
+
+// SetMatcher sets the blacklist rule matcher. The matcher is swapped
+// atomically so that the queries see either the old or the new rule
+// set. The function sends an EventConfig event with the new rule
+// count.
+func (p *Proxy) SetMatcher(matcher RuleMatcher) {
+	p.m.Lock()
+	old := p.blacklist
+	p.blacklist = matcher
+	p.m.Unlock()
+
+	if old != nil && p.Cache != nil {
+		// The cached responses can have CNAME chains to names that
+		// the new rules block.
+		p.Cache.Flush()
+	}
+
+	info := &BlacklistInfo{
+		Rules: matcher.Len(),
+	}
+	if p.Verbose > 0 {
+		fmt.Printf(" %s blacklist: %s\n", EventConfig, info)
+	}
+	if p.Events == nil {
+		return
+	}
+	p.Events <- Event{
+		Type:      EventConfig,
+		Blacklist: info,
+	}
+}
+
+// AddPassthrough adds the server of the URL to the passthrough
+// names. The passthrough names are resolved with the system DNS
+// servers.
+func (p *Proxy) AddPassthrough(u string) error {
+	server, err := getServerFromURL(u)
+	if err != nil {
+		return err
+	}
+	p.m.Lock()
+	p.passthrough = append(p.passthrough, server)
+	p.m.Unlock()
+	return nil
+}
+
+// Passthrough tests if the host is passed through to the system DNS
+// servers instead of using the upstream pools.
+func (p *Proxy) Passthrough(host string) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for _, server := range p.passthrough {
+		if server == host {
+			return true
+		}
+	}
+
+	if p.pool != nil && p.pool.Passthrough(host) {
+		return true
+	}
+	for _, pool := range p.pools {
+		if pool.Passthrough(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreams returns the pool for the query labels.
+func (p *Proxy) upstreams(labels Labels, passthrough bool) *Pool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if passthrough {
+		return p.system
+	}
+	rule := MatchForwardRule(p.forward, labels)
+	if rule != nil {
+		if p.Verbose > 1 {
+			fmt.Printf(" \u21AA %s (%s)\n", labels, rule)
+		}
+		switch rule.Upstream {
+		case UpstreamSystem:
+			return p.system
+		case UpstreamDefault:
+		default:
+			pool, ok := p.pools[rule.Upstream]
+			if ok {
+				return pool
+			}
+		}
+	}
+	if p.pool == nil {
+		return p.system
+	}
+	return p.pool
+}
+
+func (p *Proxy) upstreamEvent(u *Upstream) {
+	info := u.Info()
+	if p.Verbose > 1 {
+		fmt.Printf(" %s %s\n", EventUpstream, info)
+	}
+	if p.Events == nil {
+		return
+	}
+	p.Events <- Event{
+		Type:     EventUpstream,
+		Upstream: info,
+	}
+}
+
 // Query starts a new DNS query.
 func (p *Proxy) Query(packet gopacket.Packet, dns *layers.DNS) error {
 	var qPassthrough bool
+	var cached *layers.DNS
+	var prefetch bool
+	var questions []layers.DNSQuestion
+	var chain []layers.DNSResourceRecord
+
+	udpSize := p.maxUDPSize(packet)
+	edns := hasOPT(dns)
+
+	p.tapClient(DnstapClientQuery, packet, dns.Contents)
+
+	if p.RateLimit != nil {
+		var labels Labels
+		if len(dns.Questions) > 0 {
+			labels = NewLabels(string(dns.Questions[0].Name))
+		}
+		limit, ok := p.RateLimit.Allow(clientAddr(packet), labels)
+		if !ok {
+			return p.limit(packet, dns, limit)
+		}
+	}
+
+	if p.Local != nil && len(dns.Questions) == 1 {
+		q := dns.Questions[0]
+		answers, target, ok := p.Local.Lookup(q)
+		if ok {
+			labels := NewLabels(string(q.Name))
+			if p.Verbose > 0 {
+				fmt.Printf(" \U0001F3E0 %s %s %s\n", labels, q.Type, q.Class)
+			}
+			if len(target) == 0 {
+				p.event(EventQuery, labels)
+				p.logQuery(packet, dns, DecisionLocal, nil,
+					layers.DNSResponseCodeNoErr, nil)
+				return p.synthesize(packet, dns, layers.DNSResponseCodeNoErr,
+					answers)
+			}
+			// Resolve the CNAME target and prepend the local CNAME
+			// chain to the response. The client's query is not
+			// modified.
+			questions = dns.Questions
+			chain = answers
+			query := *dns
+			query.Questions = []layers.DNSQuestion{
+				{
+					Name:  []byte(target),
+					Type:  q.Type,
+					Class: q.Class,
+				},
+			}
+			dns = &query
+		}
+	}
+
+	p.m.Lock()
+	blacklist := p.blacklist
+	p.m.Unlock()
 
 	for _, q := range dns.Questions {
-		labels := NewLabels(string(q.Name))
-		for _, black := range p.Blacklist {
-			if labels.Match(black) {
+		labels := NewLabels(strings.ToLower(string(q.Name)))
+		var rule *Rule
+		if blacklist != nil {
+			rule = blacklist.Match(labels)
+		}
+		if rule != nil {
+			if !rule.Allow {
 				if p.Verbose > 1 {
-					fmt.Printf(" \U0001F6D1 %s (%s)\n", labels, black)
+					fmt.Printf(" \U0001F6D1 %s (%s)\n", labels, rule)
 				}
 				p.event(EventBlock, labels)
-				return p.nonExistingDomain(packet, dns)
+				return p.block(packet, dns, rule)
+			}
+			if p.Verbose > 1 {
+				fmt.Printf(" \u2714 %s (%s)\n", labels, rule)
 			}
 		}
-		if p.DoH != nil && p.DoH.Passthrough(labels.String()) {
+		if p.Passthrough(labels.String()) {
 			qPassthrough = true
 		}
+		if p.Cache != nil && len(dns.Questions) == 1 {
+			cached, prefetch = p.Cache.Lookup(q)
+		}
 		if p.Verbose > 0 {
 			marker := "\u2705"
-			if qPassthrough {
+			if cached != nil {
+				marker = "\U0001F4BE"
+			} else if qPassthrough {
 				marker = "\u2B50"
 			}
 			fmt.Printf(" %s %s %s %s\n", marker, labels, q.Type, q.Class)
@@ -151,113 +475,605 @@ func (p *Proxy) Query(packet gopacket.Packet, dns *layers.DNS) error {
 		p.event(EventQuery, labels)
 	}
 
+	if cached != nil {
+		cached.ID = dns.ID
+		cached.Questions = dns.Questions
+		stripUnsolicitedDNSSEC(cached, dns)
+		if chain != nil {
+			unalias(cached, questions, chain)
+		}
+		p.logQuery(packet, cached, DecisionCached, nil, cached.ResponseCode,
+			nil)
+		if prefetch {
+			go p.prefetch(dns.Questions[0])
+		}
+		return p.writeResponse(packet, udpSize, cached)
+	}
+
+	if qPassthrough && len(dns.Questions) > 1 {
+		return fmt.Errorf("Quering DoH server with multiple questions")
+	}
+
+	now := time.Now()
+	return p.forwardQuery(&Pending{
+		timestamp:   now,
+		deadline:    now.Add(p.Deadline),
+		packet:      packet,
+		id:          dns.ID,
+		udpSize:     udpSize,
+		edns:        edns,
+		questions:   questions,
+		chain:       chain,
+		passthrough: qPassthrough,
+	}, dns)
+}
+
+// forwardQuery sends the query to the upstream pool of its first
+// question. The query is rewritten and padded for the pool, and it
+// is coalesced with an identical in-flight query if there is one.
+func (p *Proxy) forwardQuery(pending *Pending, dns *layers.DNS) error {
+	dns = upstreamQuery(dns)
+
+	// Route the query with its first question.
+	var qLabels Labels
+	if len(dns.Questions) > 0 {
+		qLabels = NewLabels(strings.ToLower(string(dns.Questions[0].Name)))
+	}
+	pool := p.upstreams(qLabels, pending.passthrough)
 	data := dns.Contents
+	cd := dns.Z&dnsFlagCD != 0
+
+	policy := p.ednsPolicy(pool)
+	// The prefetch queries are not encoded.
+	rewrite := pending.chain != nil || len(data) == 0
+
+	if p.Validator != nil {
+		// Request the DNSSEC records for the validation.
+		setDO(dns)
+		rewrite = true
+	}
+	if policy != nil && policy.Apply(dns) {
+		rewrite = true
+	}
+	if rewrite {
+		// Marshal the query with the CNAME target question, the DO
+		// bit, and the EDNS options.
+		buffer := gopacket.NewSerializeBuffer()
+		err := gopacket.SerializeLayers(buffer, serializeOptions, dns)
+		if err != nil {
+			return err
+		}
+		data = buffer.Bytes()
+	}
 
 	// RFC 8467 padding.
-	if !p.NoPad && p.DoH != nil && !qPassthrough {
-		dataLen := len(data)
-
-		// Does the request have OPT record?
-		var opt *layers.DNSResourceRecord
-		for _, add := range dns.Additionals {
-			if add.Type == layers.DNSTypeOPT {
-				opt = &add
-				break
-			}
+	if !p.NoPad && pool.Encrypted() {
+		var padLen int
+		var err error
+		data, padLen, err = padQuery(dns, len(data))
+		if err != nil {
+			return err
 		}
-		if opt == nil {
-			// Add OPT record.
-			dns.Additionals = append(dns.Additionals, layers.DNSResourceRecord{
-				Type:  layers.DNSTypeOPT,
-				Class: 4096,
-				TTL:   0,
-			})
-			opt = &dns.Additionals[len(dns.Additionals)-1]
-			dataLen += 11
-		}
-		// Does the OPT record have a padding?
-		var pad *layers.DNSOPT
-		for _, o := range opt.OPT {
-			if o.Code == layers.DNSOptionCodePadding {
-				pad = &o
-				break
-			}
+		if p.Verbose > 2 {
+			fmt.Printf("Padded query: pad=%d:\n%s", padLen, hex.Dump(data))
 		}
-		if pad == nil {
-			dataLen += 4
-			// Pad to the closest multiple of 128 octects.
-			var padLen int
-			if dataLen%128 != 0 {
-				padLen = 128 - dataLen%128
-
-				opt.OPT = append(opt.OPT, layers.DNSOPT{
-					Code: layers.DNSOptionCodePadding,
-					Data: make([]byte, padLen),
-				})
-			}
+	}
 
-			// Marshal padded message.
-			buffer := gopacket.NewSerializeBuffer()
-			err := gopacket.SerializeLayers(buffer, serializeOptions, dns)
-			if err != nil {
-				return err
-			}
-			data = buffer.Bytes()
-			if p.Verbose > 2 {
-				fmt.Printf("Padded query: pad=%d:\n%s", padLen, hex.Dump(data))
-			}
+	pending.data = data
+	if len(dns.Questions) == 1 {
+		pending.question = &dns.Questions[0]
+	}
+	pending.cd = cd
+	pending.ecs = policy != nil && policy.ClientSubnet != nil
+	pending.clientECS = !pending.ecs &&
+		hasOption(dns, layers.DNSOptionCodeEDNSClientSubnet)
+	pending.pool = pool
+
+	key := newQueryKey(dns, pool)
+	if key != nil && p.coalesce(pending, key) {
+		metricCoalesced.Inc()
+		if p.Verbose > 1 {
+			fmt.Printf(" \u29C9 %s: in-flight\n", key)
 		}
+		if pending.stale {
+			// The in-flight query was answered with stale data.
+			p.answerStale(pending, p.Cache.GetStale(dns.Questions[0]))
+		}
+		return nil
+	}
+	id, ok := p.allocate(pending)
+	if !ok {
+		return p.limitInFlight(pending)
+	}
+	p.send(id, pending)
+	return nil
+}
+
+// limitInFlight refuses or drops the pending query and its followers
+// when the in-flight limit is exceeded.
+func (p *Proxy) limitInFlight(pending *Pending) error {
+	p.m.Lock()
+	p.uncoalesce(pending)
+	followers := pending.followers
+	pending.followers = nil
+	p.m.Unlock()
+
+	var result error
+	for _, pend := range append([]*Pending{pending}, followers...) {
+		if pend.packet == nil {
+			// Prefetch query.
+			continue
+		}
+		layer := pend.packet.Layer(layers.LayerTypeDNS)
+		if layer == nil {
+			continue
+		}
+		err := p.limit(pend.packet, layer.(*layers.DNS), LimitInFlight)
+		if pend == pending {
+			result = err
+		} else if err != nil {
+			log.Printf("Failed to write UDP response: %s\n", err)
+		}
+	}
+	return result
+}
+
+// Resolve resolves the records of the name with the proxy's
+// upstreams. The query has the DNSSEC OK and Checking Disabled bits
+// set so that the upstreams return the DNSSEC records without
+// validating them. The Validator resolves the DNSKEY and DS records
+// with this function.
+func (p *Proxy) Resolve(name string, qtype layers.DNSType) (
+	*layers.DNS, error) {
+
+	name = normalizeName(name)
+	pool := p.upstreams(NewLabels(name), p.Passthrough(name))
+
+	if p.Verbose > 1 {
+		fmt.Printf(" \U0001F511 %s %s\n", name, typeString(qtype))
 	}
 
+	// The query is constructed manually since gopacket does not
+	// serialize root names in questions correctly.
+	data := make([]byte, 12, 128)
+	bo.PutUint16(data[2:], 0x0110) // RD, CD
+	bo.PutUint16(data[4:], 1)      // QDCOUNT
+	bo.PutUint16(data[10:], 1)     // ARCOUNT
+	data = appendName(data, name)
+	data = bo.AppendUint16(data, uint16(qtype))
+	data = bo.AppendUint16(data, uint16(layers.DNSClassIN))
+
+	// OPT record with the DO bit.
+	data = append(data, 0)
+	data = bo.AppendUint16(data, uint16(layers.DNSTypeOPT))
+	data = bo.AppendUint16(data, 4096)
+	data = bo.AppendUint32(data, ednsDO)
+	if !p.NoPad && pool.Encrypted() {
+		// RFC 8467 padding to the closest multiple of 128 octets.
+		padLen := (128 - (len(data)+6)%128) % 128
+		data = bo.AppendUint16(data, uint16(4+padLen))
+		data = bo.AppendUint16(data, uint16(layers.DNSOptionCodePadding))
+		data = bo.AppendUint16(data, uint16(padLen))
+		data = append(data, make([]byte, padLen)...)
+	} else {
+		data = bo.AppendUint16(data, 0)
+	}
+
+	now := time.Now()
 	pending := &Pending{
-		timestamp: time.Now(),
-		packet:    packet,
-		id:        dns.ID,
+		timestamp: now,
+		deadline:  now.Add(p.Deadline),
+		data:      data,
+		question: &layers.DNSQuestion{
+			Name:  []byte(name),
+			Type:  qtype,
+			Class: layers.DNSClassIN,
+		},
+		pool: pool,
+		done: make(chan *layers.DNS, 1),
+	}
+	// The proxy's own queries are not limited.
+	id, _ := p.allocate(pending)
+	p.send(id, pending)
+	dns, ok := <-pending.done
+	if !ok {
+		return nil, fmt.Errorf("%s %s: all upstreams failed", name,
+			typeString(qtype))
+	}
+	return dns, nil
+}
+
+// matches tests if the response answers the question of the
+// upstream query. The names are compared case-insensitively.
+func (pending *Pending) matches(dns *layers.DNS) bool {
+	if pending.question == nil {
+		return true
 	}
+	if len(dns.Questions) != 1 {
+		return false
+	}
+	q := dns.Questions[0]
+	return q.Type == pending.question.Type &&
+		q.Class == pending.question.Class &&
+		normalizeName(string(q.Name)) ==
+			normalizeName(string(pending.question.Name))
+}
+
+// answered tests if the client does not wait for the upstream
+// response: the client was answered with stale data or the query is
+// a prefetch query without a client.
+func (pending *Pending) answered() bool {
+	return pending.stale || pending.prefetch
+}
+
+// decision returns the query log decision of the forwarded query.
+func (pending *Pending) decision() Decision {
+	if pending.passthrough {
+		return DecisionPassthrough
+	}
+	return DecisionForwarded
+}
 
-	// Allocate ID
+// ednsPolicy returns the EDNS(0) policy for the queries to the
+// upstream pool. If the proxy's EDNS policy is unset, the queries to
+// the encrypted upstreams use the EDNSPrivacy policy.
+func (p *Proxy) ednsPolicy(pool *Pool) *EDNSPolicy {
+	if p.EDNS != nil {
+		return p.EDNS
+	}
+	if pool.Encrypted() {
+		return EDNSPrivacy
+	}
+	return nil
+}
+
+// upstreamQuery returns a copy of the query for the upstream
+// rewrites. The questions and the OPT records are copied so that the
+// client's query is not modified.
+func upstreamQuery(dns *layers.DNS) *layers.DNS {
+	query := *dns
+	query.Questions = append([]layers.DNSQuestion(nil), dns.Questions...)
+	query.Additionals = make([]layers.DNSResourceRecord, len(dns.Additionals))
+	for i, rr := range dns.Additionals {
+		if rr.Type == layers.DNSTypeOPT {
+			rr.OPT = append([]layers.DNSOPT(nil), rr.OPT...)
+		}
+		query.Additionals[i] = rr
+	}
+	return &query
+}
+
+// setDO sets the DNSSEC OK bit of the query. The OPT record is added
+// if the query does not have it.
+func setDO(dns *layers.DNS) {
+	for i := range dns.Additionals {
+		if dns.Additionals[i].Type == layers.DNSTypeOPT {
+			dns.Additionals[i].TTL |= ednsDO
+			return
+		}
+	}
+	dns.Additionals = append(dns.Additionals, layers.DNSResourceRecord{
+		Type:  layers.DNSTypeOPT,
+		Class: 4096,
+		TTL:   ednsDO,
+	})
+}
+
+// allocate allocates a query ID for the pending query and sets it to
+// the query data. The function returns false if the query exceeds
+// the in-flight limit. The proxy's own queries are not limited.
+func (p *Proxy) allocate(pending *Pending) (uint16, bool) {
 	p.m.Lock()
+	defer p.m.Unlock()
+
+	if pending.done == nil && p.RateLimit != nil &&
+		!p.RateLimit.AllowInFlight(len(p.pending)) {
+		return 0, false
+	}
+
 	var id uint16
-	client := p.client
-	chResponses := p.chResponses
-idalloc:
 	for {
 		var idbuf [2]byte
 
-		for i := 0; i < 10; i++ {
-			rand.Read(idbuf[:])
-			id = bo.Uint16(idbuf[:])
-			_, ok := p.pending[id]
-			if !ok {
-				p.pending[id] = pending
-				break idalloc
-			}
+		rand.Read(idbuf[:])
+		id = bo.Uint16(idbuf[:])
+		_, ok := p.pending[id]
+		if !ok {
+			p.pending[id] = pending
+			break
 		}
-		limit := time.Now().Add(-30 * time.Second)
-		for id, pend := range p.pending {
-			if pend.timestamp.Before(limit) {
-				delete(p.pending, id)
+	}
+	bo.PutUint16(pending.data, id)
+
+	return id, true
+}
+
+// send sends the pending query to the next upstream of its pool. If
+// the upstream fails or times out, the query is retried until it
+// runs out of attempts or its deadline passes, and then it fails.
+func (p *Proxy) send(id uint16, pending *Pending) {
+	for {
+		p.m.Lock()
+		if p.pending[id] != pending {
+			// Query completed.
+			p.m.Unlock()
+			return
+		}
+		u := p.next(pending)
+		if u == nil {
+			// The queries that were answered with stale data wait
+			// for the late responses until their deadlines.
+			wait := pending.stale && time.Now().Before(pending.deadline)
+			p.m.Unlock()
+			if !wait {
+				p.fail(id, pending)
 			}
+			return
+		}
+		pending.tried = append(pending.tried, u)
+		pending.upstream = u
+		sent := time.Now()
+		pending.sent = sent
+		pending.err = nil
+		// DoH and DoT responses are never truncated.
+		pending.tcp = u.Encrypted()
+
+		attempt := len(pending.tried)
+		if pending.timer != nil {
+			pending.timer.Stop()
+		}
+		timeout := p.Timeout
+		remaining := pending.deadline.Sub(sent)
+		if remaining < timeout {
+			timeout = remaining
+		}
+		pending.timer = time.AfterFunc(timeout, func() {
+			p.timeout(id, pending, attempt)
+		})
+		p.m.Unlock()
+
+		p.tapForwarder(DnstapForwarderQuery, u, sent, pending.data)
+		err := u.send(pending.data, p.chResponses)
+		if err == nil {
+			return
+		}
+		if p.Verbose > 0 {
+			fmt.Printf(" \u26A0 upstream %s: %s\n", u, err)
 		}
+		pending.pool.failure(u)
+
+		p.m.Lock()
+		current := len(pending.tried) == attempt
+		if current {
+			pending.err = err
+		}
+		p.m.Unlock()
+		if !current {
+			// The timeout has already moved the query to the next
+			// upstream.
+			return
+		}
+		p.serveStale(id, pending)
+	}
+}
+
+// next selects the next upstream for the pending query. The query is
+// sent to every upstream of its pool at least once, and at most
+// Retries+1 times in total. Each round over the pool excludes the
+// upstreams that were already tried in the round. The function
+// returns nil if the query has no attempts left or if its deadline
+// has passed. The proxy mutex must be held.
+func (p *Proxy) next(pending *Pending) *Upstream {
+	n := len(pending.pool.Upstreams)
+	if n == 0 || !time.Now().Before(pending.deadline) {
+		return nil
+	}
+	attempts := p.Retries + 1
+	if attempts < n {
+		attempts = n
 	}
+	tried := len(pending.tried)
+	if tried >= attempts {
+		return nil
+	}
+	return pending.pool.Select(pending.tried[tried-tried%n:])
+}
+
+// fail completes the pending query whose upstreams failed. The
+// client receives a SERVFAIL response with an Extended DNS Error
+// that tells if the last upstream failed or timed out.
+func (p *Proxy) fail(id uint16, pending *Pending) {
+	p.serveStale(id, pending)
+
+	p.m.Lock()
+	if p.pending[id] != pending {
+		// Query completed.
+		p.m.Unlock()
+		return
+	}
+	delete(p.pending, id)
+	if pending.timer != nil {
+		pending.timer.Stop()
+	}
+	p.uncoalesce(pending)
+	err := pending.err
 	p.m.Unlock()
 
-	bo.PutUint16(data, uint16(id))
+	if pending.done != nil {
+		close(pending.done)
+		return
+	}
+	ede := EDENoReachableAuthority
+	text := "upstream timeout"
+	if err != nil {
+		ede = EDENetworkError
+		text = err.Error()
+	}
+	p.servfail(pending, ede, text)
+}
+
+// servfail answers the pending query and its followers with SERVFAIL.
+// The Extended DNS Error is set if the client sent an EDNS(0) query.
+func (p *Proxy) servfail(pending *Pending, ede ExtendedError, text string) {
+	for _, follower := range pending.followers {
+		p.servfail(follower, ede, text)
+	}
+	if !pending.answered() {
+		p.writeServFail(pending, ede, text)
+	}
+}
 
-	if qPassthrough && len(dns.Questions) > 1 {
-		return fmt.Errorf("Quering DoH server with multiple questions")
+// writeServFail writes the SERVFAIL response to the pending query.
+func (p *Proxy) writeServFail(pending *Pending, ede ExtendedError,
+	text string) {
+
+	layer := pending.packet.Layer(layers.LayerTypeDNS)
+	if layer == nil {
+		return
+	}
+	query := layer.(*layers.DNS)
+	questions := query.Questions
+	if pending.chain != nil {
+		questions = pending.questions
+	}
+	if p.Verbose > 0 && len(questions) > 0 {
+		fmt.Printf(" \u2718 %s: %s (%s)\n", questions[0].Name, ede, text)
 	}
+	dns := &layers.DNS{
+		ID:           pending.id,
+		QR:           true,
+		OpCode:       query.OpCode,
+		RD:           query.RD,
+		RA:           true,
+		ResponseCode: layers.DNSResponseCodeServFail,
+		Questions:    questions,
+	}
+	if pending.edns {
+		setExtendedError(dns, ede, text)
+	}
+	p.logQuery(pending.packet, dns, pending.decision(), nil,
+		dns.ResponseCode, pending)
+	err := p.writeResponse(pending.packet, pending.udpSize, dns)
+	if err != nil {
+		log.Printf("Failed to write UDP response: %s\n", err)
+	}
+}
 
-	if p.DoH != nil && !qPassthrough {
-		resp, err := p.DoH.Do(data)
-		if err != nil {
-			return err
+// reaper fails the pending queries whose deadlines have passed. The
+// upstream timeouts normally complete the queries before their
+// deadlines, but the queries can't be stuck in the pending table
+// even if their upstreams block.
+func (p *Proxy) reaper() {
+	for now := range time.Tick(ReapInterval) {
+		p.reap(now)
+	}
+}
+
+// reap fails the pending queries that have expired at now.
+func (p *Proxy) reap(now time.Time) {
+	expired := make(map[uint16]*Pending)
+
+	p.m.Lock()
+	for id, pending := range p.pending {
+		if now.After(pending.deadline) {
+			expired[id] = pending
 		}
-		chResponses <- resp
-		return nil
 	}
+	p.m.Unlock()
+
+	for id, pending := range expired {
+		p.fail(id, pending)
+	}
+}
+
+// timeout handles the upstream timeout of the pending query. The
+// attempt identifies the upstream that timed out.
+func (p *Proxy) timeout(id uint16, pending *Pending, attempt int) {
+	p.m.Lock()
+	current := p.pending[id] == pending && len(pending.tried) == attempt
+	u := pending.upstream
+	p.m.Unlock()
+
+	if !current {
+		return
+	}
+	if p.Verbose > 0 {
+		fmt.Printf(" \u231B upstream %s timeout\n", u)
+	}
+	pending.pool.failure(u)
+	p.serveStale(id, pending)
+	p.send(id, pending)
+}
+
+// rebound removes the private addresses from the response unless
+// the question names are exempt from the rebinding protection. The
+// function returns true if the response had private addresses.
+func (p *Proxy) rebound(dns *layers.DNS) bool {
+	for _, q := range dns.Questions {
+		labels := NewLabels(strings.ToLower(string(q.Name)))
+		if p.Rebinding.Exempt(labels) || p.internal(labels) {
+			return false
+		}
+	}
+	var answers, additionals []net.IP
+	dns.Answers, answers = p.Rebinding.filter(dns.Answers)
+	dns.Additionals, additionals = p.Rebinding.filter(dns.Additionals)
+	private := append(answers, additionals...)
+	if len(private) == 0 {
+		return false
+	}
+	if p.Verbose > 0 {
+		var names []string
+		for _, q := range dns.Questions {
+			names = append(names, string(q.Name))
+		}
+		fmt.Printf(" \u26A0 %s: private addresses %v\n",
+			strings.Join(names, ","), private)
+	}
+	return true
+}
+
+// internal tests if the name is conditionally forwarded to a named or
+// the system upstream. The forwarded internal zones can resolve to
+// private addresses.
+func (p *Proxy) internal(labels Labels) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
 
-	return client.Write(data)
+	rule := MatchForwardRule(p.forward, labels)
+	return rule != nil && rule.Upstream != UpstreamDefault
+}
+
+// cloaked checks the CNAME targets of the response against the
+// blacklist. The function returns the blocking rule and the blocked
+// CNAME target, or nil if no CNAME targets are blocked. The
+// responses to the explicitly allowed question names are never
+// blocked.
+func (p *Proxy) cloaked(dns *layers.DNS) (*Rule, Labels) {
+	p.m.Lock()
+	blacklist := p.blacklist
+	p.m.Unlock()
+
+	if blacklist == nil || len(dns.Questions) == 0 {
+		return nil, nil
+	}
+	for _, q := range dns.Questions {
+		rule := blacklist.Match(NewLabels(strings.ToLower(string(q.Name))))
+		if rule != nil && rule.Allow {
+			return nil, nil
+		}
+	}
+	for _, rr := range dns.Answers {
+		if rr.Type != layers.DNSTypeCNAME {
+			continue
+		}
+		labels := NewLabels(strings.ToLower(string(rr.CNAME)))
+		rule := blacklist.Match(labels)
+		if rule != nil && !rule.Allow {
+			return rule, labels
+		}
+	}
+	return nil, nil
 }
 
 func (p *Proxy) event(t EventType, labels Labels) {
@@ -270,13 +1086,58 @@ func (p *Proxy) event(t EventType, labels Labels) {
 	}
 }
 
-func (p *Proxy) nonExistingDomain(packet gopacket.Packet, q *layers.DNS) error {
-	responseLayers, err := udpResponse(packet)
-	if err != nil {
-		return err
+// limit refuses or drops the over-limit query.
+func (p *Proxy) limit(packet gopacket.Packet, dns *layers.DNS,
+	limit Limit) error {
+
+	var labels Labels
+	if len(dns.Questions) > 0 {
+		labels = NewLabels(string(dns.Questions[0].Name))
 	}
+	info := &RateLimitInfo{
+		Limit:   limit,
+		Client:  clientAddr(packet),
+		Dropped: p.RateLimit.Drop,
+	}
+	if p.Verbose > 1 {
+		fmt.Printf(" %s %s (%s)\n", EventRateLimit, labels, info)
+	}
+	if p.Events != nil {
+		p.Events <- Event{
+			Type:      EventRateLimit,
+			Labels:    labels,
+			RateLimit: info,
+		}
+	}
+	if info.Dropped {
+		return p.drop(packet, dns, nil)
+	}
+	p.logQuery(packet, dns, DecisionLimited, nil,
+		layers.DNSResponseCodeRefused, nil)
+	return p.synthesize(packet, dns, layers.DNSResponseCodeRefused, nil)
+}
+
+// inFlight returns the number of in-flight upstream queries.
+func (p *Proxy) inFlight() int {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return len(p.pending)
+}
+
+// clientAddr returns the source address of the query packet.
+func clientAddr(packet gopacket.Packet) string {
+	network := packet.NetworkLayer()
+	if network == nil {
+		return ""
+	}
+	return network.NetworkFlow().Src().String()
+}
 
-	responseLayers = append(responseLayers, &layers.DNS{
+// synthesize writes a locally generated response for the query.
+func (p *Proxy) synthesize(packet gopacket.Packet, q *layers.DNS,
+	rcode layers.DNSResponseCode, answers []layers.DNSResourceRecord) error {
+
+	return p.writeResponse(packet, p.maxUDPSize(packet), &layers.DNS{
 		ID:           q.ID,
 		QR:           true,
 		OpCode:       q.OpCode,
@@ -284,23 +1145,112 @@ func (p *Proxy) nonExistingDomain(packet gopacket.Packet, q *layers.DNS) error {
 		TC:           false,
 		RD:           q.RD,
 		RA:           false,
-		ResponseCode: layers.DNSResponseCodeNXDomain,
+		ResponseCode: rcode,
 		Questions:    q.Questions,
+		Answers:      answers,
 	})
+}
+
+// unalias restores the original questions of the response that was
+// resolved through a local CNAME chain, and prepends the chain to
+// the response answers. The AD bit is cleared since the local CNAME
+// chains are not signed.
+func unalias(dns *layers.DNS, questions []layers.DNSQuestion,
+	chain []layers.DNSResourceRecord) {
+
+	answers := make([]layers.DNSResourceRecord, 0,
+		len(chain)+len(dns.Answers))
+	answers = append(answers, chain...)
+	dns.Answers = append(answers, dns.Answers...)
+	dns.Questions = questions
+	dns.Z &^= dnsFlagAD
+}
+
+// writeResponse writes the DNS response to the client that sent the
+// query packet. If the response does not fit into the client's UDP
+// payload size udpSize, the response is truncated and its TC bit is
+// set.
+func (p *Proxy) writeResponse(packet gopacket.Packet, udpSize int,
+	dns *layers.DNS) error {
+
+	response, err := udpResponse(packet)
+	if err != nil {
+		return fmt.Errorf("can't create UDP response: %s", err)
+	}
 
 	buffer := gopacket.NewSerializeBuffer()
-	err = gopacket.SerializeLayers(buffer, serializeOptions, responseLayers...)
+	err = dns.SerializeTo(buffer, serializeOptions)
 	if err != nil {
-		return err
+		return fmt.Errorf("serialization error: %s: layers=%v", err, response)
+	}
+	if len(buffer.Bytes()) > udpSize {
+		buffer.Clear()
+		err = truncate(dns).SerializeTo(buffer, serializeOptions)
+		if err != nil {
+			return fmt.Errorf("serialization error: %s", err)
+		}
 	}
+	p.tapClient(DnstapClientResponse, packet, buffer.Bytes())
 
-	_, err = p.out.Write(buffer.Bytes())
+	for i := len(response) - 1; i >= 0; i-- {
+		err = response[i].SerializeTo(buffer, serializeOptions)
+		if err != nil {
+			return fmt.Errorf("serialization error: %s: layers=%v",
+				err, response)
+		}
+	}
 
+	_, err = p.out.Write(buffer.Bytes())
 	return err
 }
 
-func (p *Proxy) reader(client *UDPClient) {
-	for msg := range client.C {
+// maxUDPSize returns the maximum DNS response size for the query
+// packet. The size is the UDP payload size that the client
+// advertised with EDNS(0), limited by the tunnel MTU.
+func (p *Proxy) maxUDPSize(packet gopacket.Packet) int {
+	size := MinUDPSize
+
+	layer := packet.Layer(layers.LayerTypeDNS)
+	if layer != nil {
+		dns := layer.(*layers.DNS)
+		for _, rr := range dns.Additionals {
+			if rr.Type == layers.DNSTypeOPT && int(rr.Class) > size {
+				size = int(rr.Class)
+			}
+		}
+	}
+
+	mtu := p.MTU - 8
+	if packet.Layer(layers.LayerTypeIPv4) != nil {
+		mtu -= 20
+	} else {
+		mtu -= 40
+	}
+	if size > mtu {
+		size = mtu
+	}
+	return size
+}
+
+// truncate creates a truncated copy of the DNS response. The copy
+// has the TC bit set and it contains only the question section and
+// the OPT record.
+func truncate(dns *layers.DNS) *layers.DNS {
+	result := *dns
+	result.TC = true
+	result.Answers = nil
+	result.Authorities = nil
+	result.Additionals = nil
+	for _, rr := range dns.Additionals {
+		if rr.Type == layers.DNSTypeOPT {
+			result.Additionals = append(result.Additionals, rr)
+		}
+	}
+	return &result
+}
+
+func (p *Proxy) reader() {
+	for msg := range p.chResponses {
 
 		packet := gopacket.NewPacket(msg, layers.LayerTypeDNS, decodeOptions)
 		layer := packet.Layer(layers.LayerTypeDNS)
@@ -310,6 +1260,12 @@ func (p *Proxy) reader(client *UDPClient) {
 		}
 		dns, _ := layer.(*layers.DNS)
 
+		if dns.TC {
+			if p.retryTCP(dns.ID) {
+				continue
+			}
+		}
+
 		if p.Verbose > 2 {
 			if hasSvcParams(dns) {
 				log.Printf("DNS response with SvcParams:\n%s", hex.Dump(msg))
@@ -322,46 +1278,221 @@ func (p *Proxy) reader(client *UDPClient) {
 		var ok bool
 		p.m.Lock()
 		pending, ok = p.pending[dns.ID]
+		if ok && !pending.matches(dns) {
+			// A late response to a reused ID or a spoofed response.
+			ok = false
+		}
 		if ok {
 			delete(p.pending, dns.ID)
+			pending.timer.Stop()
+			p.uncoalesce(pending)
 		}
 		p.m.Unlock()
 
 		if !ok {
-			log.Printf("Unknown server response:\n%s", hex.Dump(msg))
+			if p.Verbose > 1 {
+				log.Printf("Unknown server response:\n%s", hex.Dump(msg))
+			}
 			continue
 		}
+		p.tapForwarder(DnstapForwarderResponse, pending.upstream, pending.sent,
+			msg)
+		latency := time.Since(pending.sent)
+		pending.pool.success(pending.upstream, latency)
+		metricUpstreamLatency.Observe(latency.Seconds(),
+			pending.upstream.Type.String())
+
+		if pending.done != nil {
+			pending.done <- dns
+			continue
+		}
+		if p.Validator != nil && !pending.cd {
+			// The validator resolves the DNSSEC records through the
+			// reader so the validation can't block it.
+			go p.validate(pending, dns)
+			continue
+		}
+		p.respond(pending, dns)
+	}
+}
+
+// validate validates the response with the Validator. The bogus
+// responses are answered with SERVFAIL and the secure responses have
+// the AD bit set.
+func (p *Proxy) validate(pending *Pending, dns *layers.DNS) {
+	security, err := p.Validator.Validate(dns)
+	if security == Bogus {
+		var text string
+		if err != nil {
+			text = err.Error()
+		}
+		p.servfail(pending, EDEDNSSECBogus, text)
+		return
+	}
+	if p.Verbose > 1 && len(dns.Questions) > 0 {
+		fmt.Printf(" \U0001F512 %s %s\n", dns.Questions[0].Name, security)
+	}
+	if security == Secure {
+		dns.Z |= dnsFlagAD
+	} else {
+		dns.Z &^= dnsFlagAD
+	}
+	p.respond(pending, dns)
+}
 
-		// Filter DNSSvcParamKeyDoHPath and DNSSvcParamKeyDoHURI
-		// responses from DNSTypeSVCB and DNSTypeHTTPS resource
-		// records.
-		dns.Answers = p.filterDoH(dns.Answers)
-		dns.Authorities = p.filterDoH(dns.Authorities)
-		dns.Additionals = p.filterDoH(dns.Additionals)
+// respond writes the upstream response to the client and caches it.
+func (p *Proxy) respond(pending *Pending, dns *layers.DNS) {
+	p.fanout(pending, dns)
 
-		// Restore original request ID
-		dns.ID = pending.id
+	if p.Validator != nil {
+		// The DNSSEC records can't be serialized.
+		dns.Answers = stripDNSSEC(dns.Answers)
+		dns.Authorities = stripDNSSEC(dns.Authorities)
+		dns.Additionals = stripDNSSEC(dns.Additionals)
+	}
 
-		response, err := udpResponse(pending.packet)
+	// Filter DNSSvcParamKeyDoHPath and DNSSvcParamKeyDoHURI
+	// responses from DNSTypeSVCB and DNSTypeHTTPS resource
+	// records.
+	dns.Answers = p.filterDoH(dns.Answers)
+	dns.Authorities = p.filterDoH(dns.Authorities)
+	dns.Additionals = p.filterDoH(dns.Additionals)
+
+	// Restore original request ID
+	dns.ID = pending.id
+
+	if pending.ecs {
+		// The client did not send the configured client subnet.
+		stripOption(dns.Additionals, layers.DNSOptionCodeEDNSClientSubnet)
+	}
+
+	if p.Rebinding != nil && p.rebound(dns) && p.Rebinding.Refuse {
+		if pending.answered() {
+			return
+		}
+		p.logQuery(pending.packet, dns, pending.decision(), nil,
+			layers.DNSResponseCodeRefused, pending)
+		err := p.synthesize(pending.packet, dns,
+			layers.DNSResponseCodeRefused, nil)
 		if err != nil {
-			log.Printf("Can't create UDP response: %s\n", err)
-			continue
+			log.Printf("Failed to write UDP response: %s\n", err)
 		}
-		response = append(response, dns)
+		return
+	}
 
-		buffer := gopacket.NewSerializeBuffer()
-		err = gopacket.SerializeLayers(buffer, serializeOptions, response...)
+	// The upstream response is cached with the CNAME target question
+	// so that Query finds it.
+	upstream := *dns
+	if pending.chain != nil {
+		unalias(dns, pending.questions, pending.chain)
+	}
+
+	rule, cname := p.cloaked(dns)
+	if rule != nil {
+		if pending.answered() {
+			return
+		}
+		labels := NewLabels(string(dns.Questions[0].Name))
+		if p.Verbose > 1 {
+			fmt.Printf(" \U0001F6D1 %s \u2192 %s (%s)\n", labels, cname,
+				rule)
+		}
+		if p.Events != nil {
+			p.Events <- Event{
+				Type:   EventBlock,
+				Labels: labels,
+				CNAME:  cname,
+			}
+		}
+		err := p.block(pending.packet, dns, rule)
 		if err != nil {
-			log.Printf("Serialization error: %s", err)
-			log.Printf("Layers: %v", response)
-			continue
+			log.Printf("Failed to write UDP response: %s\n", err)
 		}
+		return
+	}
 
-		_, err = p.out.Write(buffer.Bytes())
+	// The clients that were answered with stale data are not
+	// answered again and the prefetch queries have no clients, but
+	// their responses refresh the cache.
+	if !pending.answered() {
+		p.logQuery(pending.packet, dns, pending.decision(), nil,
+			dns.ResponseCode, pending)
+		err := p.writeResponse(pending.packet, pending.udpSize, dns)
 		if err != nil {
 			log.Printf("Failed to write UDP response: %s\n", err)
+			return
 		}
 	}
+	// The responses to the CD queries are not validated and they
+	// can't be served to the other clients.
+	// The response is cached with the question of the upstream
+	// query. The responses tailored to the client's subnet are not
+	// shared with the other clients.
+	if p.Cache != nil && pending.question != nil && !pending.clientECS &&
+		!(pending.cd && p.Validator != nil) {
+		upstream.Questions = []layers.DNSQuestion{*pending.question}
+		p.Cache.Put(&upstream)
+	}
+}
+
+// retryTCP resends the pending query id over TCP. The function
+// returns true if the query was resent and false if the query was
+// already sent over TCP or if it is not pending.
+func (p *Proxy) retryTCP(id uint16) bool {
+	p.m.Lock()
+	pending, ok := p.pending[id]
+	if !ok || pending.tcp {
+		p.m.Unlock()
+		return false
+	}
+	pending.tcp = true
+	pending.timer.Reset(p.Timeout)
+	u := pending.upstream
+	p.m.Unlock()
+
+	if p.Verbose > 1 {
+		fmt.Printf(" \u2702 truncated response, retrying over TCP\n")
+	}
+
+	err := u.sendTCP(pending.data)
+	if err != nil {
+		log.Printf("TCP query failed: %s\n", err)
+		return false
+	}
+	return true
+}
+
+// stripDNSSEC removes the RRSIG, NSEC, and NSEC3 records.
+func stripDNSSEC(rrs []layers.DNSResourceRecord) []layers.DNSResourceRecord {
+	var result []layers.DNSResourceRecord
+	for _, rr := range rrs {
+		switch rr.Type {
+		case dnsTypeRRSIG, dnsTypeNSEC, dnsTypeNSEC3:
+		default:
+			result = append(result, rr)
+		}
+	}
+	return result
+}
+
+// stripUnsolicitedDNSSEC removes the DNSSEC records from the response
+// if the query does not have the DO bit set (RFC 3225 section 3).
+// The answers of the queried DNSSEC type are kept.
+func stripUnsolicitedDNSSEC(response, query *layers.DNS) {
+	if hasDO(query) {
+		return
+	}
+	var qtype layers.DNSType
+	if len(query.Questions) > 0 {
+		qtype = query.Questions[0].Type
+	}
+	switch qtype {
+	case dnsTypeRRSIG, dnsTypeNSEC, dnsTypeNSEC3:
+	default:
+		response.Answers = stripDNSSEC(response.Answers)
+	}
+	response.Authorities = stripDNSSEC(response.Authorities)
+	response.Additionals = stripDNSSEC(response.Additionals)
 }
 
 func hasSvcParams(dns *layers.DNS) bool {
@@ -0,0 +1,410 @@
+//
+// proxy_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// testOutput captures the packets the proxy writes to the tunnel.
+type testOutput chan []byte
+
+func (out testOutput) Write(data []byte) (int, error) {
+	msg := make([]byte, len(data))
+	copy(msg, data)
+	out <- msg
+	return len(data), nil
+}
+
+// response returns the DNS response from the next output packet.
+func (out testOutput) response(t *testing.T) *layers.DNS {
+	t.Helper()
+	select {
+	case data := <-out:
+		packet := gopacket.NewPacket(data, layers.LayerTypeIPv4,
+			gopacket.Default)
+		layer := packet.Layer(layers.LayerTypeDNS)
+		if layer == nil {
+			t.Fatalf("non-DNS response: %s", packet)
+		}
+		return layer.(*layers.DNS)
+
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no response")
+		return nil
+	}
+}
+
+// testQuery creates a tunnel query packet for the name.
+func testQuery(t *testing.T, id uint16, name string,
+	qtype layers.DNSType) (gopacket.Packet, *layers.DNS) {
+
+	return testClientQuery(t, 40000, id, name, qtype)
+}
+
+// testClientQuery creates a tunnel query packet for the name from
+// the client port.
+func testClientQuery(t *testing.T, port layers.UDPPort, id uint16,
+	name string, qtype layers.DNSType) (gopacket.Packet, *layers.DNS) {
+
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IPv4(192, 168, 192, 1),
+		DstIP:    net.IPv4(192, 168, 192, 254),
+	}
+	udp := &layers.UDP{
+		SrcPort: port,
+		DstPort: 53,
+	}
+	udp.SetNetworkLayerForChecksum(ip)
+	query := &layers.DNS{
+		ID:        id,
+		OpCode:    layers.DNSOpCodeQuery,
+		RD:        true,
+		Questions: []layers.DNSQuestion{question(name, qtype)},
+	}
+	buffer := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buffer, serializeOptions, ip, udp, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buffer.Bytes(), layers.LayerTypeIPv4,
+		decodeOptions)
+	layer := packet.Layer(layers.LayerTypeDNS)
+	if layer == nil {
+		t.Fatalf("non-DNS query: %s", packet)
+	}
+	return packet, layer.(*layers.DNS)
+}
+
+// testServer implements an upstream DNS server that answers all A
+// queries with 192.0.2.1. If silent is set, the server does not
+// answer. The names in cnames are answered with CNAME records. If
+// handler is set, the server answers with the handler's responses.
+type testServer struct {
+	conn    net.PacketConn
+	silent  bool
+	cnames  map[string]string
+	handler func(q *layers.DNS) []byte
+	queries chan *layers.DNS
+}
+
+func newTestServer(t *testing.T, silent bool) *testServer {
+	return startTestServer(t, &testServer{
+		silent: silent,
+	})
+}
+
+func startTestServer(t *testing.T, server *testServer) *testServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.conn = conn
+	server.queries = make(chan *layers.DNS, 100)
+	go server.serve()
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return server
+}
+
+func (server *testServer) Addr() string {
+	return server.conn.LocalAddr().String()
+}
+
+func (server *testServer) serve() {
+	var buf [65535]byte
+	for {
+		n, addr, err := server.conn.ReadFrom(buf[:])
+		if err != nil {
+			return
+		}
+		packet := gopacket.NewPacket(buf[:n], layers.LayerTypeDNS,
+			gopacket.Default)
+		layer := packet.Layer(layers.LayerTypeDNS)
+		if layer == nil {
+			continue
+		}
+		q := layer.(*layers.DNS)
+		select {
+		case server.queries <- q:
+		default:
+		}
+		if server.silent {
+			continue
+		}
+		if server.handler != nil {
+			resp := server.handler(q)
+			if resp != nil {
+				server.conn.WriteTo(resp, addr)
+			}
+			continue
+		}
+		resp := &layers.DNS{
+			ID:        q.ID,
+			QR:        true,
+			OpCode:    q.OpCode,
+			RD:        q.RD,
+			RA:        true,
+			Questions: q.Questions,
+		}
+		for _, question := range q.Questions {
+			if question.Type != layers.DNSTypeA {
+				continue
+			}
+			name := question.Name
+			cname, ok := server.cnames[string(name)]
+			if ok {
+				resp.Answers = append(resp.Answers, layers.DNSResourceRecord{
+					Name:  name,
+					Type:  layers.DNSTypeCNAME,
+					Class: layers.DNSClassIN,
+					TTL:   60,
+					CNAME: []byte(cname),
+				})
+				name = []byte(cname)
+			}
+			resp.Answers = append(resp.Answers, layers.DNSResourceRecord{
+				Name:  name,
+				Type:  layers.DNSTypeA,
+				Class: layers.DNSClassIN,
+				TTL:   60,
+				IP:    net.IPv4(192, 0, 2, 1),
+			})
+		}
+		buffer := gopacket.NewSerializeBuffer()
+		err = gopacket.SerializeLayers(buffer, serializeOptions, resp)
+		if err != nil {
+			continue
+		}
+		server.conn.WriteTo(buffer.Bytes(), addr)
+	}
+}
+
+func newTestProxy(t *testing.T, servers ...*testServer) (*Proxy, testOutput) {
+	var addrs []string
+	for _, server := range servers {
+		addrs = append(addrs, server.Addr())
+	}
+	out := make(testOutput, 100)
+	proxy, err := NewProxy(addrs, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proxy, out
+}
+
+func TestProxyQuery(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, false))
+	proxy.Cache = NewCache(10)
+
+	for i := 0; i < 2; i++ {
+		packet, query := testQuery(t, 0x1234, "www.example.com",
+			layers.DNSTypeA)
+		err := proxy.Query(packet, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := out.response(t)
+		if resp.ID != 0x1234 {
+			t.Errorf("response ID %x, expected %x", resp.ID, 0x1234)
+		}
+		if len(resp.Answers) != 1 ||
+			!resp.Answers[0].IP.Equal(net.IPv4(192, 0, 2, 1)) {
+			t.Errorf("unexpected answers: %v", resp.Answers)
+		}
+		if i == 0 {
+			// Wait until the response is cached.
+			for proxy.Cache.Len() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+}
+
+func TestProxyQuestionMismatch(t *testing.T) {
+	server := &testServer{}
+	server.handler = func(q *layers.DNS) []byte {
+		// Answer with a different question.
+		resp := &layers.DNS{
+			ID:     q.ID,
+			QR:     true,
+			OpCode: q.OpCode,
+			RD:     q.RD,
+			RA:     true,
+			Questions: []layers.DNSQuestion{
+				question("evil.example.com", layers.DNSTypeA),
+			},
+			Answers: []layers.DNSResourceRecord{
+				{
+					Name:  []byte("evil.example.com"),
+					Type:  layers.DNSTypeA,
+					Class: layers.DNSClassIN,
+					TTL:   60,
+					IP:    net.IPv4(192, 0, 2, 66),
+				},
+			},
+		}
+		buffer := gopacket.NewSerializeBuffer()
+		err := gopacket.SerializeLayers(buffer, serializeOptions, resp)
+		if err != nil {
+			return nil
+		}
+		return buffer.Bytes()
+	}
+	startTestServer(t, server)
+	proxy, out := newTestProxy(t, server)
+	proxy.Timeout = 50 * time.Millisecond
+	proxy.Retries = 0
+	proxy.Cache = NewCache(10)
+
+	packet, query := testQuery(t, 1, "www.example.com", layers.DNSTypeA)
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := out.response(t)
+	if resp.ResponseCode != layers.DNSResponseCodeServFail {
+		t.Errorf("got %s, expected %s", resp.ResponseCode,
+			layers.DNSResponseCodeServFail)
+	}
+	if proxy.Cache.Len() != 0 {
+		t.Errorf("mismatched response cached")
+	}
+}
+
+func TestProxyLocalCNAME(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, false))
+	proxy.Local = NewLocalRecords()
+	proxy.Local.Add(layers.DNSResourceRecord{
+		Name:  []byte("alias.example.com"),
+		Type:  layers.DNSTypeCNAME,
+		Class: layers.DNSClassIN,
+		TTL:   DefaultLocalTTL,
+		CNAME: []byte("www.example.com"),
+	})
+
+	packet, query := testQuery(t, 1, "alias.example.com", layers.DNSTypeA)
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := out.response(t)
+	if len(resp.Questions) != 1 ||
+		string(resp.Questions[0].Name) != "alias.example.com" {
+		t.Errorf("unexpected questions: %v", resp.Questions)
+	}
+	if len(resp.Answers) != 2 ||
+		string(resp.Answers[0].CNAME) != "www.example.com" ||
+		string(resp.Answers[1].Name) != "www.example.com" {
+		t.Errorf("unexpected answers: %v", resp.Answers)
+	}
+}
+
+func TestProxyLocalCNAMECache(t *testing.T) {
+	server := newTestServer(t, false)
+	proxy, out := newTestProxy(t, server)
+	proxy.Cache = NewCache(10)
+	proxy.Local = NewLocalRecords()
+	proxy.Local.Add(layers.DNSResourceRecord{
+		Name:  []byte("alias.example.com"),
+		Type:  layers.DNSTypeCNAME,
+		Class: layers.DNSClassIN,
+		TTL:   DefaultLocalTTL,
+		CNAME: []byte("www.example.com"),
+	})
+
+	for i := 0; i < 2; i++ {
+		packet, query := testQuery(t, uint16(i), "alias.example.com",
+			layers.DNSTypeA)
+		err := proxy.Query(packet, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := out.response(t)
+		if len(resp.Answers) != 2 ||
+			string(resp.Answers[0].CNAME) != "www.example.com" ||
+			string(resp.Answers[1].Name) != "www.example.com" {
+			t.Errorf("query %d: unexpected answers: %v", i, resp.Answers)
+		}
+	}
+	if n := len(server.queries); n != 1 {
+		t.Errorf("got %d upstream queries, expected 1", n)
+	}
+
+	// The upstream response is cached with the target question.
+	resp := proxy.Cache.Get(question("www.example.com", layers.DNSTypeA))
+	if resp == nil {
+		t.Fatalf("target response not cached")
+	}
+	if len(resp.Answers) != 1 {
+		t.Errorf("unexpected cached answers: %v", resp.Answers)
+	}
+	if proxy.Cache.Get(question("alias.example.com", layers.DNSTypeA)) != nil {
+		t.Errorf("alias response cached")
+	}
+}
+
+func TestProxyCNAMECloaking(t *testing.T) {
+	server := startTestServer(t, &testServer{
+		cnames: map[string]string{
+			"metrics.example.com": "tracker.adnetwork.com",
+			"www.example.com":     "www.cdn.example.net",
+		},
+	})
+	proxy, out := newTestProxy(t, server)
+	proxy.Cache = NewCache(10)
+	proxy.SetBlacklist([]Rule{
+		{Pattern: NewLabels("**.adnetwork.com")},
+	})
+	events := make(chan Event, 10)
+	proxy.Events = events
+
+	packet, query := testQuery(t, 1, "metrics.example.com", layers.DNSTypeA)
+	if err := proxy.Query(packet, query); err != nil {
+		t.Fatal(err)
+	}
+	resp := out.response(t)
+	if resp.ResponseCode != layers.DNSResponseCodeNXDomain ||
+		len(resp.Answers) != 0 {
+		t.Errorf("unexpected cloaked response: %v %v", resp.ResponseCode,
+			resp.Answers)
+	}
+	var block *Event
+	for len(events) > 0 {
+		event := <-events
+		if event.Type == EventBlock {
+			block = &event
+		}
+	}
+	if block == nil || block.Labels.String() != "metrics.example.com" ||
+		block.CNAME.String() != "tracker.adnetwork.com" {
+		t.Errorf("unexpected block event: %v", block)
+	}
+	if proxy.Cache.Len() != 0 {
+		t.Errorf("cloaked response cached")
+	}
+
+	packet, query = testQuery(t, 2, "www.example.com", layers.DNSTypeA)
+	if err := proxy.Query(packet, query); err != nil {
+		t.Fatal(err)
+	}
+	resp = out.response(t)
+	if len(resp.Answers) != 2 {
+		t.Errorf("unexpected answers: %v", resp.Answers)
+	}
+}
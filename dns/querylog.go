@@ -0,0 +1,380 @@
+//
+// querylog.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Structured query log.
+//
+
+package dns
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// Query log constants.
+const (
+	DefaultLogMaxSize    = 64 * 1024 * 1024
+	DefaultLogMaxAge     = 24 * time.Hour
+	DefaultLogMaxBackups = 7
+	// logTimeFormat defines the timestamp format of the rotated log
+	// files.
+	logTimeFormat = "20060102T150405"
+)
+
+// Decision defines how the proxy resolved queries.
+type Decision int
+
+// Query decisions.
+const (
+	DecisionForwarded Decision = iota
+	DecisionBlocked
+	DecisionCached
+	DecisionPassthrough
+	DecisionLocal
+	DecisionLimited
+	DecisionDropped
+	DecisionStale
+)
+
+var decisions = map[Decision]string{
+	DecisionForwarded:   "forwarded",
+	DecisionBlocked:     "blocked",
+	DecisionCached:      "cached",
+	DecisionPassthrough: "passthrough",
+	DecisionLocal:       "local",
+	DecisionLimited:     "limited",
+	DecisionDropped:     "dropped",
+	DecisionStale:       "stale",
+}
+
+func (d Decision) String() string {
+	name, ok := decisions[d]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{Decision %d}", d)
+}
+
+// ParseDecision parses the decision name.
+func ParseDecision(name string) (Decision, error) {
+	for d, n := range decisions {
+		if n == name {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown decision: %s", name)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Decision) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Decision) UnmarshalText(text []byte) error {
+	v, err := ParseDecision(string(text))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// LogRecord defines a query log record.
+type LogRecord struct {
+	Time     time.Time     `json:"time"`
+	Client   string        `json:"client"`
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Decision Decision      `json:"decision"`
+	Rule     string        `json:"rule,omitempty"`
+	Upstream string        `json:"upstream,omitempty"`
+	RCode    string        `json:"rcode,omitempty"`
+	Latency  time.Duration `json:"latency,omitempty"`
+}
+
+// QueryLog writes the query log records as JSON lines. The log file
+// is rotated when it exceeds MaxSize bytes or when its first record
+// is older than MaxAge. The rotated files are named by their
+// rotation time and at most MaxBackups of them are kept.
+type QueryLog struct {
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	path       string
+	now        func() time.Time
+	m          sync.Mutex
+	f          *os.File
+	size       int64
+	started    time.Time
+}
+
+// NewQueryLog opens the query log file. New records are appended to
+// an existing log file.
+func NewQueryLog(path string) (*QueryLog, error) {
+	l := &QueryLog{
+		MaxSize:    DefaultLogMaxSize,
+		MaxAge:     DefaultLogMaxAge,
+		MaxBackups: DefaultLogMaxBackups,
+		path:       path,
+		now:        time.Now,
+	}
+	err := l.open()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *QueryLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = fi.Size()
+	l.started = l.now()
+	if l.size > 0 {
+		// The log age is the age of its first record.
+		started, err := firstRecordTime(l.path)
+		if err == nil {
+			l.started = started
+		}
+	}
+	return nil
+}
+
+func firstRecordTime(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return time.Time{}, err
+	}
+	var rec LogRecord
+	err = json.Unmarshal(line, &rec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return rec.Time, nil
+}
+
+// Log writes the record to the log.
+func (l *QueryLog) Log(rec *LogRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.f == nil {
+		return os.ErrClosed
+	}
+	if l.size > 0 && (l.size+int64(len(data)) > l.MaxSize ||
+		l.now().Sub(l.started) > l.MaxAge) {
+		err = l.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(data)
+	l.size += int64(n)
+	return err
+}
+
+// rotate renames the current log file by the rotation time and
+// opens a new log file.
+func (l *QueryLog) rotate() error {
+	err := l.f.Close()
+	l.f = nil
+	if err != nil {
+		return err
+	}
+	name := l.path + "." + l.now().Format(logTimeFormat)
+	for i := 1; ; i++ {
+		_, err = os.Stat(name)
+		if err != nil {
+			break
+		}
+		name = fmt.Sprintf("%s.%s.%d", l.path, l.now().Format(logTimeFormat),
+			i)
+	}
+	err = os.Rename(l.path, name)
+	if err != nil {
+		return err
+	}
+	backups, err := QueryLogFiles(l.path)
+	if err != nil {
+		return err
+	}
+	// The last file is the current log file.
+	backups = backups[:len(backups)-1]
+	for len(backups) > l.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return l.open()
+}
+
+// Close closes the query log.
+func (l *QueryLog) Close() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// QueryLogFiles returns the query log files in the chronological
+// order: the rotated files followed by the current log file.
+func QueryLogFiles(path string) ([]string, error) {
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, backup := range backups {
+		suffix := strings.TrimPrefix(backup, path+".")
+		if len(suffix) < len(logTimeFormat) {
+			continue
+		}
+		_, err := time.Parse(logTimeFormat, suffix[:len(logTimeFormat)])
+		if err != nil {
+			continue
+		}
+		result = append(result, backup)
+	}
+	sort.Strings(result)
+	return append(result, path), nil
+}
+
+// ReadQueryLog reads the query log records that are not older than
+// since. The records of the rotated log files are read in the
+// chronological order.
+func ReadQueryLog(path string, since time.Time,
+	f func(rec *LogRecord) error) error {
+
+	files, err := QueryLogFiles(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if fi.ModTime().Before(since) {
+			// All records are older than since.
+			continue
+		}
+		err = readQueryLog(file, since, f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readQueryLog(file string, since time.Time,
+	f func(rec *LogRecord) error) error {
+
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
+	var line int
+	for scanner.Scan() {
+		line++
+		var rec LogRecord
+		err = json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", file, line, err)
+		}
+		if rec.Time.Before(since) {
+			continue
+		}
+		err = f(&rec)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// logQuery counts the query in the query metrics and writes the
+// query log record for the query's first question. The pending query
+// specifies the upstream and the latency of the forwarded queries.
+func (p *Proxy) logQuery(packet gopacket.Packet, dns *layers.DNS,
+	decision Decision, rule *Rule, rcode layers.DNSResponseCode,
+	pending *Pending) {
+
+	if len(dns.Questions) == 0 {
+		return
+	}
+	q := dns.Questions[0]
+	metricQueries.Inc(typeString(q.Type), decision.String())
+	if p.QueryLog == nil {
+		return
+	}
+	rec := &LogRecord{
+		Time:     time.Now(),
+		Client:   clientAddr(packet),
+		Name:     strings.ToLower(string(q.Name)),
+		Type:     typeString(q.Type),
+		Decision: decision,
+	}
+	if decision != DecisionDropped {
+		rec.RCode = rcode.String()
+	}
+	if rule != nil {
+		rec.Rule = rule.String()
+	}
+	if pending != nil {
+		if pending.upstream != nil {
+			rec.Upstream = pending.upstream.Name
+		}
+		rec.Latency = rec.Time.Sub(pending.timestamp)
+	}
+	err := p.QueryLog.Log(rec)
+	if err != nil {
+		log.Printf("Failed to write query log: %s\n", err)
+	}
+}
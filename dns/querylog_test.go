@@ -0,0 +1,205 @@
+//
+// querylog_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func readTestLog(t *testing.T, path string, since time.Time) []*LogRecord {
+	var result []*LogRecord
+	err := ReadQueryLog(path, since, func(rec *LogRecord) error {
+		result = append(result, rec)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestQueryLogRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	l, err := NewQueryLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time {
+		return now
+	}
+	l.started = now
+	l.MaxSize = 400
+	l.MaxBackups = 2
+
+	names := []string{"a.example.com", "b.example.com", "c.example.com"}
+	for i := 0; i < 12; i++ {
+		now = now.Add(time.Second)
+		err = l.Log(&LogRecord{
+			Time:     now,
+			Client:   "192.168.192.1",
+			Name:     names[i%len(names)],
+			Type:     "A",
+			Decision: DecisionBlocked,
+			RCode:    "NXDomain",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := QueryLogFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got files %v, expected 2 backups and the log", files)
+	}
+	recs := readTestLog(t, path, time.Time{})
+	if len(recs) == 0 || len(recs) >= 12 {
+		t.Fatalf("got %d records", len(recs))
+	}
+	for i := 1; i < len(recs); i++ {
+		if !recs[i].Time.After(recs[i-1].Time) {
+			t.Errorf("records out of order: %s, %s", recs[i-1].Time,
+				recs[i].Time)
+		}
+	}
+	if recs[len(recs)-1].Decision != DecisionBlocked {
+		t.Errorf("got decision %s", recs[len(recs)-1].Decision)
+	}
+
+	// Rotate by age.
+	l.MaxSize = DefaultLogMaxSize
+	l.MaxAge = time.Hour
+	now = now.Add(2 * time.Hour)
+	err = l.Log(&LogRecord{
+		Time: now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err = QueryLogFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got files %v", files)
+	}
+	var count int
+	err = readQueryLog(path, time.Time{}, func(rec *LogRecord) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d records after age rotation", count)
+	}
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopened log keeps its age.
+	l, err = NewQueryLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if !l.started.Equal(now) {
+		t.Errorf("reopened log started %s, expected %s", l.started, now)
+	}
+}
+
+func TestQueryLogSince(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	l, err := NewQueryLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		err = l.Log(&LogRecord{
+			Time: now.Add(time.Duration(i-3) * time.Hour),
+			Name: "www.example.com",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	recs := readTestLog(t, path, now.Add(-90*time.Minute))
+	if len(recs) != 2 {
+		t.Errorf("got %d records, expected 2", len(recs))
+	}
+}
+
+func TestProxyQueryLog(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, false))
+	proxy.Cache = NewCache(10)
+	proxy.SetBlacklist([]Rule{
+		{
+			Pattern: NewLabels("ads.example.com"),
+		},
+	})
+	path := filepath.Join(t.TempDir(), "queries.log")
+	var err error
+	proxy.QueryLog, err = NewQueryLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.QueryLog.Close()
+
+	for i, name := range []string{
+		"www.example.com", "ads.example.com", "www.example.com",
+	} {
+		packet, query := testQuery(t, uint16(i), name, layers.DNSTypeA)
+		err := proxy.Query(packet, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.response(t)
+		for i == 0 && proxy.Cache.Len() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	recs := readTestLog(t, path, time.Time{})
+	expected := []struct {
+		name     string
+		decision Decision
+		rcode    string
+		rule     string
+	}{
+		{"www.example.com", DecisionForwarded, "No Error", ""},
+		{"ads.example.com", DecisionBlocked, "Non-Existent Domain",
+			"ads.example.com"},
+		{"www.example.com", DecisionCached, "No Error", ""},
+	}
+	if len(recs) != len(expected) {
+		t.Fatalf("got %d records, expected %d", len(recs), len(expected))
+	}
+	for i, e := range expected {
+		rec := recs[i]
+		if rec.Name != e.name || rec.Decision != e.decision ||
+			rec.RCode != e.rcode || rec.Rule != e.rule ||
+			rec.Client != "192.168.192.1" || rec.Type != "A" {
+			t.Errorf("record %d: got %+v, expected %+v", i, rec, e)
+		}
+	}
+	if len(recs[0].Upstream) == 0 || recs[0].Latency <= 0 {
+		t.Errorf("forwarded record without upstream: %+v", recs[0])
+	}
+}
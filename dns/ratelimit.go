@@ -0,0 +1,234 @@
+//
+// ratelimit.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Query rate limiting.
+//
+
+package dns
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limiter constants.
+const (
+	// MaxRateLimitBuckets defines the maximum number of token
+	// buckets per limit type. The idle buckets are removed when the
+	// limit is reached.
+	MaxRateLimitBuckets = 4096
+)
+
+// Limit defines the rate limit types.
+type Limit int
+
+// Rate limit types.
+const (
+	LimitClient Limit = iota
+	LimitDomain
+	LimitInFlight
+)
+
+var limits = map[Limit]string{
+	LimitClient:   "client",
+	LimitDomain:   "domain",
+	LimitInFlight: "in-flight",
+}
+
+func (l Limit) String() string {
+	name, ok := limits[l]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{Limit %d}", l)
+}
+
+// Rate defines a token bucket rate. The zero rate is unlimited.
+type Rate struct {
+	// Rate defines the sustained rate in queries per second.
+	Rate float64
+	// Burst defines the bucket size in queries.
+	Burst int
+}
+
+// ParseRate parses the rate specification RATE[/BURST]. The burst
+// defaults to the rate rounded up.
+func ParseRate(spec string) (Rate, error) {
+	var result Rate
+	var err error
+
+	parts := strings.SplitN(spec, "/", 2)
+	result.Rate, err = strconv.ParseFloat(parts[0], 64)
+	if err != nil || result.Rate <= 0 {
+		return result, fmt.Errorf("invalid rate: %s", spec)
+	}
+	if len(parts) == 2 {
+		result.Burst, err = strconv.Atoi(parts[1])
+		if err != nil || result.Burst < 1 {
+			return result, fmt.Errorf("invalid burst: %s", spec)
+		}
+	} else {
+		result.Burst = int(math.Ceil(result.Rate))
+	}
+	return result, nil
+}
+
+func (r Rate) String() string {
+	if r.Rate == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%g/%d", r.Rate, r.Burst)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter implements token buckets for the rate.
+type limiter struct {
+	rate    Rate
+	buckets map[string]*bucket
+}
+
+func (l *limiter) allow(key string, now time.Time) bool {
+	if l.rate.Rate == 0 {
+		return true
+	}
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= MaxRateLimitBuckets {
+			l.prune(now)
+		}
+		b = &bucket{
+			tokens: float64(l.rate.Burst),
+			last:   now,
+		}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate.Rate
+	if b.tokens > float64(l.rate.Burst) {
+		b.tokens = float64(l.rate.Burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune removes the buckets that have refilled. If all buckets are
+// active, the buckets are reset.
+func (l *limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		tokens := b.tokens + now.Sub(b.last).Seconds()*l.rate.Rate
+		if tokens >= float64(l.rate.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) >= MaxRateLimitBuckets {
+		l.buckets = make(map[string]*bucket)
+	}
+}
+
+// RateLimiter implements token bucket rate limits for the queries
+// per client address and per queried domain, and a global limit for
+// the in-flight upstream queries.
+type RateLimiter struct {
+	// InFlight limits the number of in-flight upstream queries. The
+	// zero value is unlimited.
+	InFlight int
+	// Drop specifies if the over-limit queries are dropped. By
+	// default, the queries are refused.
+	Drop    bool
+	m       sync.Mutex
+	now     func() time.Time
+	clients limiter
+	domains limiter
+}
+
+// NewRateLimiter creates a new rate limiter with the client and
+// domain rates.
+func NewRateLimiter(client, domain Rate) *RateLimiter {
+	return &RateLimiter{
+		now: time.Now,
+		clients: limiter{
+			rate: client,
+		},
+		domains: limiter{
+			rate: domain,
+		},
+	}
+}
+
+func (rl *RateLimiter) String() string {
+	return fmt.Sprintf("client=%s, domain=%s, in-flight=%d",
+		rl.clients.rate, rl.domains.rate, rl.InFlight)
+}
+
+// Allow tests if the client's query for the name is allowed. If the
+// query is over a limit, the function returns false and the
+// exceeded limit.
+func (rl *RateLimiter) Allow(client string, name Labels) (Limit, bool) {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+
+	now := rl.now()
+	if !rl.clients.allow(client, now) {
+		return LimitClient, false
+	}
+	if !rl.allowDomain(name, now) {
+		return LimitDomain, false
+	}
+	return 0, true
+}
+
+// AllowDomain tests if a query for the name is allowed by the domain
+// limit. The proxy's own queries are not limited by the client
+// limit.
+func (rl *RateLimiter) AllowDomain(name Labels) bool {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+
+	return rl.allowDomain(name, rl.now())
+}
+
+// allowDomain tests the query against the limit of its registrable
+// domain. The random subdomains of a domain share the domain's rate
+// limit.
+func (rl *RateLimiter) allowDomain(name Labels, now time.Time) bool {
+	return rl.domains.allow(name.Domain(), now)
+}
+
+// AllowInFlight tests if a new upstream query is allowed when there
+// are n in-flight queries.
+func (rl *RateLimiter) AllowInFlight(n int) bool {
+	return rl.InFlight == 0 || n < rl.InFlight
+}
+
+// RateLimitInfo describes a rate limit decision.
+type RateLimitInfo struct {
+	Limit   Limit
+	Client  string
+	Dropped bool
+}
+
+func (info *RateLimitInfo) String() string {
+	action := "refused"
+	if info.Dropped {
+		action = "dropped"
+	}
+	return fmt.Sprintf("%s limit, %s %s", info.Limit, info.Client, action)
+}
@@ -0,0 +1,248 @@
+//
+// ratelimit_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		spec string
+		rate Rate
+	}{
+		{
+			spec: "10",
+			rate: Rate{Rate: 10, Burst: 10},
+		},
+		{
+			spec: "0.5",
+			rate: Rate{Rate: 0.5, Burst: 1},
+		},
+		{
+			spec: "20/100",
+			rate: Rate{Rate: 20, Burst: 100},
+		},
+	}
+	for _, test := range tests {
+		rate, err := ParseRate(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rate != test.rate {
+			t.Errorf("%s: got %v, expected %v", test.spec, rate, test.rate)
+		}
+	}
+	for _, spec := range []string{"", "0", "-1", "x", "10/0", "10/x"} {
+		_, err := ParseRate(spec)
+		if err == nil {
+			t.Errorf("invalid rate %s accepted", spec)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	rl := NewRateLimiter(Rate{Rate: 2, Burst: 4}, Rate{Rate: 1, Burst: 3})
+	rl.now = func() time.Time {
+		return now
+	}
+
+	www := NewLabels("www.example.com")
+	for i := 0; i < 3; i++ {
+		_, ok := rl.Allow("192.0.2.1", www)
+		if !ok {
+			t.Fatalf("query %d limited", i)
+		}
+	}
+	// The random subdomains share the domain's bucket.
+	limit, ok := rl.Allow("192.0.2.1", NewLabels("x1y2z3.EXAMPLE.com"))
+	if ok || limit != LimitDomain {
+		t.Errorf("got %v %v, expected %v", limit, ok, LimitDomain)
+	}
+	_, ok = rl.Allow("192.0.2.1", NewLabels("www.example.org"))
+	if ok {
+		t.Errorf("client limit not enforced")
+	}
+	limit, ok = rl.Allow("192.0.2.1", NewLabels("www.example.org"))
+	if ok || limit != LimitClient {
+		t.Errorf("got %v %v, expected %v", limit, ok, LimitClient)
+	}
+	_, ok = rl.Allow("192.0.2.2", NewLabels("www.example.org"))
+	if !ok {
+		t.Errorf("other client limited")
+	}
+
+	// The buckets refill at the rates.
+	now = now.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		_, ok = rl.Allow("192.0.2.1", NewLabels("www.example.net"))
+		if !ok {
+			t.Errorf("query %d limited after refill", i)
+		}
+	}
+	_, ok = rl.Allow("192.0.2.1", NewLabels("www.example.net"))
+	if ok {
+		t.Errorf("client limit not enforced after refill")
+	}
+
+	if !rl.AllowInFlight(100) {
+		t.Errorf("unlimited in-flight queries limited")
+	}
+	rl.InFlight = 10
+	if rl.AllowInFlight(10) || !rl.AllowInFlight(9) {
+		t.Errorf("in-flight limit not enforced")
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	now := time.Now()
+	rl := NewRateLimiter(Rate{Rate: 1, Burst: 1}, Rate{})
+	rl.now = func() time.Time {
+		return now
+	}
+	for i := 0; i < MaxRateLimitBuckets; i++ {
+		rl.Allow(string(rune(i)), nil)
+	}
+	now = now.Add(time.Second)
+	rl.Allow("new", nil)
+	if len(rl.clients.buckets) != 1 {
+		t.Errorf("idle buckets not pruned: %d", len(rl.clients.buckets))
+	}
+}
+
+func TestRateLimiterPublicSuffix(t *testing.T) {
+	now := time.Now()
+	rl := NewRateLimiter(Rate{}, Rate{Rate: 1, Burst: 1})
+	rl.now = func() time.Time {
+		return now
+	}
+
+	// The domains under a public suffix have their own buckets.
+	for _, name := range []string{"www.example.co.uk", "www.other.co.uk",
+		"a.github.io", "b.github.io"} {
+		_, ok := rl.Allow("192.0.2.1", NewLabels(name))
+		if !ok {
+			t.Errorf("%s limited", name)
+		}
+	}
+	limit, ok := rl.Allow("192.0.2.1", NewLabels("mail.example.co.uk"))
+	if ok || limit != LimitDomain {
+		t.Errorf("got %v %v, expected %v", limit, ok, LimitDomain)
+	}
+}
+
+func TestProxyRateLimit(t *testing.T) {
+	server := newTestServer(t, false)
+	proxy, out := newTestProxy(t, server)
+	proxy.RateLimit = NewRateLimiter(Rate{Rate: 1, Burst: 2}, Rate{})
+	events := make(chan Event, 10)
+	proxy.Events = events
+
+	for i := 0; i < 3; i++ {
+		packet, query := testQuery(t, uint16(i), "www.example.com",
+			layers.DNSTypeA)
+		err := proxy.Query(packet, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := out.response(t)
+		expected := layers.DNSResponseCodeNoErr
+		if i == 2 {
+			expected = layers.DNSResponseCodeRefused
+		}
+		if resp.ResponseCode != expected {
+			t.Errorf("query %d: got %s, expected %s", i, resp.ResponseCode,
+				expected)
+		}
+	}
+
+	// The over-limit query emits an event.
+	var info *RateLimitInfo
+	for len(events) > 0 {
+		event := <-events
+		if event.Type == EventRateLimit {
+			info = event.RateLimit
+		}
+	}
+	if info == nil || info.Limit != LimitClient ||
+		info.Client != "192.168.192.1" || info.Dropped {
+		t.Errorf("unexpected rate limit event: %v", info)
+	}
+
+	// Dropped queries are not answered.
+	proxy.RateLimit.Drop = true
+	packet, query := testQuery(t, 3, "www.example.com", layers.DNSTypeA)
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-out:
+		t.Errorf("dropped query answered")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestProxyInFlightLimit(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, true))
+	proxy.RateLimit = NewRateLimiter(Rate{}, Rate{})
+	proxy.RateLimit.InFlight = 1
+
+	packet, query := testQuery(t, 1, "www.example.com", layers.DNSTypeA)
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, query = testQuery(t, 2, "www.example.org", layers.DNSTypeA)
+	err = proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := out.response(t)
+	if resp.ID != 2 || resp.ResponseCode != layers.DNSResponseCodeRefused {
+		t.Errorf("got %d %s, expected refused", resp.ID, resp.ResponseCode)
+	}
+}
+
+func TestProxyInFlightLimitConcurrent(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, true))
+	proxy.RateLimit = NewRateLimiter(Rate{}, Rate{})
+	proxy.RateLimit.InFlight = 5
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			packet, query := testQuery(t, uint16(i),
+				fmt.Sprintf("host%d.example.com", i), layers.DNSTypeA)
+			err := proxy.Query(packet, query)
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := proxy.inFlight(); n != 5 {
+		t.Errorf("%d in-flight queries, expected 5", n)
+	}
+	for i := 0; i < 45; i++ {
+		resp := out.response(t)
+		if resp.ResponseCode != layers.DNSResponseCodeRefused {
+			t.Errorf("got %s, expected refused", resp.ResponseCode)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+//
+// rebind.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// DNS rebinding protection.
+//
+
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// Rebinding implements DNS rebinding protection. It filters the
+// private addresses from the upstream answers so that public names
+// can't be used to reach the hosts in the local networks.
+type Rebinding struct {
+	// Networks define the private address ranges.
+	Networks []*net.IPNet
+	// Allow defines the name patterns that can resolve to private
+	// addresses.
+	Allow []Labels
+	// Refuse specifies if the responses with private addresses are
+	// refused. By default, the private addresses are removed from
+	// the responses.
+	Refuse bool
+}
+
+// PrivateNetworks returns the private (RFC 1918), loopback,
+// link-local, unique local (RFC 4193), and unspecified address
+// ranges.
+func PrivateNetworks() []*net.IPNet {
+	var result []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"::/128",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		result = append(result, network)
+	}
+	return result
+}
+
+// NewRebinding creates a new rebinding protection for the private
+// networks. The allow argument specifies the name patterns that are
+// exempt from the protection.
+func NewRebinding(allow ...string) *Rebinding {
+	r := &Rebinding{
+		Networks: PrivateNetworks(),
+	}
+	for _, pattern := range allow {
+		r.Allow = append(r.Allow, NewLabels(strings.ToLower(pattern)))
+	}
+	return r
+}
+
+// ParseNetworks parses the comma separated list of CIDR networks.
+func ParseNetworks(spec string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, cidr := range strings.Split(spec, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid network: %s", cidr)
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
+
+// Exempt tests if the name can resolve to private addresses.
+func (r *Rebinding) Exempt(labels Labels) bool {
+	for _, pattern := range r.Allow {
+		if labels.Match(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Private tests if the IP address belongs to the private networks.
+func (r *Rebinding) Private(ip net.IP) bool {
+	for _, network := range r.Networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter removes the A and AAAA records with private addresses. The
+// function returns the remaining records and the removed private
+// addresses.
+func (r *Rebinding) filter(rrs []layers.DNSResourceRecord) (
+	[]layers.DNSResourceRecord, []net.IP) {
+
+	var result []layers.DNSResourceRecord
+	var private []net.IP
+	for _, rr := range rrs {
+		switch rr.Type {
+		case layers.DNSTypeA, layers.DNSTypeAAAA:
+			if r.Private(rr.IP) {
+				private = append(private, rr.IP)
+				continue
+			}
+		}
+		result = append(result, rr)
+	}
+	return result, private
+}
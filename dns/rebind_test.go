@@ -0,0 +1,125 @@
+//
+// rebind_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func TestRebindingPrivate(t *testing.T) {
+	r := NewRebinding()
+	tests := []struct {
+		ip      string
+		private bool
+	}{
+		{"10.1.2.3", true},
+		{"172.31.255.255", true},
+		{"172.32.0.1", false},
+		{"192.168.1.1", true},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"8.8.8.8", false},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"::ffff:10.0.0.1", true},
+		{"2001:db8::1", false},
+	}
+	for _, test := range tests {
+		private := r.Private(net.ParseIP(test.ip))
+		if private != test.private {
+			t.Errorf("%s: private=%v, expected %v", test.ip, private,
+				test.private)
+		}
+	}
+}
+
+func TestProxyRebinding(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, false))
+	testNet, err := ParseNetworks("192.0.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.Rebinding = NewRebinding("**.internal.example.com")
+	proxy.Rebinding.Networks = testNet
+
+	query := func(name string) *layers.DNS {
+		packet, query := testQuery(t, 1, name, layers.DNSTypeA)
+		if err := proxy.Query(packet, query); err != nil {
+			t.Fatal(err)
+		}
+		return out.response(t)
+	}
+
+	resp := query("www.example.com")
+	if resp.ResponseCode != layers.DNSResponseCodeNoErr ||
+		len(resp.Answers) != 0 {
+		t.Errorf("private address not filtered: %v %v", resp.ResponseCode,
+			resp.Answers)
+	}
+	resp = query("dev.internal.example.com")
+	if len(resp.Answers) != 1 {
+		t.Errorf("exempt name filtered: %v", resp.Answers)
+	}
+
+	proxy.Rebinding.Refuse = true
+	resp = query("www.example.com")
+	if resp.ResponseCode != layers.DNSResponseCodeRefused {
+		t.Errorf("private address not refused: %v", resp.ResponseCode)
+	}
+}
+
+func TestProxyRebindingForward(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, false))
+	corp := newTestServer(t, false)
+	err := proxy.SetForwarding(&Forwarding{
+		Upstreams: map[string]*Pool{
+			"corp": NewPool(StrategyOrder, NewUDPUpstream(corp.Addr())),
+		},
+		Rules: []ForwardRule{
+			{
+				Pattern:  NewLabels("**.corp.example.com"),
+				Upstream: "corp",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testNet, err := ParseNetworks("192.0.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.Rebinding = NewRebinding()
+	proxy.Rebinding.Networks = testNet
+
+	for _, test := range []struct {
+		name    string
+		answers int
+	}{
+		{"www.example.com", 0},
+		{"dev.corp.example.com", 1},
+	} {
+		packet, query := testQuery(t, 1, test.name, layers.DNSTypeA)
+		if err := proxy.Query(packet, query); err != nil {
+			t.Fatal(err)
+		}
+		resp := out.response(t)
+		if len(resp.Answers) != test.answers {
+			t.Errorf("%s: unexpected answers: %v", test.name, resp.Answers)
+		}
+	}
+	if len(corp.queries) != 1 {
+		t.Errorf("internal name not forwarded")
+	}
+}
@@ -0,0 +1,88 @@
+//
+// stale.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Serving stale data (RFC 8767).
+//
+
+package dns
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// serveStale answers the pending query and its followers with the
+// stale cached response if the query is still pending and it has
+// not been answered yet. The upstream query continues in the
+// background and its response refreshes the cache.
+func (p *Proxy) serveStale(id uint16, pending *Pending) {
+	if p.Cache == nil || pending.done != nil || pending.prefetch ||
+		pending.question == nil {
+		return
+	}
+	stale := p.Cache.GetStale(*pending.question)
+	if stale == nil {
+		return
+	}
+
+	p.m.Lock()
+	if p.pending[id] != pending || pending.stale {
+		p.m.Unlock()
+		return
+	}
+	answered := append([]*Pending{pending}, pending.followers...)
+	for _, pend := range answered {
+		pend.stale = true
+	}
+	p.m.Unlock()
+
+	for _, pend := range answered {
+		p.answerStale(pend, stale)
+	}
+}
+
+// answerStale writes the stale response to the pending query. The
+// response has the Stale Answer Extended DNS Error if the client
+// sent an EDNS(0) query. If the stale response is nil, the query is
+// answered with SERVFAIL.
+func (p *Proxy) answerStale(pending *Pending, stale *layers.DNS) {
+	if stale == nil {
+		p.writeServFail(pending, EDENoReachableAuthority, "upstream timeout")
+		return
+	}
+	layer := pending.packet.Layer(layers.LayerTypeDNS)
+	if layer == nil {
+		return
+	}
+	query := layer.(*layers.DNS)
+
+	response := *stale
+	// The additionals are shared by the answered queries.
+	response.Additionals = append([]layers.DNSResourceRecord(nil),
+		stale.Additionals...)
+	response.ID = pending.id
+	response.Questions = query.Questions
+	stripUnsolicitedDNSSEC(&response, query)
+	if pending.chain != nil {
+		unalias(&response, pending.questions, pending.chain)
+	}
+	if pending.edns {
+		setExtendedError(&response, EDEStaleAnswer, "")
+	}
+	if p.Verbose > 0 && len(response.Questions) > 0 {
+		fmt.Printf(" \U0001F4BE %s: %s\n", response.Questions[0].Name,
+			EDEStaleAnswer)
+	}
+	p.logQuery(pending.packet, &response, DecisionStale, nil,
+		response.ResponseCode, pending)
+	err := p.writeResponse(pending.packet, pending.udpSize, &response)
+	if err != nil {
+		log.Printf("Failed to write UDP response: %s\n", err)
+	}
+}
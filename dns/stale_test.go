@@ -0,0 +1,135 @@
+//
+// stale_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+func TestProxyServeStale(t *testing.T) {
+	server := &testServer{}
+	server.handler = func(q *layers.DNS) []byte {
+		// Answer after the proxy has timed out.
+		time.Sleep(150 * time.Millisecond)
+		resp := &layers.DNS{
+			ID:        q.ID,
+			QR:        true,
+			OpCode:    q.OpCode,
+			RD:        q.RD,
+			RA:        true,
+			Questions: q.Questions,
+			Answers: []layers.DNSResourceRecord{
+				{
+					Name:  q.Questions[0].Name,
+					Type:  layers.DNSTypeA,
+					Class: layers.DNSClassIN,
+					TTL:   60,
+					IP:    net.IPv4(192, 0, 2, 2),
+				},
+			},
+		}
+		buffer := gopacket.NewSerializeBuffer()
+		err := gopacket.SerializeLayers(buffer, serializeOptions, resp)
+		if err != nil {
+			return nil
+		}
+		return buffer.Bytes()
+	}
+	startTestServer(t, server)
+	proxy, out := newTestProxy(t, server)
+	proxy.Timeout = 50 * time.Millisecond
+	proxy.Retries = 0
+	proxy.Cache = NewCache(10)
+	proxy.Cache.Stale = time.Hour
+
+	q := question("www.example.com", layers.DNSTypeA)
+	proxy.Cache.put(&layers.DNS{
+		QR:        true,
+		RA:        true,
+		Questions: []layers.DNSQuestion{q},
+		Answers: []layers.DNSResourceRecord{
+			{
+				Name:  q.Name,
+				Type:  layers.DNSTypeA,
+				Class: layers.DNSClassIN,
+				TTL:   60,
+				IP:    net.IPv4(192, 0, 2, 1),
+			},
+		},
+	}, time.Now().Add(-2*time.Minute))
+
+	packet, query := testQuery(t, 7, "www.example.com", layers.DNSTypeA)
+	query.Additionals = append(query.Additionals, layers.DNSResourceRecord{
+		Type:  layers.DNSTypeOPT,
+		Class: 4096,
+	})
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := out.response(t)
+	if resp.ID != 7 || len(resp.Answers) != 1 ||
+		!resp.Answers[0].IP.Equal(net.IPv4(192, 0, 2, 1)) ||
+		resp.Answers[0].TTL != StaleTTL {
+		t.Errorf("unexpected stale response: %v", resp.Answers)
+	}
+	ede, _, ok := extendedError(resp)
+	if !ok || ede != EDEStaleAnswer {
+		t.Errorf("got extended error %v, expected %v", ede, EDEStaleAnswer)
+	}
+
+	// The late upstream response refreshes the cache.
+	var fresh *layers.DNS
+	for i := 0; i < 200 && fresh == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		fresh = proxy.Cache.Get(q)
+	}
+	if fresh == nil {
+		t.Fatalf("stale entry not refreshed")
+	}
+	if !fresh.Answers[0].IP.Equal(net.IPv4(192, 0, 2, 2)) {
+		t.Errorf("unexpected refreshed answers: %v", fresh.Answers)
+	}
+	select {
+	case <-out:
+		t.Errorf("client answered twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestProxyServeStaleExpired(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, true))
+	proxy.Timeout = 50 * time.Millisecond
+	proxy.Retries = 0
+	proxy.Cache = NewCache(10)
+	proxy.Cache.Stale = time.Minute
+
+	q := question("www.example.com", layers.DNSTypeA)
+	proxy.Cache.put(&layers.DNS{
+		QR:          true,
+		Questions:   []layers.DNSQuestion{q},
+		Authorities: []layers.DNSResourceRecord{soa(60, 60)},
+	}, time.Now().Add(-time.Hour))
+
+	packet, query := testQuery(t, 8, "www.example.com", layers.DNSTypeA)
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := out.response(t)
+	if resp.ResponseCode != layers.DNSResponseCodeServFail {
+		t.Errorf("got response code %v, expected SERVFAIL",
+			resp.ResponseCode)
+	}
+}
@@ -0,0 +1,260 @@
+//
+// subscribe.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Remote blacklist subscriptions.
+//
+
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Subscription constants.
+const (
+	// DefaultRefreshInterval defines the default refresh interval of
+	// the blacklist subscriptions.
+	DefaultRefreshInterval = 24 * time.Hour
+	// RefreshRetryInterval defines how soon the failed subscription
+	// fetches are retried.
+	RefreshRetryInterval = 5 * time.Minute
+	// FetchTimeout defines the timeout of the subscription fetches.
+	FetchTimeout = time.Minute
+	// MaxListSize defines the maximum size of the subscribed
+	// blacklists.
+	MaxListSize = 64 * 1024 * 1024
+)
+
+// Subscription implements a remote blacklist subscription. The
+// subscription keeps the last successfully fetched blacklist in its
+// disk cache and uses it until a new version is fetched.
+type Subscription struct {
+	URL    string
+	Format ListFormat
+	// CacheDir specifies the disk cache directory. If empty, the
+	// blacklist is not cached on disk.
+	CacheDir string
+	client   *http.Client
+	m        sync.Mutex
+	meta     subscriptionMeta
+	rules    []Rule
+}
+
+// subscriptionMeta defines the disk cache metadata of a blacklist.
+type subscriptionMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+// NewSubscription creates a new blacklist subscription. If the cache
+// directory has a cached copy of the blacklist, the cached rules are
+// loaded and used until the blacklist is fetched.
+func NewSubscription(url string, format ListFormat, cacheDir string) (
+	*Subscription, error) {
+
+	sub := &Subscription{
+		URL:      url,
+		Format:   format,
+		CacheDir: cacheDir,
+		client: &http.Client{
+			Timeout: FetchTimeout,
+		},
+	}
+	if len(cacheDir) == 0 {
+		return sub, nil
+	}
+	err := os.MkdirAll(cacheDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = sub.loadCache()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (sub *Subscription) String() string {
+	return sub.URL
+}
+
+// Rules returns the current rules of the subscription.
+func (sub *Subscription) Rules() []Rule {
+	sub.m.Lock()
+	defer sub.m.Unlock()
+	return sub.rules
+}
+
+// Fetched returns the time when the blacklist was last fetched.
+func (sub *Subscription) Fetched() time.Time {
+	sub.m.Lock()
+	defer sub.m.Unlock()
+	return sub.meta.Fetched
+}
+
+func (sub *Subscription) cacheFile() string {
+	sum := sha256.Sum256([]byte(sub.URL))
+	return filepath.Join(sub.CacheDir, hex.EncodeToString(sum[:8]))
+}
+
+func (sub *Subscription) loadCache() error {
+	name := sub.cacheFile()
+	data, err := os.ReadFile(name + ".json")
+	if err != nil {
+		return err
+	}
+	var meta subscriptionMeta
+	err = json.Unmarshal(data, &meta)
+	if err != nil {
+		return fmt.Errorf("%s.json: %s", name, err)
+	}
+	if meta.URL != sub.URL {
+		return os.ErrNotExist
+	}
+	data, err = os.ReadFile(name + ".list")
+	if err != nil {
+		return err
+	}
+	rules, err := sub.parse(data)
+	if err != nil {
+		return err
+	}
+
+	sub.m.Lock()
+	sub.meta = meta
+	sub.rules = rules
+	sub.m.Unlock()
+
+	return nil
+}
+
+func (sub *Subscription) saveCache(data []byte,
+	meta subscriptionMeta) error {
+
+	name := sub.cacheFile()
+	err := writeFileAtomic(name+".list", data)
+	if err != nil {
+		return err
+	}
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(name+".json", metaData)
+}
+
+func writeFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+	err := os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// parse parses the blacklist data. The unparseable entries are
+// skipped unless the blacklist does not have any valid rules.
+func (sub *Subscription) parse(data []byte) ([]Rule, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := parseRules(sub.URL, lines, sub.Format, false)
+	if err != nil && len(rules) == 0 {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Fetch fetches the blacklist if it has changed since the last
+// fetch. The function returns true if the rules were updated. If the
+// fetch or parsing fails, the subscription keeps its current
+// rules. The function can return an error also with updated rules if
+// the disk cache can't be written.
+func (sub *Subscription) Fetch() (bool, error) {
+	sub.m.Lock()
+	meta := sub.meta
+	sub.m.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, sub.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	if len(meta.ETag) > 0 {
+		req.Header.Set("If-None-Match", meta.ETag)
+	}
+	if len(meta.LastModified) > 0 {
+		req.Header.Set("If-Modified-Since", meta.LastModified)
+	}
+	resp, err := sub.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	now := time.Now()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+
+	case http.StatusNotModified:
+		sub.m.Lock()
+		sub.meta.Fetched = now
+		sub.m.Unlock()
+		return false, nil
+
+	default:
+		return false, fmt.Errorf("%s: %s", sub.URL, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxListSize+1))
+	if err != nil {
+		return false, err
+	}
+	if len(data) > MaxListSize {
+		return false, fmt.Errorf("%s: blacklist too big", sub.URL)
+	}
+	rules, err := sub.parse(data)
+	if err != nil {
+		return false, err
+	}
+	meta = subscriptionMeta{
+		URL:          sub.URL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Fetched:      now,
+	}
+
+	sub.m.Lock()
+	sub.meta = meta
+	sub.rules = rules
+	sub.m.Unlock()
+
+	if len(sub.CacheDir) > 0 {
+		err = sub.saveCache(data, meta)
+	}
+	return true, err
+}
@@ -0,0 +1,131 @@
+//
+// subscribe_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestSubscription(t *testing.T) {
+	var m sync.Mutex
+	list := "||ads.example.com^\n"
+	etag := `"v1"`
+	status := http.StatusOK
+	var fetches int
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			m.Lock()
+			defer m.Unlock()
+			fetches++
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Write([]byte(list))
+		}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	sub, err := NewSubscription(server.URL, FormatAuto, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRules := func(sub *Subscription, rules ...string) {
+		t.Helper()
+		var rs []string
+		for _, rule := range sub.Rules() {
+			rs = append(rs, rule.String())
+		}
+		if len(rs) != len(rules) {
+			t.Fatalf("got rules %q, expected %q", rs, rules)
+		}
+		for i := range rs {
+			if rs[i] != rules[i] {
+				t.Fatalf("got rules %q, expected %q", rs, rules)
+			}
+		}
+	}
+	fetch := func(updated bool, fail bool) {
+		t.Helper()
+		ok, err := sub.Fetch()
+		if ok != updated || (err != nil) != fail {
+			t.Fatalf("Fetch()=%v,%v", ok, err)
+		}
+	}
+
+	fetch(true, false)
+	expectRules(sub, "**.ads.example.com")
+
+	// Not modified.
+	fetch(false, false)
+	expectRules(sub, "**.ads.example.com")
+
+	// Fetch and parse errors keep the last good rules.
+	m.Lock()
+	status = http.StatusInternalServerError
+	m.Unlock()
+	fetch(false, true)
+	expectRules(sub, "**.ads.example.com")
+
+	m.Lock()
+	status = http.StatusOK
+	list = "||example.com/ads/*\n"
+	etag = `"v2"`
+	m.Unlock()
+	fetch(false, true)
+	expectRules(sub, "**.ads.example.com")
+
+	m.Lock()
+	list = "0.0.0.0 tracker.example.com\n"
+	etag = `"v3"`
+	m.Unlock()
+	fetch(true, false)
+	expectRules(sub, "tracker.example.com")
+
+	// The new subscription starts with the cached rules and uses
+	// the cached ETag.
+	sub, err = NewSubscription(server.URL, FormatAuto, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRules(sub, "tracker.example.com")
+	fetch(false, false)
+
+	m.Lock()
+	if fetches != 6 {
+		t.Errorf("server got %d fetches, expected 6", fetches)
+	}
+	m.Unlock()
+
+	// The subscription server is resolved with the system servers.
+	proxy, _ := newTestProxy(t)
+	watcher := NewListWatcher(proxy)
+	if err := watcher.Subscribe(sub); err != nil {
+		t.Fatal(err)
+	}
+	if !proxy.Passthrough("127.0.0.1") {
+		t.Errorf("subscription server is not passthrough")
+	}
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if rule := proxy.blacklist.Match(
+		NewLabels("tracker.example.com")); rule == nil {
+		t.Errorf("subscription rules not in blacklist")
+	}
+}
@@ -0,0 +1,188 @@
+//
+// tcp.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// DNS over TCP, RFC 1035 section 4.2.2 and RFC 7766.
+//
+
+package dns
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// TCP client constants.
+const (
+	TCPIdleTimeout  = 10 * time.Second
+	TCPDialTimeout  = 5 * time.Second
+	TCPQueryTimeout = 30 * time.Second
+)
+
+// TCPClient implements a DNS-over-TCP client. The client pipelines
+// queries over a persistent connection and reuses the connection
+// until it has been idle for TCPIdleTimeout. While queries are
+// outstanding, the connection is kept open until TCPQueryTimeout has
+// passed from the last query.
+type TCPClient struct {
+	Server       string
+	C            chan []byte
+	m            sync.Mutex
+	conn         net.Conn
+	inFlight     int
+	written      time.Time
+	idleTimeout  time.Duration
+	queryTimeout time.Duration
+}
+
+// NewTCPClient creates a new TCP client. The responses are delivered
+// to the channel c. The client connects to the server lazily when
+// the first query is written.
+func NewTCPClient(server string, c chan []byte) *TCPClient {
+	return &TCPClient{
+		Server:       server,
+		C:            c,
+		idleTimeout:  TCPIdleTimeout,
+		queryTimeout: TCPQueryTimeout,
+	}
+}
+
+func (dns *TCPClient) connect() (net.Conn, error) {
+	dns.m.Lock()
+	defer dns.m.Unlock()
+
+	if dns.conn != nil {
+		return dns.conn, nil
+	}
+	conn, err := net.DialTimeout("tcp", dns.Server, TCPDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	dns.conn = conn
+	dns.inFlight = 0
+	dns.setDeadline(conn)
+	go dns.reader(conn)
+
+	return conn, nil
+}
+
+func (dns *TCPClient) reader(conn net.Conn) {
+	for {
+		msg, err := readTCPMessage(conn)
+		if err != nil {
+			break
+		}
+		dns.m.Lock()
+		if dns.conn == conn && dns.inFlight > 0 {
+			dns.inFlight--
+		}
+		dns.setDeadline(conn)
+		dns.m.Unlock()
+
+		dns.C <- msg
+	}
+	dns.closeConn(conn)
+}
+
+// setDeadline sets the read deadline of the connection: the idle
+// timeout if no queries are outstanding and the query timeout
+// otherwise. The client mutex must be held.
+func (dns *TCPClient) setDeadline(conn net.Conn) {
+	if dns.inFlight > 0 {
+		conn.SetReadDeadline(dns.written.Add(dns.queryTimeout))
+	} else {
+		conn.SetReadDeadline(time.Now().Add(dns.idleTimeout))
+	}
+}
+
+// sent marks the query outstanding on the connection.
+func (dns *TCPClient) sent(conn net.Conn) {
+	dns.m.Lock()
+	defer dns.m.Unlock()
+
+	if dns.conn == conn {
+		dns.inFlight++
+		dns.written = time.Now()
+		dns.setDeadline(conn)
+	}
+}
+
+func (dns *TCPClient) closeConn(conn net.Conn) {
+	dns.m.Lock()
+	if dns.conn == conn {
+		dns.conn = nil
+	}
+	dns.m.Unlock()
+	conn.Close()
+}
+
+// Close closes the TCP client.
+func (dns *TCPClient) Close() error {
+	dns.m.Lock()
+	conn := dns.conn
+	dns.conn = nil
+	dns.m.Unlock()
+
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// Write writes the DNS message to the server.
+func (dns *TCPClient) Write(data []byte) error {
+	// The connection may have been closed by the server after our
+	// last read. Retry once with a new connection.
+	var err error
+	for i := 0; i < 2; i++ {
+		var conn net.Conn
+		conn, err = dns.connect()
+		if err != nil {
+			return err
+		}
+		// Mark the query outstanding before the reader can receive
+		// its response.
+		dns.sent(conn)
+		err = writeTCPMessage(conn, data)
+		if err == nil {
+			return nil
+		}
+		dns.closeConn(conn)
+	}
+	return err
+}
+
+// writeTCPMessage writes the DNS message with the two-byte length
+// prefix.
+func writeTCPMessage(w io.Writer, data []byte) error {
+	if len(data) > 0xffff {
+		return fmt.Errorf("DNS message too long: %d", len(data))
+	}
+	buf := make([]byte, 2+len(data))
+	bo.PutUint16(buf, uint16(len(data)))
+	copy(buf[2:], data)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// readTCPMessage reads a length-prefixed DNS message.
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var hdr [2]byte
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, bo.Uint16(hdr[:]))
+	_, err = io.ReadFull(r, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
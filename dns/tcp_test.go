@@ -0,0 +1,137 @@
+//
+// tcp_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTCPClientPipeline(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func(conn net.Conn) {
+				defer conn.Close()
+				var msgs [][]byte
+				for {
+					msg, err := readTCPMessage(conn)
+					if err != nil {
+						return
+					}
+					msgs = append(msgs, msg)
+					if len(msgs) == 2 {
+						// Reply out of order.
+						writeTCPMessage(conn, msgs[1])
+						writeTCPMessage(conn, msgs[0])
+						msgs = nil
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	c := make(chan []byte)
+	client := NewTCPClient(l.Addr().String(), c)
+	defer client.Close()
+
+	q1 := []byte{0, 1, 'a'}
+	q2 := bytes.Repeat([]byte{0, 2}, 1000)
+
+	for i := 0; i < 2; i++ {
+		if err := client.Write(q1); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Write(q2); err != nil {
+			t.Fatal(err)
+		}
+		if r := <-c; !bytes.Equal(r, q2) {
+			t.Errorf("unexpected first response: %x", r)
+		}
+		if r := <-c; !bytes.Equal(r, q1) {
+			t.Errorf("unexpected second response: %x", r)
+		}
+	}
+	if accepted.Load() != 1 {
+		t.Errorf("connection not reused: %d connections", accepted.Load())
+	}
+}
+
+func TestTCPClientSlowResponse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func(conn net.Conn) {
+				defer conn.Close()
+				for {
+					msg, err := readTCPMessage(conn)
+					if err != nil {
+						return
+					}
+					// Answer after the idle timeout.
+					time.Sleep(100 * time.Millisecond)
+					writeTCPMessage(conn, msg)
+				}
+			}(conn)
+		}
+	}()
+
+	c := make(chan []byte)
+	client := NewTCPClient(l.Addr().String(), c)
+	client.idleTimeout = 50 * time.Millisecond
+	defer client.Close()
+
+	q := []byte{0, 1, 'a'}
+	if err := client.Write(q); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-c:
+		if !bytes.Equal(r, q) {
+			t.Errorf("unexpected response: %x", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("slow response lost")
+	}
+
+	// The idle connection is closed.
+	time.Sleep(100 * time.Millisecond)
+	if err := client.Write(q); err != nil {
+		t.Fatal(err)
+	}
+	<-c
+	if accepted.Load() != 2 {
+		t.Errorf("idle connection reused: %d connections", accepted.Load())
+	}
+}
@@ -0,0 +1,519 @@
+//
+// upstream.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// Upstream constants.
+const (
+	ProbeInterval = 30 * time.Second
+	ProbeTimeout  = 5 * time.Second
+	// MaxFailures defines how many consecutive failures mark an
+	// upstream down.
+	MaxFailures = 2
+)
+
+// UpstreamType defines upstream transport types.
+type UpstreamType int
+
+// Upstream transport types.
+const (
+	UpstreamUDP UpstreamType = iota
+	UpstreamDoH
+	UpstreamDoT
+)
+
+var upstreamTypes = map[UpstreamType]string{
+	UpstreamUDP: "udp",
+	UpstreamDoH: "doh",
+	UpstreamDoT: "dot",
+}
+
+func (t UpstreamType) String() string {
+	name, ok := upstreamTypes[t]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{UpstreamType %d}", t)
+}
+
+// Upstream defines an upstream DNS server.
+type Upstream struct {
+	Name     string
+	Type     UpstreamType
+	Server   string
+	DoH      *DoHClient
+	DoT      *DoTClient
+	udp      *UDPClient
+	tcp      *TCPClient
+	m        sync.Mutex
+	down     bool
+	failures int
+	srtt     time.Duration
+}
+
+// NewUDPUpstream creates a new plain DNS upstream for the server
+// address. The address port defaults to 53.
+func NewUDPUpstream(server string) *Upstream {
+	_, _, err := net.SplitHostPort(server)
+	if err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &Upstream{
+		Name:   server,
+		Type:   UpstreamUDP,
+		Server: server,
+	}
+}
+
+// NewDoHUpstream creates a new DoH upstream for the DoH client.
+func NewDoHUpstream(doh *DoHClient) *Upstream {
+	return &Upstream{
+		Name:   doh.URL,
+		Type:   UpstreamDoH,
+		Server: doh.URL,
+		DoH:    doh,
+	}
+}
+
+// NewDoTUpstream creates a new DoT upstream for the DoT client.
+func NewDoTUpstream(dot *DoTClient) *Upstream {
+	return &Upstream{
+		Name:   dot.String(),
+		Type:   UpstreamDoT,
+		Server: dot.Server,
+		DoT:    dot,
+	}
+}
+
+// ParseUpstream parses the upstream specification. The supported
+// specifications are:
+//
+//	https://server/path       DNS-over-HTTPS
+//	tls://host[:port][#name]  DNS-over-TLS with authentication name
+//	host[:port]               plain DNS
+func ParseUpstream(spec string) (*Upstream, error) {
+	switch {
+	case strings.HasPrefix(spec, "https://"):
+		doh, err := NewDoHClient(spec, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		return NewDoHUpstream(doh), nil
+
+	case strings.HasPrefix(spec, "tls://"):
+		server := strings.TrimPrefix(spec, "tls://")
+		var name string
+		idx := strings.IndexByte(server, '#')
+		if idx >= 0 {
+			name = server[idx+1:]
+			server = server[:idx]
+		}
+		dot, err := NewDoTClient(server, name)
+		if err != nil {
+			return nil, err
+		}
+		return NewDoTUpstream(dot), nil
+
+	default:
+		return NewUDPUpstream(spec), nil
+	}
+}
+
+func (u *Upstream) String() string {
+	return u.Name
+}
+
+// Encrypted tests if the upstream transport is encrypted.
+func (u *Upstream) Encrypted() bool {
+	return u.Type != UpstreamUDP
+}
+
+// Up tests if the upstream is considered healthy.
+func (u *Upstream) Up() bool {
+	u.m.Lock()
+	defer u.m.Unlock()
+	return !u.down
+}
+
+// SRTT returns the smoothed round-trip time of the upstream. The
+// function returns 0 if the round-trip time is not known.
+func (u *Upstream) SRTT() time.Duration {
+	u.m.Lock()
+	defer u.m.Unlock()
+	return u.srtt
+}
+
+// Info returns the current state of the upstream.
+func (u *Upstream) Info() *UpstreamInfo {
+	u.m.Lock()
+	defer u.m.Unlock()
+	return &UpstreamInfo{
+		Name: u.Name,
+		Type: u.Type,
+		Up:   !u.down,
+		SRTT: u.srtt,
+	}
+}
+
+// success records a successful exchange and returns true if the
+// upstream state changed.
+func (u *Upstream) success(rtt time.Duration) bool {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	// RFC 6298 style smoothing with alpha=1/8.
+	if u.srtt == 0 {
+		u.srtt = rtt
+	} else {
+		u.srtt = u.srtt - u.srtt/8 + rtt/8
+	}
+	u.failures = 0
+	changed := u.down
+	u.down = false
+	return changed
+}
+
+// failure records a failed exchange and returns true if the upstream
+// state changed.
+func (u *Upstream) failure() bool {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	u.failures++
+	if u.failures >= MaxFailures && !u.down {
+		u.down = true
+		return true
+	}
+	return false
+}
+
+func (u *Upstream) open(c chan []byte) error {
+	if u.Type != UpstreamUDP {
+		return nil
+	}
+	udp, err := NewUDPClient(u.Server, c)
+	if err != nil {
+		return err
+	}
+	u.udp = udp
+	u.tcp = NewTCPClient(u.Server, c)
+	return nil
+}
+
+func (u *Upstream) close() {
+	if u.udp != nil {
+		u.udp.Close()
+	}
+	if u.tcp != nil {
+		u.tcp.Close()
+	}
+}
+
+// send sends the query to the upstream. The response is delivered to
+// the channel c.
+func (u *Upstream) send(data []byte, c chan []byte) error {
+	var resp []byte
+	var err error
+
+	switch u.Type {
+	case UpstreamDoH:
+		resp, err = u.DoH.Do(data)
+	case UpstreamDoT:
+		resp, err = u.DoT.Do(data)
+	default:
+		return u.udp.Write(data)
+	}
+	if err != nil {
+		return err
+	}
+	c <- resp
+	return nil
+}
+
+// sendTCP resends the query to the upstream over TCP.
+func (u *Upstream) sendTCP(data []byte) error {
+	if u.tcp == nil {
+		return fmt.Errorf("upstream %s does not support TCP", u)
+	}
+	return u.tcp.Write(data)
+}
+
+// probe tests the upstream with a root NS query.
+func (u *Upstream) probe() (time.Duration, error) {
+	// Root NS query. The query is constructed manually since
+	// gopacket does not serialize root names in questions
+	// correctly.
+	query := make([]byte, 17)
+	rand.Read(query[:2])
+	bo.PutUint16(query[2:], 0x0100) // RD
+	bo.PutUint16(query[4:], 1)      // QDCOUNT
+	bo.PutUint16(query[13:], uint16(layers.DNSTypeNS))
+	bo.PutUint16(query[15:], uint16(layers.DNSClassIN))
+
+	start := time.Now()
+
+	var resp []byte
+	var err error
+	switch u.Type {
+	case UpstreamDoH:
+		resp, err = u.DoH.Do(query)
+	case UpstreamDoT:
+		resp, err = u.DoT.Do(query)
+	default:
+		resp, err = probeUDP(u.Server, query)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(resp) < 2 || bo.Uint16(resp) != bo.Uint16(query) {
+		return 0, fmt.Errorf("invalid probe response from %s", u)
+	}
+	return time.Since(start), nil
+}
+
+func probeUDP(server string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", server, ProbeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(ProbeTimeout))
+	_, err = conn.Write(query)
+	if err != nil {
+		return nil, err
+	}
+	var buf [65535]byte
+	n, err := conn.Read(buf[:])
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// UpstreamInfo describes the state of an upstream server.
+type UpstreamInfo struct {
+	Name string
+	Type UpstreamType
+	Up   bool
+	SRTT time.Duration
+}
+
+func (info *UpstreamInfo) String() string {
+	if !info.Up {
+		return fmt.Sprintf("%s \u2717", info.Name)
+	}
+	return fmt.Sprintf("%s %s", info.Name,
+		info.SRTT.Round(time.Millisecond))
+}
+
+// Strategy defines upstream selection strategies.
+type Strategy int
+
+// Upstream selection strategies.
+const (
+	StrategyOrder Strategy = iota
+	StrategyLatency
+	StrategyRoundRobin
+)
+
+var strategies = map[Strategy]string{
+	StrategyOrder:      "order",
+	StrategyLatency:    "latency",
+	StrategyRoundRobin: "roundrobin",
+}
+
+func (s Strategy) String() string {
+	name, ok := strategies[s]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{Strategy %d}", s)
+}
+
+// ParseStrategy parses the upstream selection strategy name.
+func ParseStrategy(name string) (Strategy, error) {
+	for s, n := range strategies {
+		if n == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown upstream strategy '%s'", name)
+}
+
+// Pool implements a pool of upstream servers.
+type Pool struct {
+	Strategy  Strategy
+	Upstreams []*Upstream
+	m         sync.Mutex
+	next      int
+	done      chan bool
+	notify    func(u *Upstream)
+}
+
+// NewPool creates a new upstream pool.
+func NewPool(strategy Strategy, upstreams ...*Upstream) *Pool {
+	return &Pool{
+		Strategy:  strategy,
+		Upstreams: upstreams,
+	}
+}
+
+// Encrypted tests if the pool has encrypted upstreams.
+func (pool *Pool) Encrypted() bool {
+	for _, u := range pool.Upstreams {
+		if u.Encrypted() {
+			return true
+		}
+	}
+	return false
+}
+
+// Passthrough tests if the host must be resolved without the pool's
+// DoH upstreams.
+func (pool *Pool) Passthrough(host string) bool {
+	for _, u := range pool.Upstreams {
+		if u.DoH != nil && u.DoH.Passthrough(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Select selects an upstream, excluding the upstreams that are
+// already tried. The function returns nil if all upstreams are
+// excluded.
+func (pool *Pool) Select(exclude []*Upstream) *Upstream {
+	var up, all []*Upstream
+	for _, u := range pool.Upstreams {
+		if containsUpstream(exclude, u) {
+			continue
+		}
+		all = append(all, u)
+		if u.Up() {
+			up = append(up, u)
+		}
+	}
+	// If all upstreams are down, try them anyway.
+	candidates := up
+	if len(candidates) == 0 {
+		candidates = all
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch pool.Strategy {
+	case StrategyLatency:
+		best := candidates[0]
+		for _, u := range candidates[1:] {
+			if u.SRTT() < best.SRTT() {
+				best = u
+			}
+		}
+		return best
+
+	case StrategyRoundRobin:
+		pool.m.Lock()
+		u := candidates[pool.next%len(candidates)]
+		pool.next++
+		pool.m.Unlock()
+		return u
+
+	default:
+		return candidates[0]
+	}
+}
+
+func containsUpstream(upstreams []*Upstream, u *Upstream) bool {
+	for _, el := range upstreams {
+		if el == u {
+			return true
+		}
+	}
+	return false
+}
+
+// open opens the pool's upstreams and starts health probes. The
+// responses are delivered to the channel c and the notify function
+// is called when the upstreams are probed or their state changes.
+func (pool *Pool) open(c chan []byte, notify func(u *Upstream)) error {
+	for idx, u := range pool.Upstreams {
+		err := u.open(c)
+		if err != nil {
+			for _, u := range pool.Upstreams[:idx] {
+				u.close()
+			}
+			return err
+		}
+	}
+	pool.notify = notify
+	pool.done = make(chan bool)
+	go pool.prober(pool.done)
+	return nil
+}
+
+func (pool *Pool) close() {
+	if pool.done != nil {
+		close(pool.done)
+	}
+	for _, u := range pool.Upstreams {
+		u.close()
+	}
+}
+
+func (pool *Pool) prober(done chan bool) {
+	ticker := time.NewTicker(ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			for _, u := range pool.Upstreams {
+				go pool.probe(u)
+			}
+		}
+	}
+}
+
+func (pool *Pool) probe(u *Upstream) {
+	rtt, err := u.probe()
+	if err != nil {
+		u.failure()
+	} else {
+		u.success(rtt)
+	}
+	if pool.notify != nil {
+		pool.notify(u)
+	}
+}
+
+func (pool *Pool) success(u *Upstream, rtt time.Duration) {
+	if u.success(rtt) && pool.notify != nil {
+		pool.notify(u)
+	}
+}
+
+func (pool *Pool) failure(u *Upstream) {
+	if u.failure() && pool.notify != nil {
+		pool.notify(u)
+	}
+}
@@ -0,0 +1,174 @@
+//
+// upstream_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func TestPoolSelect(t *testing.T) {
+	a := NewUDPUpstream("192.0.2.1")
+	b := NewUDPUpstream("192.0.2.2")
+	c := NewUDPUpstream("192.0.2.3")
+
+	pool := NewPool(StrategyOrder, a, b, c)
+	if u := pool.Select(nil); u != a {
+		t.Errorf("order: selected %s", u)
+	}
+	if u := pool.Select([]*Upstream{a}); u != b {
+		t.Errorf("order: selected %s after %s", u, a)
+	}
+	for i := 0; i < MaxFailures; i++ {
+		a.failure()
+	}
+	if a.Up() {
+		t.Errorf("upstream up after %d failures", MaxFailures)
+	}
+	if u := pool.Select(nil); u != b {
+		t.Errorf("order: selected %s, expected %s", u, b)
+	}
+	if u := pool.Select([]*Upstream{b, c}); u != a {
+		t.Errorf("order: selected %s instead of down upstream", u)
+	}
+	if u := pool.Select([]*Upstream{a, b, c}); u != nil {
+		t.Errorf("selected %s from empty pool", u)
+	}
+	a.success(10 * time.Millisecond)
+
+	pool.Strategy = StrategyLatency
+	b.success(5 * time.Millisecond)
+	c.success(20 * time.Millisecond)
+	if u := pool.Select(nil); u != b {
+		t.Errorf("latency: selected %s", u)
+	}
+
+	pool.Strategy = StrategyRoundRobin
+	seen := make(map[*Upstream]bool)
+	for i := 0; i < 3; i++ {
+		seen[pool.Select(nil)] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("roundrobin: selected %d upstreams", len(seen))
+	}
+}
+
+func TestProxyFailover(t *testing.T) {
+	dead := newTestServer(t, true)
+	alive := newTestServer(t, false)
+
+	proxy, out := newTestProxy(t, dead, alive)
+	proxy.Timeout = 100 * time.Millisecond
+
+	packet, query := testQuery(t, 1, "www.example.com", layers.DNSTypeA)
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := out.response(t)
+	if len(resp.Answers) != 1 {
+		t.Errorf("unexpected answers: %v", resp.Answers)
+	}
+	select {
+	case <-dead.queries:
+	default:
+		t.Errorf("query not sent to first upstream")
+	}
+}
+
+func TestProxyServFail(t *testing.T) {
+	first := newTestServer(t, true)
+	second := newTestServer(t, true)
+
+	proxy, out := newTestProxy(t, first, second)
+	proxy.Timeout = 50 * time.Millisecond
+	proxy.Retries = 3
+
+	packet, query := testQuery(t, 1, "www.example.com", layers.DNSTypeA)
+	query.Additionals = append(query.Additionals, layers.DNSResourceRecord{
+		Type:  layers.DNSTypeOPT,
+		Class: 4096,
+	})
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := out.response(t)
+	if resp.ResponseCode != layers.DNSResponseCodeServFail || resp.ID != 1 {
+		t.Errorf("unexpected response: %v %v", resp.ID, resp.ResponseCode)
+	}
+	ede, _, ok := extendedError(resp)
+	if !ok || ede != EDENoReachableAuthority {
+		t.Errorf("got extended error %v, expected %v", ede,
+			EDENoReachableAuthority)
+	}
+	if len(first.queries) != 2 || len(second.queries) != 2 {
+		t.Errorf("got %d and %d queries, expected 2 and 2",
+			len(first.queries), len(second.queries))
+	}
+	if n := proxy.inFlight(); n != 0 {
+		t.Errorf("%d pending queries", n)
+	}
+}
+
+func TestProxyDeadline(t *testing.T) {
+	dead := newTestServer(t, true)
+
+	proxy, out := newTestProxy(t, dead)
+	proxy.Timeout = time.Minute
+	proxy.Deadline = 100 * time.Millisecond
+
+	packet, query := testQuery(t, 2, "www.example.com", layers.DNSTypeA)
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := out.response(t)
+	if resp.ResponseCode != layers.DNSResponseCodeServFail {
+		t.Errorf("unexpected response code: %v", resp.ResponseCode)
+	}
+	if _, _, ok := extendedError(resp); ok {
+		t.Errorf("extended error without EDNS(0) query")
+	}
+	if len(dead.queries) != 1 {
+		t.Errorf("got %d queries, expected 1", len(dead.queries))
+	}
+}
+
+func TestProxyReap(t *testing.T) {
+	proxy, out := newTestProxy(t, newTestServer(t, true))
+
+	packet, query := testQuery(t, 3, "www.example.com", layers.DNSTypeA)
+	now := time.Now()
+	pending := &Pending{
+		timestamp: now,
+		deadline:  now.Add(time.Minute),
+		packet:    packet,
+		id:        query.ID,
+		udpSize:   MinUDPSize,
+		data:      append([]byte(nil), query.Contents...),
+		pool:      proxy.system,
+	}
+	proxy.allocate(pending)
+
+	proxy.reap(now)
+	if n := proxy.inFlight(); n != 1 {
+		t.Fatalf("%d pending queries before deadline", n)
+	}
+	proxy.reap(now.Add(2 * time.Minute))
+	if n := proxy.inFlight(); n != 0 {
+		t.Errorf("%d pending queries after deadline", n)
+	}
+	resp := out.response(t)
+	if resp.ResponseCode != layers.DNSResponseCodeServFail || resp.ID != 3 {
+		t.Errorf("unexpected response: %v %v", resp.ID, resp.ResponseCode)
+	}
+}
@@ -0,0 +1,974 @@
+//
+// validate.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// DNSSEC validator.
+//
+
+package dns
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+// Validator constants.
+const (
+	// BogusCacheTTL defines how long the validator caches the zones
+	// that failed validation.
+	BogusCacheTTL = time.Minute
+	// MaxNSEC3Iterations defines the maximum number of NSEC3 hash
+	// iterations. The denial of existence proofs with more
+	// iterations are treated as insecure (RFC 9276 section 3.2).
+	MaxNSEC3Iterations = 150
+	// ValidatorCacheSize defines the number of zones and delegations
+	// after which the validator purges its expired cache entries.
+	ValidatorCacheSize = 4096
+)
+
+// Security defines the DNSSEC security status of the responses (RFC
+// 4035 section 4.3).
+type Security int
+
+// Security statuses.
+const (
+	Insecure Security = iota
+	Secure
+	Bogus
+)
+
+var securities = map[Security]string{
+	Insecure: "insecure",
+	Secure:   "secure",
+	Bogus:    "bogus",
+}
+
+func (s Security) String() string {
+	name, ok := securities[s]
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("{Security %d}", s)
+}
+
+// combine combines the security statuses of two RRsets.
+func (s Security) combine(o Security) Security {
+	if s == Bogus || o == Bogus {
+		return Bogus
+	}
+	if s == Insecure || o == Insecure {
+		return Insecure
+	}
+	return Secure
+}
+
+// RootAnchors returns the root zone trust anchors (KSK-2017 and
+// KSK-2024).
+func RootAnchors() []*DS {
+	var result []*DS
+	for _, anchor := range []struct {
+		keyTag uint16
+		digest string
+	}{
+		{
+			keyTag: 20326,
+			digest: "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+		},
+		{
+			keyTag: 38696,
+			digest: "683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+		},
+	} {
+		digest, err := hex.DecodeString(anchor.digest)
+		if err != nil {
+			panic(err)
+		}
+		result = append(result, &DS{
+			KeyTag:     anchor.keyTag,
+			Algorithm:  AlgorithmRSASHA256,
+			DigestType: DigestSHA256,
+			Digest:     digest,
+		})
+	}
+	return result
+}
+
+// Resolver resolves the records of the name for the validator. The
+// responses must contain the DNSSEC records of the answers.
+type Resolver func(name string, qtype layers.DNSType) (*layers.DNS, error)
+
+// Validator implements a DNSSEC validator. The validator builds the
+// chains of trust from the trust anchors to the zones of the
+// responses by resolving the DNSKEY and DS records of the zones. The
+// validated keys and delegations are cached for their TTLs.
+type Validator struct {
+	// Anchors define the trust anchors of the root zone.
+	Anchors     []*DS
+	resolve     Resolver
+	now         func() time.Time
+	m           sync.Mutex
+	zones       map[string]*zone
+	delegations map[string]*delegation
+}
+
+// zone defines the security status and the validated keys of a zone.
+type zone struct {
+	name     string
+	security Security
+	keys     []*DNSKEY
+	err      error
+	expires  time.Time
+}
+
+// delegation defines the DS lookup result of a name. The cut
+// specifies if the name is a zone cut, and the ds holds the
+// supported DS records of the secure zone cuts.
+type delegation struct {
+	cut      bool
+	security Security
+	ds       []*DS
+	err      error
+	expires  time.Time
+}
+
+// NewValidator creates a new validator that resolves the DNSSEC
+// records with the resolver. The validator uses the RootAnchors.
+func NewValidator(resolve Resolver) *Validator {
+	return &Validator{
+		Anchors:     RootAnchors(),
+		resolve:     resolve,
+		now:         time.Now,
+		zones:       make(map[string]*zone),
+		delegations: make(map[string]*delegation),
+	}
+}
+
+// Validate validates the DNSSEC signatures of the response. The
+// response is secure if all its answer and authority RRsets are
+// signed by validated keys, and its negative answers have valid
+// denial of existence proofs. The function returns an error
+// describing the failure for bogus responses. The TTLs of the
+// validated records are limited to their signatures' original TTLs.
+func (v *Validator) Validate(dns *layers.DNS) (Security, error) {
+	if len(dns.Questions) != 1 {
+		return Insecure, nil
+	}
+	switch dns.ResponseCode {
+	case layers.DNSResponseCodeNoErr, layers.DNSResponseCodeNXDomain:
+	default:
+		return Insecure, nil
+	}
+	now := v.now()
+	result := Secure
+
+	var sets []*rrset
+	var den denial
+	for _, set := range newRRsets(dns.Answers, dns.Authorities) {
+		if set.authority && set.rtype == layers.DNSTypeNS &&
+			len(set.sigs) == 0 {
+			// The delegation NS records are not signed.
+			continue
+		}
+		security, err := v.verify(set, now)
+		if security == Bogus {
+			return Bogus, err
+		}
+		result = result.combine(security)
+		if security != Secure {
+			continue
+		}
+		switch set.rtype {
+		case dnsTypeNSEC, dnsTypeNSEC3:
+			den.add(set)
+		}
+		sets = append(sets, set)
+	}
+
+	// The wildcard expansions require a proof that the expanded
+	// name does not exist.
+	for _, set := range sets {
+		if set.wildcard == 0 {
+			continue
+		}
+		security := den.expanded(set.name, set.wildcard)
+		if security == Bogus {
+			return Bogus, fmt.Errorf("%s %s: no wildcard proof", set.name,
+				typeString(set.rtype))
+		}
+		result = result.combine(security)
+	}
+
+	// Follow the CNAME chain of the answers.
+	q := dns.Questions[0]
+	sname := normalizeName(string(q.Name))
+	if q.Type != layers.DNSTypeCNAME {
+		for i := 0; i < MaxCNAMEChain; i++ {
+			target, ok := cnameTarget(dns.Answers, sname)
+			if !ok {
+				break
+			}
+			sname = target
+		}
+	}
+	for _, rr := range dns.Answers {
+		if normalizeName(string(rr.Name)) == sname &&
+			(rr.Type == q.Type || q.Type == dnsTypeANY) {
+			return result, nil
+		}
+	}
+
+	// Negative answer.
+	if den.empty() {
+		z := v.lookup(sname)
+		switch z.security {
+		case Secure:
+			return Bogus, fmt.Errorf("%s %s: no denial of existence",
+				sname, typeString(q.Type))
+		case Bogus:
+			return Bogus, z.err
+		}
+		return Insecure, nil
+	}
+	var security Security
+	if dns.ResponseCode == layers.DNSResponseCodeNXDomain {
+		security = den.nxdomain(sname)
+	} else {
+		security = den.nodata(sname, q.Type)
+	}
+	if security == Bogus {
+		return Bogus, fmt.Errorf("%s %s: invalid denial of existence",
+			sname, typeString(q.Type))
+	}
+	return result.combine(security), nil
+}
+
+func cnameTarget(rrs []layers.DNSResourceRecord, name string) (string, bool) {
+	for _, rr := range rrs {
+		if rr.Type == layers.DNSTypeCNAME &&
+			normalizeName(string(rr.Name)) == name {
+			return normalizeName(string(rr.CNAME)), true
+		}
+	}
+	return "", false
+}
+
+// rrset defines a resource record set and its signatures.
+type rrset struct {
+	name      string
+	rtype     layers.DNSType
+	class     layers.DNSClass
+	authority bool
+	rrs       []*layers.DNSResourceRecord
+	sigs      []*RRSIG
+	signer    string
+	// wildcard specifies the number of labels of the wildcard
+	// closest encloser if the RRset was synthesized from a
+	// wildcard.
+	wildcard int
+}
+
+// records returns the records of the RRset.
+func (set *rrset) records() []layers.DNSResourceRecord {
+	result := make([]layers.DNSResourceRecord, len(set.rrs))
+	for i, rr := range set.rrs {
+		result[i] = *rr
+	}
+	return result
+}
+
+// ttl returns the minimum TTL of the RRset.
+func (set *rrset) ttl() uint32 {
+	var ttl uint32 = CacheMaxTTL
+	for _, rr := range set.rrs {
+		if rr.TTL < ttl {
+			ttl = rr.TTL
+		}
+	}
+	return ttl
+}
+
+// newRRsets groups the answer and authority records into RRsets and
+// assigns the signatures to their RRsets.
+func newRRsets(answers, authorities []layers.DNSResourceRecord) []*rrset {
+	var sets []*rrset
+	var sigs []*RRSIG
+
+	add := func(rrs []layers.DNSResourceRecord, authority bool) {
+		for i := range rrs {
+			rr := &rrs[i]
+			switch rr.Type {
+			case layers.DNSTypeOPT:
+				continue
+
+			case dnsTypeRRSIG:
+				sig, err := ParseRRSIG(rr)
+				if err == nil {
+					sigs = append(sigs, sig)
+				}
+				continue
+			}
+			name := normalizeName(string(rr.Name))
+			var set *rrset
+			for _, s := range sets {
+				if s.name == name && s.rtype == rr.Type && s.class == rr.Class &&
+					s.authority == authority {
+					set = s
+					break
+				}
+			}
+			if set == nil {
+				set = &rrset{
+					name:      name,
+					rtype:     rr.Type,
+					class:     rr.Class,
+					authority: authority,
+				}
+				sets = append(sets, set)
+			}
+			set.rrs = append(set.rrs, rr)
+		}
+	}
+	add(answers, false)
+	add(authorities, true)
+
+	for _, sig := range sigs {
+		for _, set := range sets {
+			if set.name == sig.Name && set.rtype == sig.TypeCovered {
+				set.sigs = append(set.sigs, sig)
+			}
+		}
+	}
+	return sets
+}
+
+// verify verifies the RRset of a response. The unsigned RRsets are
+// insecure if they belong to insecure zones.
+func (v *Validator) verify(set *rrset, now time.Time) (Security, error) {
+	if len(set.sigs) == 0 {
+		owner := set.name
+		if set.rtype == dnsTypeDS {
+			// The DS records belong to the parent zone.
+			owner = parentName(owner)
+		}
+		z := v.lookup(owner)
+		switch z.security {
+		case Secure:
+			return Bogus, fmt.Errorf("%s %s: missing signature", set.name,
+				typeString(set.rtype))
+		case Bogus:
+			return Bogus, z.err
+		}
+		return Insecure, nil
+	}
+
+	err := errors.New("no signature")
+	for _, sig := range set.sigs {
+		if !inZone(set.name, sig.SignerName) {
+			err = fmt.Errorf("signer %s outside zone", sig.SignerName)
+			continue
+		}
+		z := v.lookup(sig.SignerName)
+		switch z.security {
+		case Insecure:
+			return Insecure, nil
+		case Bogus:
+			return Bogus, z.err
+		}
+		if z.name != sig.SignerName {
+			err = fmt.Errorf("signer %s is not a zone", sig.SignerName)
+			continue
+		}
+		err = verifySignature(sig, set, z.keys, now)
+		if err != nil {
+			continue
+		}
+		set.signer = sig.SignerName
+		if sig.Wildcard() {
+			set.wildcard = int(sig.Labels)
+		}
+		ttl := sig.OrigTTL
+		if expires := sig.Expiration - uint32(now.Unix()); expires < ttl {
+			ttl = expires
+		}
+		for _, rr := range set.rrs {
+			if rr.TTL > ttl {
+				rr.TTL = ttl
+			}
+		}
+		return Secure, nil
+	}
+	return Bogus, fmt.Errorf("%s %s: %s", set.name, typeString(set.rtype), err)
+}
+
+// verifySignature verifies the signature over the RRset with the
+// keys.
+func verifySignature(sig *RRSIG, set *rrset, keys []*DNSKEY,
+	now time.Time) error {
+
+	if !sig.Valid(now) {
+		return fmt.Errorf("signature %s expired", sig)
+	}
+	err := fmt.Errorf("no key for signature %s", sig)
+	for _, key := range keys {
+		if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+			continue
+		}
+		err = sig.Verify(key, set.records())
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// verifyRRset verifies the RRset with the keys of the signer zone.
+func verifyRRset(set *rrset, signer string, keys []*DNSKEY,
+	now time.Time) error {
+
+	err := fmt.Errorf("%s %s: no signature", set.name, typeString(set.rtype))
+	for _, sig := range set.sigs {
+		if sig.SignerName != signer {
+			continue
+		}
+		err = verifySignature(sig, set, keys, now)
+		if err == nil {
+			set.signer = signer
+			return nil
+		}
+	}
+	return err
+}
+
+// lookup finds the zone of the name. The function walks the zone
+// cuts from the root zone down to the name and returns the closest
+// enclosing zone, or the insecure or bogus delegation that ends the
+// chain of trust.
+func (v *Validator) lookup(name string) *zone {
+	z := v.zone("", v.Anchors)
+
+	labels := nameLabels(name)
+	for i := len(labels) - 1; i >= 0; i-- {
+		if z.security != Secure {
+			return z
+		}
+		cut := strings.Join(labels[i:], ".")
+		d := v.delegation(cut, z)
+		switch {
+		case d.security == Bogus:
+			return &zone{
+				name:     cut,
+				security: Bogus,
+				err:      d.err,
+			}
+		case d.security == Insecure:
+			return &zone{
+				name:     cut,
+				security: Insecure,
+			}
+		case d.cut:
+			z = v.zone(cut, d.ds)
+		}
+	}
+	return z
+}
+
+// zone returns the validated keys of the zone. The ds argument
+// specifies the DS records or the trust anchors of the zone.
+func (v *Validator) zone(name string, ds []*DS) *zone {
+	now := v.now()
+
+	v.m.Lock()
+	z, ok := v.zones[name]
+	v.m.Unlock()
+	if ok && now.Before(z.expires) {
+		return z
+	}
+
+	z = v.fetchKeys(name, ds, now)
+	if z.expires.After(now) {
+		v.m.Lock()
+		if len(v.zones) >= ValidatorCacheSize {
+			for key, value := range v.zones {
+				if !now.Before(value.expires) {
+					delete(v.zones, key)
+				}
+			}
+		}
+		v.zones[name] = z
+		v.m.Unlock()
+	}
+	return z
+}
+
+func (v *Validator) fetchKeys(name string, ds []*DS, now time.Time) *zone {
+	bogus := func(err error) *zone {
+		return &zone{
+			name:     name,
+			security: Bogus,
+			err:      fmt.Errorf("%s DNSKEY: %s", name, err),
+			expires:  now.Add(BogusCacheTTL),
+		}
+	}
+
+	var supported []*DS
+	for _, d := range ds {
+		if d.Supported() {
+			supported = append(supported, d)
+		}
+	}
+	if len(supported) == 0 {
+		// The zones signed with unsupported algorithms are treated
+		// as insecure (RFC 4035 section 5.2).
+		return &zone{
+			name:     name,
+			security: Insecure,
+			expires:  now.Add(BogusCacheTTL),
+		}
+	}
+
+	resp, err := v.resolve(name, dnsTypeDNSKEY)
+	if err != nil {
+		// The resolver errors are not cached.
+		return &zone{
+			name:     name,
+			security: Bogus,
+			err:      fmt.Errorf("%s DNSKEY: %s", name, err),
+		}
+	}
+	var set *rrset
+	for _, s := range newRRsets(resp.Answers, nil) {
+		if s.name == name && s.rtype == dnsTypeDNSKEY {
+			set = s
+			break
+		}
+	}
+	if set == nil {
+		return bogus(errors.New("no keys"))
+	}
+
+	var keys, trusted []*DNSKEY
+	for _, rr := range set.rrs {
+		key, err := ParseDNSKEY(rr)
+		if err != nil || !key.Usable() {
+			continue
+		}
+		keys = append(keys, key)
+		for _, d := range supported {
+			if d.Match(key) {
+				trusted = append(trusted, key)
+				break
+			}
+		}
+	}
+	if len(trusted) == 0 {
+		return bogus(errors.New("no keys match DS records"))
+	}
+	err = verifyRRset(set, name, trusted, now)
+	if err != nil {
+		return bogus(err)
+	}
+	return &zone{
+		name:     name,
+		security: Secure,
+		keys:     keys,
+		expires:  now.Add(time.Duration(set.ttl()) * time.Second),
+	}
+}
+
+// delegation resolves the DS records of the name from its enclosing
+// secure zone.
+func (v *Validator) delegation(name string, parent *zone) *delegation {
+	now := v.now()
+
+	v.m.Lock()
+	d, ok := v.delegations[name]
+	v.m.Unlock()
+	if ok && now.Before(d.expires) {
+		return d
+	}
+
+	resp, err := v.resolve(name, dnsTypeDS)
+	if err != nil {
+		// The resolver errors are not cached.
+		return &delegation{
+			security: Bogus,
+			err:      fmt.Errorf("%s DS: %s", name, err),
+		}
+	}
+	d = checkDelegation(name, parent, resp, now)
+	if d.expires.After(now) {
+		v.m.Lock()
+		if len(v.delegations) >= ValidatorCacheSize {
+			for key, value := range v.delegations {
+				if !now.Before(value.expires) {
+					delete(v.delegations, key)
+				}
+			}
+		}
+		v.delegations[name] = d
+		v.m.Unlock()
+	}
+	return d
+}
+
+// checkDelegation checks the DS response of the name. The DS
+// records, or the proof of their non-existence, must be signed by
+// the parent zone.
+func checkDelegation(name string, parent *zone, resp *layers.DNS,
+	now time.Time) *delegation {
+
+	bogus := func(err error) *delegation {
+		return &delegation{
+			security: Bogus,
+			err:      fmt.Errorf("%s DS: %s", name, err),
+			expires:  now.Add(BogusCacheTTL),
+		}
+	}
+	switch resp.ResponseCode {
+	case layers.DNSResponseCodeNoErr, layers.DNSResponseCodeNXDomain:
+	default:
+		return bogus(errors.New(resp.ResponseCode.String()))
+	}
+
+	var ttl uint32 = CacheMaxTTL
+	var ds []*DS
+	var den denial
+	for _, set := range newRRsets(resp.Answers, resp.Authorities) {
+		if set.authority && set.rtype == layers.DNSTypeNS &&
+			len(set.sigs) == 0 {
+			continue
+		}
+		err := verifyRRset(set, parent.name, parent.keys, now)
+		if err != nil {
+			return bogus(err)
+		}
+		if set.ttl() < ttl {
+			ttl = set.ttl()
+		}
+		switch set.rtype {
+		case dnsTypeDS:
+			if set.name != name {
+				continue
+			}
+			for _, rr := range set.rrs {
+				d, err := ParseDS(rr)
+				if err != nil {
+					return bogus(err)
+				}
+				ds = append(ds, d)
+			}
+
+		case layers.DNSTypeSOA:
+			for _, rr := range set.rrs {
+				if rr.SOA.Minimum < ttl {
+					ttl = rr.SOA.Minimum
+				}
+			}
+
+		case dnsTypeNSEC, dnsTypeNSEC3:
+			den.add(set)
+		}
+	}
+	expires := now.Add(time.Duration(ttl) * time.Second)
+
+	if len(ds) > 0 {
+		var supported []*DS
+		for _, d := range ds {
+			if d.Supported() {
+				supported = append(supported, d)
+			}
+		}
+		security := Secure
+		if len(supported) == 0 {
+			security = Insecure
+		}
+		return &delegation{
+			cut:      true,
+			security: security,
+			ds:       supported,
+			expires:  expires,
+		}
+	}
+	cut, security := den.delegation(name)
+	if security == Bogus {
+		return bogus(errors.New("no DS records or denial of existence"))
+	}
+	return &delegation{
+		cut:      cut,
+		security: security,
+		expires:  expires,
+	}
+}
+
+// denial holds the validated NSEC and NSEC3 records of a response
+// for proving the non-existence of names and records (RFC 4035
+// section 5.4, RFC 5155 section 8).
+type denial struct {
+	nsec  []*nsec
+	nsec3 []*nsec3
+	// insecure specifies if the response had NSEC3 records with
+	// too many iterations.
+	insecure bool
+}
+
+func (d *denial) add(set *rrset) {
+	for _, rr := range set.rrs {
+		switch rr.Type {
+		case dnsTypeNSEC:
+			n, err := parseNSEC(rr, set.signer)
+			if err == nil {
+				d.nsec = append(d.nsec, n)
+			}
+
+		case dnsTypeNSEC3:
+			n, err := parseNSEC3(rr, set.signer)
+			if err != nil {
+				continue
+			}
+			if n.iterations > MaxNSEC3Iterations {
+				d.insecure = true
+				continue
+			}
+			d.nsec3 = append(d.nsec3, n)
+		}
+	}
+}
+
+func (d *denial) empty() bool {
+	return len(d.nsec) == 0 && len(d.nsec3) == 0 && !d.insecure
+}
+
+// fail returns the security status of a failed proof.
+func (d *denial) fail() Security {
+	if d.insecure {
+		return Insecure
+	}
+	return Bogus
+}
+
+// nxdomain proves that the name does not exist.
+func (d *denial) nxdomain(name string) Security {
+	for _, n := range d.nsec {
+		if !n.covers(name) {
+			continue
+		}
+		// The closest encloser is the longest common ancestor of
+		// the name and the NSEC names.
+		ce := commonAncestor(name, n.name)
+		if next := commonAncestor(name, n.next); len(next) > len(ce) {
+			ce = next
+		}
+		for _, w := range d.nsec {
+			if w.covers(wildcardName(ce)) {
+				return Secure
+			}
+		}
+	}
+
+	ce, optOut, ok := d.closestEncloser(name)
+	if ok && ce != name {
+		for _, n := range d.nsec3 {
+			if n.covers(wildcardName(ce)) {
+				if optOut {
+					return Insecure
+				}
+				return Secure
+			}
+		}
+	}
+	return d.fail()
+}
+
+// nodata proves that the name does not have records of the type.
+func (d *denial) nodata(name string, qtype layers.DNSType) Security {
+	for _, n := range d.nsec {
+		if n.name == name {
+			if noData(n.types, name, qtype) {
+				return Secure
+			}
+			return Bogus
+		}
+		if n.covers(name) && n.next != name && inZone(n.next, name) {
+			// Empty non-terminal.
+			return Secure
+		}
+	}
+	for _, n := range d.nsec {
+		if !n.covers(name) {
+			continue
+		}
+		ce := commonAncestor(name, n.name)
+		if next := commonAncestor(name, n.next); len(next) > len(ce) {
+			ce = next
+		}
+		for _, w := range d.nsec {
+			if w.name == wildcardName(ce) && noData(w.types, name, qtype) {
+				return Secure
+			}
+		}
+	}
+
+	for _, n := range d.nsec3 {
+		if n.matches(name) {
+			if noData(n.types, name, qtype) {
+				return Secure
+			}
+			return Bogus
+		}
+	}
+	ce, optOut, ok := d.closestEncloser(name)
+	if ok && ce != name {
+		if qtype == dnsTypeDS && optOut {
+			return Insecure
+		}
+		for _, n := range d.nsec3 {
+			if n.matches(wildcardName(ce)) && noData(n.types, name, qtype) {
+				return Secure
+			}
+		}
+	}
+	return d.fail()
+}
+
+// noData tests if the type bitmap of the name proves that the name
+// does not have records of the type.
+func noData(types []layers.DNSType, name string, qtype layers.DNSType) bool {
+	if hasType(types, qtype) || hasType(types, layers.DNSTypeCNAME) {
+		return false
+	}
+	if qtype == dnsTypeDS {
+		// The DS records are at the parent side of the zone cut.
+		return len(name) == 0 || !hasType(types, layers.DNSTypeSOA)
+	}
+	// The parent side of a delegation can't prove the
+	// non-existence of the child zone's records.
+	return !hasType(types, layers.DNSTypeNS) ||
+		hasType(types, layers.DNSTypeSOA)
+}
+
+// delegation proves that the name does not have DS records. The
+// function returns true if the name is an insecure zone cut, and
+// false if the name is not a zone cut.
+func (d *denial) delegation(name string) (bool, Security) {
+	for _, n := range d.nsec {
+		if n.name == name {
+			return nsecDelegation(n.types)
+		}
+		if n.covers(name) {
+			// The name does not exist.
+			return false, Secure
+		}
+	}
+	for _, n := range d.nsec3 {
+		if n.matches(name) {
+			return nsecDelegation(n.types)
+		}
+	}
+	ce, optOut, ok := d.closestEncloser(name)
+	if ok && ce != name {
+		if optOut {
+			// The name can be an unsigned delegation.
+			return true, Insecure
+		}
+		return false, Secure
+	}
+	return false, d.fail()
+}
+
+func nsecDelegation(types []layers.DNSType) (bool, Security) {
+	if hasType(types, dnsTypeDS) || hasType(types, layers.DNSTypeSOA) {
+		return false, Bogus
+	}
+	if hasType(types, layers.DNSTypeNS) {
+		return true, Insecure
+	}
+	return false, Secure
+}
+
+// expanded proves that the name of a wildcard expansion does not
+// exist. The labels argument specifies the number of labels in the
+// wildcard's closest encloser.
+func (d *denial) expanded(name string, labels int) Security {
+	for _, n := range d.nsec {
+		if n.covers(name) {
+			return Secure
+		}
+	}
+	// The NSEC3 proof covers the next closer name.
+	l := nameLabels(name)
+	if labels+1 > len(l) {
+		return Bogus
+	}
+	nc := strings.Join(l[len(l)-labels-1:], ".")
+	for _, n := range d.nsec3 {
+		if n.covers(nc) {
+			if n.optOut() {
+				return Insecure
+			}
+			return Secure
+		}
+	}
+	return d.fail()
+}
+
+// closestEncloser finds the closest encloser of the name with the
+// NSEC3 closest encloser proof (RFC 5155 section 8.3). The function
+// returns the closest encloser and the opt-out flag of the NSEC3
+// record covering the next closer name. If the name exists, it is
+// its own closest encloser.
+func (d *denial) closestEncloser(name string) (string, bool, bool) {
+	nc := name
+	for ce := name; ; ce = parentName(ce) {
+		for _, n := range d.nsec3 {
+			if !n.matches(ce) {
+				continue
+			}
+			if ce == name {
+				return ce, false, true
+			}
+			if hasType(n.types, layers.DNSTypeNS) &&
+				!hasType(n.types, layers.DNSTypeSOA) {
+				// The name is below a delegation point.
+				return "", false, false
+			}
+			for _, c := range d.nsec3 {
+				if c.covers(nc) {
+					return ce, c.optOut(), true
+				}
+			}
+			return "", false, false
+		}
+		if len(ce) == 0 {
+			return "", false, false
+		}
+		nc = ce
+	}
+}
+
+// commonAncestor returns the longest common ancestor of the names.
+func commonAncestor(a, b string) string {
+	la := nameLabels(a)
+	lb := nameLabels(b)
+	var n int
+	for n < len(la) && n < len(lb) &&
+		la[len(la)-1-n] == lb[len(lb)-1-n] {
+		n++
+	}
+	return strings.Join(la[len(la)-n:], ".")
+}
+
+func wildcardName(ce string) string {
+	if len(ce) == 0 {
+		return "*"
+	}
+	return "*." + ce
+}
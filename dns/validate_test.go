@@ -0,0 +1,483 @@
+//
+// validate_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// testFixture defines a hierarchy of signed fixture zones:
+//
+//	.                RSASHA256 with KSK and ZSK
+//	test             ECDSAP384SHA384 with NSEC3
+//	example.test     ECDSAP256SHA256 with KSK, ZSK, and NSEC
+//	ed.test          ED25519
+//	insecure.test    unsigned delegation
+//	optout.test      unsigned delegation covered by NSEC3 opt-out
+type testFixture struct {
+	anchors   []*DS
+	example   *testZone
+	m         sync.Mutex
+	responses map[string]*layers.DNS
+	queries   int
+}
+
+func newTestFixture(t *testing.T) *testFixture {
+	root := newTestZone(t, "", AlgorithmRSASHA256, true)
+	tld := newTestZone(t, "test", AlgorithmECDSAP384SHA384, false)
+	example := newTestZone(t, "example.test", AlgorithmECDSAP256SHA256, true)
+	ed := newTestZone(t, "ed.test", AlgorithmEd25519, false)
+
+	ds, err := root.ksk.dnskey.DS(DigestSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &testFixture{
+		anchors:   []*DS{ds},
+		example:   example,
+		responses: make(map[string]*layers.DNS),
+	}
+
+	// Keys and delegations.
+	for _, z := range []*testZone{root, tld, example, ed} {
+		f.add(z.keys(t))
+	}
+	f.add(testResponse("test", dnsTypeDS, layers.DNSResponseCodeNoErr,
+		root.sign(t, tld.ds(t)), nil))
+	for _, z := range []*testZone{example, ed} {
+		f.add(testResponse(z.name, dnsTypeDS, layers.DNSResponseCodeNoErr,
+			tld.sign(t, z.ds(t)), nil))
+	}
+
+	hash, _, next := testHash("insecure.test")
+	f.add(testResponse("insecure.test", dnsTypeDS,
+		layers.DNSResponseCodeNoErr, nil,
+		append(tld.soa(t),
+			tld.nsec3(t, hash, next, 0, layers.DNSTypeNS)...)))
+
+	tldHash, _, tldNext := testHash("test")
+	_, prev, next := testHash("optout.test")
+	authorities := tld.soa(t)
+	authorities = append(authorities, tld.nsec3(t, tldHash, tldNext, 0,
+		layers.DNSTypeNS, layers.DNSTypeSOA, dnsTypeRRSIG, dnsTypeDNSKEY)...)
+	authorities = append(authorities,
+		tld.nsec3(t, prev, next, nsec3OptOut, layers.DNSTypeNS)...)
+	f.add(testResponse("optout.test", dnsTypeDS, layers.DNSResponseCodeNoErr,
+		nil, authorities))
+
+	// Data.
+	f.add(testResponse("www.example.test", layers.DNSTypeA,
+		layers.DNSResponseCodeNoErr,
+		example.sign(t, testA("www.example.test", "192.0.2.1")), nil))
+
+	answers := example.sign(t, layers.DNSResourceRecord{
+		Name:  []byte("alias.example.test"),
+		Type:  layers.DNSTypeCNAME,
+		Class: layers.DNSClassIN,
+		TTL:   3600,
+		CNAME: []byte("www.ed.test"),
+	})
+	answers = append(answers, ed.sign(t, testA("www.ed.test", "192.0.2.2"))...)
+	f.add(testResponse("alias.example.test", layers.DNSTypeA,
+		layers.DNSResponseCodeNoErr, answers, nil))
+
+	answers = example.sign(t, testA("*.wild.example.test", "192.0.2.3"))
+	for i := range answers {
+		answers[i].Name = []byte("host.wild.example.test")
+	}
+	f.add(testResponse("host.wild.example.test", layers.DNSTypeA,
+		layers.DNSResponseCodeNoErr, answers,
+		example.nsec(t, "*.wild.example.test", "www.example.test",
+			layers.DNSTypeA, dnsTypeRRSIG, dnsTypeNSEC)))
+
+	authorities = example.soa(t)
+	authorities = append(authorities,
+		example.nsec(t, "alias.example.test", "*.wild.example.test",
+			layers.DNSTypeCNAME, dnsTypeRRSIG, dnsTypeNSEC)...)
+	authorities = append(authorities,
+		example.nsec(t, "example.test", "alias.example.test",
+			layers.DNSTypeNS, layers.DNSTypeSOA, dnsTypeRRSIG, dnsTypeNSEC,
+			dnsTypeDNSKEY)...)
+	f.add(testResponse("nx.example.test", layers.DNSTypeA,
+		layers.DNSResponseCodeNXDomain, nil, authorities))
+
+	f.add(testResponse("www.example.test", layers.DNSTypeAAAA,
+		layers.DNSResponseCodeNoErr, nil,
+		append(example.soa(t),
+			example.nsec(t, "www.example.test", "example.test",
+				layers.DNSTypeA, dnsTypeRRSIG, dnsTypeNSEC)...)))
+
+	for _, name := range []string{"host.insecure.test", "host.optout.test"} {
+		f.add(testResponse(name, layers.DNSTypeA, layers.DNSResponseCodeNoErr,
+			[]layers.DNSResourceRecord{testA(name, "192.0.2.4")}, nil))
+	}
+
+	// The bogus record is modified after signing.
+	answers = example.sign(t, testA("bogus.example.test", "192.0.2.5"))
+	answers[0].IP = net.IPv4(192, 0, 2, 6)
+	f.add(testResponse("bogus.example.test", layers.DNSTypeA,
+		layers.DNSResponseCodeNoErr, answers, nil))
+
+	return f
+}
+
+func testFixtureKey(name string, qtype layers.DNSType) string {
+	return fmt.Sprintf("%s/%d", normalizeName(name), qtype)
+}
+
+func (f *testFixture) add(resp *layers.DNS) {
+	q := resp.Questions[0]
+	f.responses[testFixtureKey(string(q.Name), q.Type)] = resp
+}
+
+// resolve implements the Resolver for the fixture zones. The
+// responses are encoded and decoded so that the validator sees them
+// as they were received from the network.
+func (f *testFixture) resolve(name string, qtype layers.DNSType) (
+	*layers.DNS, error) {
+
+	f.m.Lock()
+	f.queries++
+	resp, ok := f.responses[testFixtureKey(name, qtype)]
+	f.m.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%s %s: no fixture", name, typeString(qtype))
+	}
+	packet := gopacket.NewPacket(packTestMessage(resp), layers.LayerTypeDNS,
+		gopacket.Default)
+	layer := packet.Layer(layers.LayerTypeDNS)
+	if layer == nil {
+		return nil, fmt.Errorf("%s %s: non-DNS response", name,
+			typeString(qtype))
+	}
+	return layer.(*layers.DNS), nil
+}
+
+// handler implements the testServer handler for the fixture zones.
+func (f *testFixture) handler(q *layers.DNS) []byte {
+	if len(q.Questions) != 1 {
+		return nil
+	}
+	f.m.Lock()
+	resp, ok := f.responses[testFixtureKey(string(q.Questions[0].Name),
+		q.Questions[0].Type)]
+	f.m.Unlock()
+	if !ok {
+		return nil
+	}
+	msg := *resp
+	msg.ID = q.ID
+	return packTestMessage(&msg)
+}
+
+func (f *testFixture) validator() *Validator {
+	v := NewValidator(f.resolve)
+	v.Anchors = f.anchors
+	return v
+}
+
+func TestValidator(t *testing.T) {
+	f := newTestFixture(t)
+	v := f.validator()
+
+	tests := []struct {
+		name     string
+		qtype    layers.DNSType
+		modify   func(resp *layers.DNS)
+		security Security
+	}{
+		{
+			name:     "www.example.test",
+			qtype:    layers.DNSTypeA,
+			security: Secure,
+		},
+		{
+			name:     "alias.example.test",
+			qtype:    layers.DNSTypeA,
+			security: Secure,
+		},
+		{
+			name:     "host.wild.example.test",
+			qtype:    layers.DNSTypeA,
+			security: Secure,
+		},
+		{
+			name:     "nx.example.test",
+			qtype:    layers.DNSTypeA,
+			security: Secure,
+		},
+		{
+			name:     "www.example.test",
+			qtype:    layers.DNSTypeAAAA,
+			security: Secure,
+		},
+		{
+			name:     "host.insecure.test",
+			qtype:    layers.DNSTypeA,
+			security: Insecure,
+		},
+		{
+			name:     "host.optout.test",
+			qtype:    layers.DNSTypeA,
+			security: Insecure,
+		},
+		{
+			name:     "bogus.example.test",
+			qtype:    layers.DNSTypeA,
+			security: Bogus,
+		},
+		{
+			name:  "www.example.test",
+			qtype: layers.DNSTypeA,
+			modify: func(resp *layers.DNS) {
+				resp.Answers[0].IP = net.IPv4(192, 0, 2, 66)
+			},
+			security: Bogus,
+		},
+		{
+			name:  "www.example.test",
+			qtype: layers.DNSTypeA,
+			modify: func(resp *layers.DNS) {
+				// Strip signatures.
+				resp.Answers = resp.Answers[:1]
+			},
+			security: Bogus,
+		},
+		{
+			name:  "host.wild.example.test",
+			qtype: layers.DNSTypeA,
+			modify: func(resp *layers.DNS) {
+				// Remove the wildcard proof.
+				resp.Authorities = nil
+			},
+			security: Bogus,
+		},
+		{
+			name:  "nx.example.test",
+			qtype: layers.DNSTypeA,
+			modify: func(resp *layers.DNS) {
+				// Remove the NSEC covering the wildcard.
+				resp.Authorities = resp.Authorities[:4]
+			},
+			security: Bogus,
+		},
+		{
+			name:  "www.example.test",
+			qtype: layers.DNSTypeAAAA,
+			modify: func(resp *layers.DNS) {
+				// Replace the NODATA response with NXDOMAIN.
+				resp.ResponseCode = layers.DNSResponseCodeNXDomain
+			},
+			security: Bogus,
+		},
+		{
+			name:  "alias.example.test",
+			qtype: layers.DNSTypeA,
+			modify: func(resp *layers.DNS) {
+				// Forge the CNAME target.
+				resp.Answers = resp.Answers[:2]
+				resp.Answers = append(resp.Answers,
+					testA("www.ed.test", "192.0.2.66"))
+			},
+			security: Bogus,
+		},
+	}
+	for _, test := range tests {
+		resp, err := f.resolve(test.name, test.qtype)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.modify != nil {
+			test.modify(resp)
+		}
+		security, err := v.Validate(resp)
+		if security != test.security {
+			t.Errorf("%s %s: got %s (%v), expected %s", test.name,
+				typeString(test.qtype), security, err, test.security)
+		}
+		if security == Bogus && err == nil {
+			t.Errorf("%s %s: no error for bogus response", test.name,
+				typeString(test.qtype))
+		}
+	}
+}
+
+func TestValidatorCache(t *testing.T) {
+	f := newTestFixture(t)
+	v := f.validator()
+
+	for i := 0; i < 2; i++ {
+		resp, err := f.resolve("www.example.test", layers.DNSTypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		security, err := v.Validate(resp)
+		if security != Secure {
+			t.Fatalf("got %s (%v), expected %s", security, err, Secure)
+		}
+	}
+	// The data query, DNSKEYs of 3 zones, and DS records of 2
+	// zones. The second round resolves only the data.
+	if f.queries != 2+3+2 {
+		t.Errorf("got %d queries, expected %d", f.queries, 2+3+2)
+	}
+}
+
+func TestValidatorBogusChain(t *testing.T) {
+	f := newTestFixture(t)
+
+	// Expired signatures.
+	v := f.validator()
+	v.now = func() time.Time {
+		return time.Now().Add(2 * time.Hour)
+	}
+	resp, err := f.resolve("www.example.test", layers.DNSTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	security, err := v.Validate(resp)
+	if security != Bogus {
+		t.Errorf("expired: got %s, expected %s", security, Bogus)
+	}
+
+	// Unknown trust anchor.
+	v = f.validator()
+	ds, err := f.example.ksk.dnskey.DS(DigestSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Anchors = []*DS{ds}
+	security, _ = v.Validate(resp)
+	if security != Bogus {
+		t.Errorf("anchor: got %s, expected %s", security, Bogus)
+	}
+
+	// Unsupported trust anchor algorithm.
+	v = f.validator()
+	v.Anchors = []*DS{
+		{
+			KeyTag:     f.anchors[0].KeyTag,
+			Algorithm:  5,
+			DigestType: DigestSHA256,
+			Digest:     f.anchors[0].Digest,
+		},
+	}
+	security, _ = v.Validate(resp)
+	if security != Insecure {
+		t.Errorf("algorithm: got %s, expected %s", security, Insecure)
+	}
+}
+
+func TestProxyDNSSEC(t *testing.T) {
+	f := newTestFixture(t)
+	server := startTestServer(t, &testServer{
+		handler: f.handler,
+	})
+	proxy, out := newTestProxy(t, server)
+	proxy.Validator = NewValidator(proxy.Resolve)
+	proxy.Validator.Anchors = f.anchors
+
+	tests := []struct {
+		name    string
+		rcode   layers.DNSResponseCode
+		answers int
+		ad      bool
+	}{
+		{
+			name:    "www.example.test",
+			rcode:   layers.DNSResponseCodeNoErr,
+			answers: 1,
+			ad:      true,
+		},
+		{
+			name:    "host.insecure.test",
+			rcode:   layers.DNSResponseCodeNoErr,
+			answers: 1,
+		},
+		{
+			name:  "bogus.example.test",
+			rcode: layers.DNSResponseCodeServFail,
+		},
+	}
+	for idx, test := range tests {
+		packet, query := testQuery(t, uint16(idx), test.name, layers.DNSTypeA)
+		err := proxy.Query(packet, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := out.response(t)
+		if resp.ResponseCode != test.rcode {
+			t.Errorf("%s: got %s, expected %s", test.name, resp.ResponseCode,
+				test.rcode)
+		}
+		if len(resp.Answers) != test.answers {
+			t.Errorf("%s: unexpected answers: %v", test.name, resp.Answers)
+		}
+		if ad := resp.Z&dnsFlagAD != 0; ad != test.ad {
+			t.Errorf("%s: got AD %v, expected %v", test.name, ad, test.ad)
+		}
+	}
+
+	// The proxy sets the DO bit to the queries.
+	for len(server.queries) > 0 {
+		q := <-server.queries
+		var do bool
+		for _, rr := range q.Additionals {
+			if rr.Type == layers.DNSTypeOPT && rr.TTL&ednsDO != 0 {
+				do = true
+			}
+		}
+		if !do {
+			t.Errorf("query %s without DO bit", q.Questions[0].Name)
+		}
+	}
+}
+
+func TestProxyDNSSECCheckingDisabled(t *testing.T) {
+	f := newTestFixture(t)
+	server := startTestServer(t, &testServer{
+		handler: f.handler,
+	})
+	proxy, out := newTestProxy(t, server)
+	proxy.Validator = NewValidator(proxy.Resolve)
+	proxy.Validator.Anchors = f.anchors
+	proxy.Cache = NewCache(10)
+
+	// The CD query gets the unvalidated response.
+	packet, query := testQuery(t, 1, "bogus.example.test", layers.DNSTypeA)
+	query.Z |= dnsFlagCD
+	err := proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := out.response(t)
+	if resp.ResponseCode != layers.DNSResponseCodeNoErr {
+		t.Errorf("CD query: got %s, expected %s", resp.ResponseCode,
+			layers.DNSResponseCodeNoErr)
+	}
+
+	// The unvalidated response is not served from the cache.
+	packet, query = testQuery(t, 2, "bogus.example.test", layers.DNSTypeA)
+	err = proxy.Query(packet, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = out.response(t)
+	if resp.ResponseCode != layers.DNSResponseCodeServFail {
+		t.Errorf("got %s, expected %s", resp.ResponseCode,
+			layers.DNSResponseCodeServFail)
+	}
+}
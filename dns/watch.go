@@ -0,0 +1,275 @@
+//
+// watch.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Blacklist file watcher.
+//
+
+package dns
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ListWatchInterval defines how often the ListWatcher checks the
+// blacklist files for changes.
+const ListWatchInterval = 5 * time.Second
+
+// ListFile defines a blacklist file.
+type ListFile struct {
+	Name string
+	// Format specifies the file format. The FormatAuto files are
+	// opened as indices if they are index files, see IsIndex.
+	Format ListFormat
+	// Allow specifies if the file is an allowlist.
+	Allow bool
+}
+
+// ListWatcher loads the blacklist files and subscriptions to the
+// proxy and reloads them when the files or subscriptions change.
+type ListWatcher struct {
+	Files         []ListFile
+	Subscriptions []*Subscription
+	proxy         *Proxy
+	m             sync.Mutex
+	stats         map[string]os.FileInfo
+	fileRules     []Rule
+	indices       []*Index
+	done          chan bool
+	closed        bool
+}
+
+// NewListWatcher creates a new watcher for the blacklist files.
+func NewListWatcher(proxy *Proxy, files ...ListFile) *ListWatcher {
+	return &ListWatcher{
+		Files: files,
+		proxy: proxy,
+		stats: make(map[string]os.FileInfo),
+		done:  make(chan bool),
+	}
+}
+
+// Subscribe adds the blacklist subscription to the watcher. The
+// subscription's server is resolved with the proxy's system DNS
+// servers so that the fetches do not depend on the proxy's own
+// upstreams and blacklists.
+func (w *ListWatcher) Subscribe(sub *Subscription) error {
+	err := w.proxy.AddPassthrough(sub.URL)
+	if err != nil {
+		return err
+	}
+	w.m.Lock()
+	w.Subscriptions = append(w.Subscriptions, sub)
+	w.m.Unlock()
+	return nil
+}
+
+// Reload reads the blacklist files and sets the rules to the
+// proxy. If any of the files can't be read, the proxy keeps its
+// current rules and the function returns the error. The unparseable
+// entries of the files are skipped and they are reported with
+// *ListError errors after the new rules are set.
+func (w *ListWatcher) Reload() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	var rules []Rule
+	var indices []*Index
+	var listErrs []error
+	stats := make(map[string]os.FileInfo)
+
+	for _, file := range w.Files {
+		fi, err := os.Stat(file.Name)
+		if err != nil {
+			return err
+		}
+		stats[file.Name] = fi
+
+		isIndex := file.Format == FormatIndex
+		if file.Format == FormatAuto {
+			isIndex, err = IsIndex(file.Name)
+			if err != nil {
+				return err
+			}
+		}
+		if isIndex {
+			// The replaced indices are unmapped when the proxy
+			// stops using them.
+			idx, err := OpenIndex(file.Name)
+			if err != nil {
+				return err
+			}
+			indices = append(indices, idx)
+			continue
+		}
+
+		r, err := readRules(file.Name, file.Format, file.Allow)
+		if err != nil {
+			var listErr *ListError
+			if !errors.As(err, &listErr) {
+				return err
+			}
+			listErrs = append(listErrs, err)
+		}
+		rules = append(rules, r...)
+	}
+	w.stats = stats
+	w.fileRules = rules
+	w.indices = indices
+	w.apply()
+
+	return errors.Join(listErrs...)
+}
+
+// apply sets the file, index, and subscription rules to the
+// proxy. The watcher must be locked.
+func (w *ListWatcher) apply() {
+	rules := w.fileRules
+	for _, sub := range w.Subscriptions {
+		rules = append(rules[:len(rules):len(rules)], sub.Rules()...)
+	}
+	if len(w.indices) == 0 {
+		w.proxy.SetBlacklist(rules)
+		return
+	}
+	matchers := Matchers{NewMatcher(rules)}
+	for _, idx := range w.indices {
+		matchers = append(matchers, idx)
+	}
+	w.proxy.SetMatcher(matchers)
+}
+
+// Watch starts watching the blacklist files. The files are reloaded
+// when their modification time or size change.
+func (w *ListWatcher) Watch(interval time.Duration) {
+	go w.watcher(interval)
+}
+
+// Refresh starts refreshing the subscriptions. The subscriptions
+// are fetched when they are older than the interval, and the failed
+// fetches are retried after RefreshRetryInterval.
+func (w *ListWatcher) Refresh(interval time.Duration) {
+	go w.refresher(interval)
+}
+
+// Close stops watching the blacklist files and refreshing the
+// subscriptions.
+func (w *ListWatcher) Close() {
+	w.m.Lock()
+	if !w.closed {
+		close(w.done)
+		w.closed = true
+	}
+	w.m.Unlock()
+}
+
+func (w *ListWatcher) watcher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case <-ticker.C:
+			if !w.modified() {
+				continue
+			}
+			err := w.Reload()
+			if err != nil {
+				log.Printf("blacklist reload: %s", err)
+			}
+		}
+	}
+}
+
+// modified tests if any of the blacklist files have changed since
+// the last reload.
+func (w *ListWatcher) modified() bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	for _, file := range w.Files {
+		fi, err := os.Stat(file.Name)
+		if err != nil {
+			// Editors can replace files by removing and renaming
+			// them. Wait until the file exists again.
+			continue
+		}
+		old, ok := w.stats[file.Name]
+		if !ok || !fi.ModTime().Equal(old.ModTime()) ||
+			fi.Size() != old.Size() {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *ListWatcher) refresher(interval time.Duration) {
+	retry := RefreshRetryInterval
+	if retry > interval {
+		retry = interval
+	}
+
+	w.m.Lock()
+	subs := w.Subscriptions
+	w.m.Unlock()
+
+	next := make(map[*Subscription]time.Time)
+	for _, sub := range subs {
+		next[sub] = sub.Fetched().Add(interval)
+	}
+
+	for {
+		var wakeup time.Time
+		for _, t := range next {
+			if wakeup.IsZero() || t.Before(wakeup) {
+				wakeup = t
+			}
+		}
+		if wakeup.IsZero() {
+			return
+		}
+		timer := time.NewTimer(time.Until(wakeup))
+		select {
+		case <-w.done:
+			timer.Stop()
+			return
+
+		case <-timer.C:
+		}
+
+		var updated bool
+		now := time.Now()
+		for _, sub := range subs {
+			if next[sub].After(now) {
+				continue
+			}
+			ok, err := sub.Fetch()
+			if ok {
+				updated = true
+			}
+			if err != nil {
+				log.Printf("blacklist %s: %s", sub, err)
+				if !ok {
+					next[sub] = now.Add(retry)
+					continue
+				}
+			}
+			next[sub] = now.Add(interval)
+		}
+		if updated {
+			w.m.Lock()
+			w.apply()
+			w.m.Unlock()
+		}
+	}
+}
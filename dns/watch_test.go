@@ -0,0 +1,91 @@
+//
+// watch_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package dns
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListWatcher(t *testing.T) {
+	proxy, _ := newTestProxy(t)
+	events := make(chan Event, 10)
+	proxy.Events = events
+
+	dir := t.TempDir()
+	bl := filepath.Join(dir, "test.bl")
+	al := filepath.Join(dir, "test.al")
+	write := func(name, data string) {
+		// Replace the file atomically so that the watcher does not
+		// see partially written files.
+		tmp := name + ".tmp"
+		if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(bl, "*.ads.example.com\n")
+	write(al, "www.ads.example.com\n")
+
+	watcher := NewListWatcher(proxy,
+		ListFile{
+			Name: bl,
+		},
+		ListFile{
+			Name:   al,
+			Format: FormatGlob,
+			Allow:  true,
+		})
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	expectRules := func(count int) {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.Type != EventConfig || event.Blacklist == nil ||
+				event.Blacklist.Rules != count {
+				t.Errorf("unexpected event: %v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no reload event")
+		}
+	}
+	expectRules(2)
+
+	// Modified files are reloaded.
+	watcher.Watch(10 * time.Millisecond)
+	defer watcher.Close()
+	write(bl, "*.ads.example.com\n*.tracker.example.com\n")
+	expectRules(3)
+
+	// Unparseable entries are skipped.
+	write(bl, "0.0.0.0 ads.example.com\n0.0.0.0 bad!name\n")
+	expectRules(2)
+
+	// Unreadable files keep the current rules.
+	watcher.Close()
+	os.Remove(bl)
+	err := watcher.Reload()
+	var listErr *ListError
+	if err == nil || errors.As(err, &listErr) {
+		t.Errorf("unexpected reload error: %v", err)
+	}
+	proxy.m.Lock()
+	count := proxy.blacklist.Len()
+	proxy.m.Unlock()
+	if count != 2 {
+		t.Errorf("blacklist has %d rules, expected 2", count)
+	}
+}
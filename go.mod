@@ -9,7 +9,7 @@ require (
 	github.com/googleapis/gax-go/v2 v2.12.4 // indirect
 	github.com/markkurossi/cloudsdk v0.0.0-20240430075725-c2a4aacb97ac
 	github.com/markkurossi/go-libs v0.0.0-20240430075615-ce4a6e9da831 // indirect
-	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/net v0.25.0
 	golang.org/x/oauth2 v0.20.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/text v0.15.0 // indirect
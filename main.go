@@ -11,14 +11,18 @@ package main
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path"
+	"strings"
+	"syscall"
 
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/layers"
@@ -27,6 +31,7 @@ import (
 	"github.com/markkurossi/vpn/dns"
 	"github.com/markkurossi/vpn/ifmon"
 	"github.com/markkurossi/vpn/ip"
+	"github.com/markkurossi/vpn/metrics"
 	"github.com/markkurossi/vpn/tun"
 )
 
@@ -46,12 +51,93 @@ var (
 
 func main() {
 	bl := flag.String("blacklist", "", "DNS blacklist")
+	blFormat := flag.String("blacklist-format", "auto",
+		"DNS blacklist format: auto, glob, hosts, adblock, dnsmasq, or rpz")
+	al := flag.String("allowlist", "",
+		"DNS allowlist overriding the blacklist rules")
+	subscribe := flag.String("subscribe", "",
+		"Comma-separated list of DNS blacklist URLs")
+	subInterval := flag.Duration("subscribe-interval",
+		dns.DefaultRefreshInterval, "DNS blacklist subscription refresh interval")
+	subCache := flag.String("subscribe-cache", defaultSubscriptionCache(),
+		"DNS blacklist subscription cache directory")
+	block := flag.String("block", "nxdomain",
+		"Block response: nxdomain, nodata, refused, null, drop, or "+
+			"sinkhole IPs")
+	blockTTL := flag.Uint("block-ttl", dns.DefaultBlockTTL,
+		"TTL of the null IP and sinkhole block answers")
 	doh := flag.String("doh", "", "DNS-over-HTTPS URL")
 	dohProxy := flag.String("doh-proxy", "", "DNS-over-HTTPS proxy URL")
 	encrypt := flag.Bool("encrypt", true,
 		"Encrypt DNS-over-HTTPS proxy requests")
+	dot := flag.String("dot", "", "DNS-over-TLS server host[:port]")
+	dotName := flag.String("dot-name", "",
+		"DNS-over-TLS server authentication name (default to server host)")
+	dotPins := flag.String("dot-pin", "",
+		"Comma separated list of base64 SHA-256 SPKI pins for DNS-over-TLS")
 	srv := flag.String("dns", "", "DNS server to use (default to system DNS)")
+	upstreams := flag.String("upstream", "",
+		"Comma separated list of upstream DNS servers: host[:port], "+
+			"https://URL, tls://host[:port][#name]")
+	forward := flag.String("forward", "", "Conditional forwarding rules")
+	hosts := flag.String("hosts", "", "Local records in hosts file format")
+	records := flag.String("records", "", "Local records file")
+	localTTL := flag.Uint("local-ttl", dns.DefaultLocalTTL,
+		"TTL of the local records")
+	strategy := flag.String("strategy", "order",
+		"Upstream selection strategy: order, latency, roundrobin")
+	timeout := flag.Duration("timeout", dns.DefaultTimeout,
+		"Upstream query timeout")
+	retries := flag.Int("retries", dns.DefaultRetries,
+		"Number of upstream query retries")
+	deadline := flag.Duration("deadline", dns.DefaultDeadline,
+		"Query deadline after which the query fails with SERVFAIL")
+	rebind := flag.Bool("rebind", false,
+		"Filter private addresses from upstream answers")
+	rebindNets := flag.String("rebind-networks", "",
+		"Comma-separated list of private networks (default RFC 1918, "+
+			"loopback, link-local, and ULA)")
+	rebindAllow := flag.String("rebind-allow", "",
+		"Comma-separated list of names that can resolve to private addresses")
+	rebindRefuse := flag.Bool("rebind-refuse", false,
+		"Refuse answers with private addresses instead of filtering them")
+	rateClient := flag.String("ratelimit-client", "",
+		"Query rate limit per client: RATE[/BURST] in queries per second")
+	rateDomain := flag.String("ratelimit-domain", "",
+		"Query rate limit per registrable domain: RATE[/BURST] in "+
+			"queries per second")
+	rateInFlight := flag.Int("ratelimit-inflight", 0,
+		"Maximum number of in-flight upstream queries, 0 is unlimited")
+	rateDrop := flag.Bool("ratelimit-drop", false,
+		"Drop over-limit queries instead of refusing them")
+	dnssec := flag.Bool("dnssec", false, "Validate DNSSEC signatures")
 	nopad := flag.Bool("nopad", false, "Do not PAD DoH requests")
+	edns := flag.String("edns", "",
+		"EDNS option policy: none, noecs, privacy (default privacy for "+
+			"encrypted upstreams)")
+	ecs := flag.String("ecs", "",
+		"Client subnet to send to upstreams, e.g. 198.51.100.0/24")
+	cacheSize := flag.Int("cache", 4096,
+		"DNS cache size in responses, 0 disables caching")
+	stale := flag.Duration("stale", 0,
+		"Serve expired cache entries for the duration if upstreams fail")
+	prefetch := flag.Int("prefetch", 0,
+		"Prefetch cache entries with at least this many hits, 0 disables")
+	prefetchThreshold := flag.Float64("prefetch-threshold",
+		dns.DefaultPrefetchThreshold,
+		"Prefetch when the remaining TTL drops below this fraction")
+	queryLog := flag.String("querylog", "", "JSON query log file")
+	queryLogSize := flag.Int64("querylog-size", dns.DefaultLogMaxSize,
+		"Query log rotation size in bytes")
+	queryLogAge := flag.Duration("querylog-age", dns.DefaultLogMaxAge,
+		"Query log rotation age")
+	queryLogBackups := flag.Int("querylog-backups", dns.DefaultLogMaxBackups,
+		"Number of rotated query logs to keep")
+	dnstapFile := flag.String("dnstap", "", "dnstap output file")
+	dnstapSocket := flag.String("dnstap-socket", "",
+		"dnstap output unix socket")
+	metricsAddr := flag.String("metrics", "",
+		"Prometheus metrics listen address, e.g. 127.0.0.1:9153")
 	interactive := flag.Bool("i", false, "Interactive mode")
 	flag.IntVar(&verbose, "v", 0, "Verbose output")
 	flag.Parse()
@@ -65,15 +151,34 @@ func main() {
 	if *interactive {
 		verbose = 0
 	}
+	upstreamStrategy, err := dns.ParseStrategy(*strategy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	blockResponse, err := dns.ParseBlockResponse(*block)
+	if err != nil {
+		log.Fatal(err)
+	}
+	blockResponse.TTL = uint32(*blockTTL)
 
-	var blacklist []dns.Labels
-	var err error
+	var listFiles []dns.ListFile
 
 	if len(*bl) > 0 {
-		blacklist, err = dns.ReadBlacklist(*bl)
+		format, err := dns.ParseListFormat(*blFormat)
 		if err != nil {
 			log.Fatal(err)
 		}
+		listFiles = append(listFiles, dns.ListFile{
+			Name:   *bl,
+			Format: format,
+		})
+	}
+	if len(*al) > 0 {
+		listFiles = append(listFiles, dns.ListFile{
+			Name:   *al,
+			Format: dns.FormatGlob,
+			Allow:  true,
+		})
 	}
 
 	origServers, err = dns.GetServers()
@@ -84,12 +189,25 @@ func main() {
 
 	ifmonC := make(chan bool)
 
+	var servers []string
 	if len(*srv) == 0 {
 		if len(origServers) == 0 {
 			log.Fatal("DNS server not set and could not get system DNS\n")
 		}
-		*srv = origServers[0]
+		servers = makeDNSAddrs(origServers)
 		go listenInterfaceChanges(ifmonC)
+	} else {
+		servers = []string{makeDNSAddr(*srv)}
+	}
+
+	// Fail on invalid metrics address before the system DNS servers
+	// are changed.
+	var metricsListener net.Listener
+	if len(*metricsAddr) > 0 {
+		metricsListener, err = net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			log.Fatalf("metrics: %s", err)
+		}
 	}
 
 	tunnel, err = tun.Create()
@@ -105,16 +223,60 @@ func main() {
 		log.Fatal(err)
 	}
 
-	proxyAddr := makeDNSAddr(*srv)
-
-	fmt.Printf("Starting proxy with DNS server %s\n", proxyAddr)
+	fmt.Printf("Starting proxy with DNS servers %v\n", servers)
 
-	proxy, err = dns.NewProxy(proxyAddr, tunnel)
+	proxy, err = dns.NewProxy(servers, tunnelWriter{tunnel})
 	if err != nil {
 		log.Fatal(err)
 	}
 	proxy.Verbose = verbose
-	proxy.Blacklist = blacklist
+	proxy.Block = blockResponse
+	proxy.Timeout = *timeout
+	proxy.Retries = *retries
+	proxy.Deadline = *deadline
+	if *cacheSize > 0 {
+		proxy.Cache = dns.NewCache(*cacheSize)
+		proxy.Cache.Stale = *stale
+		proxy.Cache.PrefetchHits = *prefetch
+		proxy.Cache.PrefetchThreshold = *prefetchThreshold
+	}
+	if len(*queryLog) > 0 {
+		proxy.QueryLog, err = dns.NewQueryLog(*queryLog)
+		if err != nil {
+			log.Fatal(err)
+		}
+		proxy.QueryLog.MaxSize = *queryLogSize
+		proxy.QueryLog.MaxAge = *queryLogAge
+		proxy.QueryLog.MaxBackups = *queryLogBackups
+	}
+	if len(*dnstapFile) > 0 || len(*dnstapSocket) > 0 {
+		if len(*dnstapSocket) > 0 {
+			proxy.Dnstap, err = dns.NewDnstapSocket(*dnstapSocket)
+		} else {
+			proxy.Dnstap, err = dns.NewDnstapFile(*dnstapFile)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		hostname, err := os.Hostname()
+		if err == nil {
+			proxy.Dnstap.Identity = []byte(hostname)
+		}
+		proxy.Dnstap.Version = []byte("vpn")
+	}
+	if metricsListener != nil {
+		proxy.RegisterMetrics()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Default)
+		go func() {
+			err := http.Serve(metricsListener, mux)
+			if err != nil {
+				log.Printf("metrics: %s", err)
+			}
+		}()
+	}
+
+	pool := dns.NewPool(upstreamStrategy)
 
 	if len(*doh) > 0 {
 		var oauth2Client *auth.OAuth2Client
@@ -131,9 +293,117 @@ func main() {
 			log.Fatal(err)
 		}
 		doh.Encrypt = *encrypt
-		proxy.DoH = doh
+		pool.Upstreams = append(pool.Upstreams, dns.NewDoHUpstream(doh))
+	}
+	if len(*dot) > 0 {
+		dot, err := dns.NewDoTClient(*dot, *dotName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(*dotPins) > 0 {
+			for _, pin := range strings.Split(*dotPins, ",") {
+				err = dot.AddPin(strings.TrimSpace(pin))
+				if err != nil {
+					log.Fatalf("Invalid DoT pin '%s': %s", pin, err)
+				}
+			}
+		}
+		fmt.Printf("DoT server: %s\n", dot)
+		pool.Upstreams = append(pool.Upstreams, dns.NewDoTUpstream(dot))
+	}
+	if len(*upstreams) > 0 {
+		for _, spec := range strings.Split(*upstreams, ",") {
+			u, err := dns.ParseUpstream(strings.TrimSpace(spec))
+			if err != nil {
+				log.Fatalf("Invalid upstream '%s': %s", spec, err)
+			}
+			pool.Upstreams = append(pool.Upstreams, u)
+		}
+	}
+	if len(pool.Upstreams) > 0 {
+		fmt.Printf("Upstreams (%s):\n", pool.Strategy)
+		for _, u := range pool.Upstreams {
+			fmt.Printf(" - %s\n", u)
+		}
+		err = proxy.SetPool(pool)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(*forward) > 0 {
+		forwarding, err := dns.ReadForwarding(*forward)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = proxy.SetForwarding(forwarding)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(*hosts) > 0 || len(*records) > 0 {
+		local := dns.NewLocalRecords()
+		if len(*hosts) > 0 {
+			err = local.ReadHosts(*hosts, uint32(*localTTL))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if len(*records) > 0 {
+			err = local.ReadRecords(*records, uint32(*localTTL))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		fmt.Printf("Local records: %d\n", local.Len())
+		proxy.Local = local
+	}
+	if *rebind {
+		var allow []string
+		if len(*rebindAllow) > 0 {
+			allow = strings.Split(*rebindAllow, ",")
+		}
+		rebinding := dns.NewRebinding(allow...)
+		if len(*rebindNets) > 0 {
+			rebinding.Networks, err = dns.ParseNetworks(*rebindNets)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		rebinding.Refuse = *rebindRefuse
+		proxy.Rebinding = rebinding
+	}
+	if len(*rateClient) > 0 || len(*rateDomain) > 0 || *rateInFlight > 0 {
+		var client, domain dns.Rate
+		if len(*rateClient) > 0 {
+			client, err = dns.ParseRate(*rateClient)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if len(*rateDomain) > 0 {
+			domain, err = dns.ParseRate(*rateDomain)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		proxy.RateLimit = dns.NewRateLimiter(client, domain)
+		proxy.RateLimit.InFlight = *rateInFlight
+		proxy.RateLimit.Drop = *rateDrop
+	}
+	if *dnssec {
+		proxy.Validator = dns.NewValidator(proxy.Resolve)
 	}
 	proxy.NoPad = *nopad
+	if len(*edns) > 0 || len(*ecs) > 0 {
+		profile := *edns
+		if len(profile) == 0 {
+			profile = dns.EDNSPrivacy.Name
+		}
+		proxy.EDNS, err = dns.NewEDNSPolicy(profile, *ecs)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	signalC := make(chan os.Signal, 1)
 
@@ -145,10 +415,39 @@ func main() {
 
 		eventC <- dns.Event{
 			Type:   dns.EventConfig,
-			Labels: []string{proxyAddr},
+			Labels: []string{strings.Join(servers, ",")},
 		}
 	}
 
+	watcher := dns.NewListWatcher(proxy, listFiles...)
+	if len(*subscribe) > 0 {
+		for _, u := range strings.Split(*subscribe, ",") {
+			sub, err := dns.NewSubscription(strings.TrimSpace(u),
+				dns.FormatAuto, *subCache)
+			if err != nil {
+				log.Fatalf("Invalid subscription '%s': %s", u, err)
+			}
+			err = watcher.Subscribe(sub)
+			if err != nil {
+				log.Fatalf("Invalid subscription '%s': %s", u, err)
+			}
+		}
+	}
+	if len(listFiles) > 0 || len(watcher.Subscriptions) > 0 {
+		err = watcher.Reload()
+		if err != nil {
+			var listErr *dns.ListError
+			if !errors.As(err, &listErr) {
+				log.Fatal(err)
+			}
+			log.Printf("%s", err)
+		}
+		watcher.Watch(dns.ListWatchInterval)
+		watcher.Refresh(*subInterval)
+	}
+	hupC := make(chan os.Signal, 1)
+	signal.Notify(hupC, syscall.SIGHUP)
+
 	fmt.Printf("Setting proxy DNS server\n")
 	err = dns.SetServers([]string{"192.168.192.254"})
 	if err != nil {
@@ -178,9 +477,22 @@ func main() {
 		case s := <-signalC:
 			cli.Reset()
 			dns.RestoreServers(origServers)
+			if proxy.QueryLog != nil {
+				proxy.QueryLog.Close()
+			}
+			if proxy.Dnstap != nil {
+				proxy.Dnstap.Close()
+			}
 			fmt.Println("signal", s)
 			os.Exit(0)
 
+		case <-hupC:
+			log.Printf("reloading blacklists")
+			err = watcher.Reload()
+			if err != nil {
+				log.Printf("blacklist reload: %s", err)
+			}
+
 		case <-ifmonC:
 			log.Printf("interface change")
 			dns.RestoreServers(origServers)
@@ -202,12 +514,12 @@ func main() {
 			}
 
 			if len(origServers) > 0 {
-				proxyAddr := makeDNSAddr(origServers[0])
-				log.Printf("DNS server: %v", proxyAddr)
-				err = proxy.SetServer(proxyAddr)
+				servers := makeDNSAddrs(origServers)
+				log.Printf("DNS servers: %v", servers)
+				err = proxy.SetServers(servers)
 				if err != nil {
-					log.Printf("failed to set DNS server %v: %v",
-						proxyAddr, err)
+					log.Printf("failed to set DNS servers %v: %v",
+						servers, err)
 				}
 			}
 
@@ -221,6 +533,8 @@ func main() {
 }
 
 func handlePacket(data []byte) error {
+	countPacket("in", data)
+
 	// Check IP version.
 	var firstLayerDecoder gopacket.Decoder
 	version := data[0] >> 4
@@ -248,7 +562,7 @@ func handlePacket(data []byte) error {
 			return err
 		}
 		if response != nil {
-			_, err = tunnel.Write(response)
+			_, err = tunnelWriter{tunnel}.Write(response)
 			if err != nil {
 				return err
 			}
@@ -283,6 +597,36 @@ func handlePacket(data []byte) error {
 	return nil
 }
 
+var (
+	metricPackets = metrics.NewCounter("vpn_tunnel_packets_total",
+		"Tunnel packets by direction and IP protocol.", "direction",
+		"protocol")
+	metricBytes = metrics.NewCounter("vpn_tunnel_bytes_total",
+		"Tunnel bytes by direction and IP protocol.", "direction",
+		"protocol")
+)
+
+// tunnelWriter counts the packets written to the tunnel.
+type tunnelWriter struct {
+	t *tun.Tunnel
+}
+
+func (w tunnelWriter) Write(data []byte) (int, error) {
+	countPacket("out", data)
+	return w.t.Write(data)
+}
+
+func countPacket(direction string, data []byte) {
+	protocol := "other"
+	if len(data) >= 20 && data[0]>>4 == 4 {
+		protocol = ip.Protocol(data[9]).String()
+	} else if len(data) >= 40 && data[0]>>4 == 6 {
+		protocol = ip.Protocol(data[6]).String()
+	}
+	metricPackets.Inc(direction, protocol)
+	metricBytes.Add(float64(len(data)), direction, protocol)
+}
+
 func readProxyConfig() (*ProxyConfig, error) {
 	dir, err := os.UserHomeDir()
 	if err != nil {
@@ -321,6 +665,14 @@ func listenInterfaceChanges(c chan bool) {
 	}
 }
 
+func makeDNSAddrs(servers []string) []string {
+	var result []string
+	for _, server := range servers {
+		result = append(result, makeDNSAddr(server))
+	}
+	return result
+}
+
 func makeDNSAddr(server string) string {
 	proxyIP := net.ParseIP(server)
 	switch len(proxyIP) {
@@ -335,3 +687,11 @@ func makeDNSAddr(server string) string {
 		return ""
 	}
 }
+
+func defaultSubscriptionCache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return path.Join(dir, "vpn", "blacklists")
+}
@@ -0,0 +1,380 @@
+//
+// metrics.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+// Prometheus metrics in the text exposition format.
+//
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType defines the content type of the text exposition
+// format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets define the default histogram buckets in seconds.
+var DefaultBuckets = []float64{
+	.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5,
+}
+
+// Default is the default registry.
+var Default = NewRegistry()
+
+// Registry holds the registered metrics.
+type Registry struct {
+	m       sync.Mutex
+	metrics map[string]metric
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry creates a new metrics registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+	}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	_, ok := r.metrics[name]
+	if ok {
+		panic(fmt.Sprintf("metrics: duplicate metric %s", name))
+	}
+	r.metrics[name] = m
+}
+
+// WriteTo writes the metrics in the text exposition format.
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	r.m.Lock()
+	var names []string
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var metrics []metric
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.m.Unlock()
+
+	cw := &countWriter{
+		w: out,
+	}
+	w := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(w)
+	}
+	err := w.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name,
+		strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// sample writes a sample line. The extra label, if set, is appended
+// to the labels of the series.
+func (d *desc) sample(w *bufio.Writer, suffix string, values []string,
+	extra, extraValue string, v float64) {
+
+	w.WriteString(d.name)
+	w.WriteString(suffix)
+	if len(values) > 0 || len(extra) > 0 {
+		w.WriteByte('{')
+		for i, label := range d.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, label, values[i])
+		}
+		if len(extra) > 0 {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extra, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func writeLabel(w *bufio.Writer, label, value string) {
+	w.WriteString(label)
+	w.WriteString(`="`)
+	w.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).
+		Replace(value))
+	w.WriteByte('"')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// series returns the series key for the label values.
+func (d *desc) series(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s: got %d label values, expected %d",
+			d.name, len(values), len(d.labels)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys returns the series keys in the sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func splitKey(key string, labels []string) []string {
+	if len(labels) == 0 {
+		return nil
+	}
+	return strings.Split(key, "\xff")
+}
+
+// Counter implements a monotonically increasing counter with
+// optional labels.
+type Counter struct {
+	desc
+	m      sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter creates a new counter and registers it to the default
+// registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc: desc{
+			name:   name,
+			help:   help,
+			kind:   "counter",
+			labels: labels,
+		},
+		values: make(map[string]float64),
+	}
+	Default.register(name, c)
+	return c
+}
+
+// Inc increments the counter of the label values by one.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter of the label values.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: %s: negative increment", c.name))
+	}
+	key := c.series(values)
+
+	c.m.Lock()
+	c.values[key] += v
+	c.m.Unlock()
+}
+
+// Value returns the counter value of the label values.
+func (c *Counter) Value(values ...string) float64 {
+	key := c.series(values)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.header(w)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		c.sample(w, "", nil, "", "", 0)
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		c.sample(w, "", splitKey(key, c.labels), "", "", c.values[key])
+	}
+}
+
+// GaugeFunc implements a gauge that reads its value from a function.
+type GaugeFunc struct {
+	desc
+	f func() float64
+}
+
+// NewGaugeFunc creates a new gauge function and registers it to the
+// default registry.
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{
+		desc: desc{
+			name: name,
+			help: help,
+			kind: "gauge",
+		},
+		f: f,
+	}
+	Default.register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.header(w)
+	g.sample(w, "", nil, "", "", g.f())
+}
+
+// Histogram implements a histogram with cumulative buckets and
+// optional labels.
+type Histogram struct {
+	desc
+	buckets []float64
+	m       sync.Mutex
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a new histogram and registers it to the
+// default registry. The buckets must be in the increasing order.
+func NewHistogram(name, help string, buckets []float64,
+	labels ...string) *Histogram {
+
+	h := &Histogram{
+		desc: desc{
+			name:   name,
+			help:   help,
+			kind:   "histogram",
+			labels: labels,
+		},
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+	Default.register(name, h)
+	return h
+}
+
+// Observe adds the observation v to the histogram of the label
+// values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := h.series(values)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	s, ok := h.values[key]
+	if !ok {
+		s = &histogram{
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Count returns the number of observations of the label values.
+func (h *Histogram) Count(values ...string) uint64 {
+	key := h.series(values)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	s, ok := h.values[key]
+	if !ok {
+		return 0
+	}
+	return s.count
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.header(w)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for _, key := range sortedKeys(h.values) {
+		values := splitKey(key, h.labels)
+		s := h.values[key]
+		for i, upper := range h.buckets {
+			h.sample(w, "_bucket", values, "le", formatFloat(upper),
+				float64(s.counts[i]))
+		}
+		h.sample(w, "_bucket", values, "le", "+Inf", float64(s.count))
+		h.sample(w, "_sum", values, "", "", s.sum)
+		h.sample(w, "_count", values, "", "", float64(s.count))
+	}
+}
@@ -0,0 +1,106 @@
+//
+// metrics_test.go
+//
+// Copyright (c) 2026 Markku Rossi
+//
+// All rights reserved.
+//
+
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var (
+	testCounter = NewCounter("test_requests_total", "Test requests.",
+		"code", "path")
+	testTotal     = NewCounter("test_total", "Test total.\nSecond line.")
+	testHistogram = NewHistogram("test_latency_seconds", "Test latency.",
+		[]float64{0.1, 1}, "transport")
+	_ = NewGaugeFunc("test_gauge", "Test gauge.", func() float64 {
+		return 3.5
+	})
+)
+
+func TestMetrics(t *testing.T) {
+	testCounter.Inc("200", "/a")
+	testCounter.Add(2, "200", "/a")
+	testCounter.Inc("404", `/"b"`)
+	testHistogram.Observe(0.05, "udp")
+	testHistogram.Observe(0.5, "udp")
+	testHistogram.Observe(2, "udp")
+
+	if v := testCounter.Value("200", "/a"); v != 3 {
+		t.Errorf("counter value %v, expected 3", v)
+	}
+	if c := testHistogram.Count("udp"); c != 3 {
+		t.Errorf("histogram count %v, expected 3", c)
+	}
+
+	var buf bytes.Buffer
+	_, err := Default.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge 3.5
+# HELP test_latency_seconds Test latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{transport="udp",le="0.1"} 1
+test_latency_seconds_bucket{transport="udp",le="1"} 2
+test_latency_seconds_bucket{transport="udp",le="+Inf"} 3
+test_latency_seconds_sum{transport="udp"} 2.55
+test_latency_seconds_count{transport="udp"} 3
+# HELP test_requests_total Test requests.
+# TYPE test_requests_total counter
+test_requests_total{code="200",path="/a"} 3
+test_requests_total{code="404",path="/\"b\""} 1
+# HELP test_total Test total.\nSecond line.
+# TYPE test_total counter
+test_total 0
+`
+	if buf.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	server := httptest.NewServer(Default)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %s", resp.Status)
+	}
+	if resp.Header.Get("Content-Type") != ContentType {
+		t.Errorf("got content type %s", resp.Header.Get("Content-Type"))
+	}
+
+	resp, err = http.Post(server.URL, "text/plain", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST got status %s", resp.Status)
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("invalid label count accepted")
+		}
+	}()
+	testCounter.Inc("200")
+}